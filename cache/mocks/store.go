@@ -3,6 +3,7 @@
 package mocks
 
 import (
+	cache "application_metadata_api_server/cache"
 	api "application_metadata_api_server/server/api"
 
 	mock "github.com/stretchr/testify/mock"
@@ -34,6 +35,29 @@ func (_m *Store) Add(app *api.App, raw []byte) (api.Id, error) {
 	return r0, r1
 }
 
+// AddBatch provides a mock function with given fields: apps, raws
+func (_m *Store) AddBatch(apps []*api.App, raws [][]byte) ([]api.Id, error) {
+	ret := _m.Called(apps, raws)
+
+	var r0 []api.Id
+	if rf, ok := ret.Get(0).(func([]*api.App, [][]byte) []api.Id); ok {
+		r0 = rf(apps, raws)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]api.Id)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]*api.App, [][]byte) error); ok {
+		r1 = rf(apps, raws)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Get provides a mock function with given fields: id
 func (_m *Store) Get(id api.Id) ([]byte, error) {
 	ret := _m.Called(id)
@@ -57,6 +81,124 @@ func (_m *Store) Get(id api.Id) ([]byte, error) {
 	return r0, r1
 }
 
+// Snapshot provides a mock function with given fields:
+func (_m *Store) Snapshot() []cache.Document {
+	ret := _m.Called()
+
+	var r0 []cache.Document
+	if rf, ok := ret.Get(0).(func() []cache.Document); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]cache.Document)
+		}
+	}
+
+	return r0
+}
+
+// Stats provides a mock function with given fields:
+func (_m *Store) Stats() cache.Stats {
+	ret := _m.Called()
+
+	var r0 cache.Stats
+	if rf, ok := ret.Get(0).(func() cache.Stats); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(cache.Stats)
+	}
+
+	return r0
+}
+
+// Update provides a mock function with given fields: id, app, raw
+func (_m *Store) Update(id api.Id, app *api.App, raw []byte) error {
+	ret := _m.Called(id, app, raw)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(api.Id, *api.App, []byte) error); ok {
+		r0 = rf(id, app, raw)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Begin provides a mock function with given fields:
+func (_m *Store) Begin() cache.Txn {
+	ret := _m.Called()
+
+	var r0 cache.Txn
+	if rf, ok := ret.Get(0).(func() cache.Txn); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(cache.Txn)
+		}
+	}
+
+	return r0
+}
+
+// Check provides a mock function with given fields:
+func (_m *Store) Check() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Delete provides a mock function with given fields: id
+func (_m *Store) Delete(id api.Id) error {
+	ret := _m.Called(id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(api.Id) error); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// List provides a mock function with given fields:
+func (_m *Store) List() []api.Id {
+	ret := _m.Called()
+
+	var r0 []api.Id
+	if rf, ok := ret.Get(0).(func() []api.Id); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]api.Id)
+		}
+	}
+
+	return r0
+}
+
+// Revision provides a mock function with given fields:
+func (_m *Store) Revision() int64 {
+	ret := _m.Called()
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func() int64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	return r0
+}
+
 // Search provides a mock function with given fields: value, fields
 func (_m *Store) Search(value string, fields ...string) []api.Id {
 	_va := make([]interface{}, len(fields))
@@ -102,3 +244,28 @@ func (_m *Store) SearchStruct(app *api.App) ([]api.Id, error) {
 
 	return r0, r1
 }
+
+// Watch provides a mock function with given fields:
+func (_m *Store) Watch() (<-chan cache.Event, func()) {
+	ret := _m.Called()
+
+	var r0 <-chan cache.Event
+	if rf, ok := ret.Get(0).(func() <-chan cache.Event); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan cache.Event)
+		}
+	}
+
+	var r1 func()
+	if rf, ok := ret.Get(1).(func() func()); ok {
+		r1 = rf()
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(func())
+		}
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultNamespace is the namespace of the requests not naming one
+const DefaultNamespace = "default"
+
+// Namespaces holds an isolated Store per namespace, each with its own search space and Ids
+type Namespaces interface {
+	// Store returns the Store of a namespace, created empty on first use
+	Store(namespace string) Store
+	// Lookup returns the Store of a namespace, false when the namespace was never used
+	Lookup(namespace string) (Store, bool)
+	// List returns the names of the namespaces in use, sorted
+	List() []string
+}
+
+type namespacesImpl struct {
+	rwLock sync.RWMutex
+	stores map[string]Store
+}
+
+// InitNamespaces returns Namespaces whose Stores are in-memory stores
+func InitNamespaces() Namespaces {
+	return NewNamespaces(InitStore())
+}
+
+// NewNamespaces returns Namespaces with defaultStore as the Store of DefaultNamespace, the other namespaces
+// get in-memory stores
+func NewNamespaces(defaultStore Store) Namespaces {
+	return &namespacesImpl{stores: map[string]Store{DefaultNamespace: defaultStore}}
+}
+
+func (n *namespacesImpl) Store(namespace string) Store {
+	n.rwLock.RLock()
+	store, ok := n.stores[namespace]
+	n.rwLock.RUnlock()
+	if ok {
+		return store
+	}
+
+	n.rwLock.Lock()
+	defer n.rwLock.Unlock()
+	// created meanwhile
+	if store, ok := n.stores[namespace]; ok {
+		return store
+	}
+	store = InitStore()
+	n.stores[namespace] = store
+	return store
+}
+
+func (n *namespacesImpl) Lookup(namespace string) (Store, bool) {
+	n.rwLock.RLock()
+	defer n.rwLock.RUnlock()
+	store, ok := n.stores[namespace]
+	return store, ok
+}
+
+func (n *namespacesImpl) List() []string {
+	n.rwLock.RLock()
+	defer n.rwLock.RUnlock()
+
+	rs := make([]string, 0, len(n.stores))
+	for namespace := range n.stores {
+		rs = append(rs, namespace)
+	}
+	sort.Strings(rs)
+	return rs
+}
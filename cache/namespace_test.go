@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"application_metadata_api_server/server/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNamespacesImpl(t *testing.T) {
+	defaultStore := InitStore()
+	namespaces := NewNamespaces(defaultStore)
+	assert.Equal(t, defaultStore, namespaces.Store(DefaultNamespace))
+	assert.Equal(t, []string{DefaultNamespace}, namespaces.List())
+	_, ok := namespaces.Lookup("payments")
+	assert.False(t, ok)
+	assert.Equal(t, []string{DefaultNamespace}, namespaces.List())
+
+	payments := namespaces.Store("payments")
+	assert.Equal(t, payments, namespaces.Store("payments"))
+	assert.Equal(t, []string{DefaultNamespace, "payments"}, namespaces.List())
+	store, ok := namespaces.Lookup("payments")
+	assert.True(t, ok)
+	assert.Equal(t, payments, store)
+
+	// ids and search spaces are per namespace
+	id, err := defaultStore.Add(&api.App{Title: "t0"}, []byte("title: t0"))
+	assert.Nil(t, err)
+	assert.Equal(t, api.Id("1"), id)
+	id, err = payments.Add(&api.App{Title: "t1"}, []byte("title: t1"))
+	assert.Nil(t, err)
+	assert.Equal(t, api.Id("1"), id)
+	raw, err := payments.Get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("title: t1"), raw)
+	assert.Equal(t, []api.Id{}, payments.Search("t0", "title"))
+	assert.Equal(t, []api.Id{"1"}, defaultStore.Search("t0", "title"))
+}
@@ -53,6 +53,73 @@ func (p *TreeNode) addNode(appId api.Id, unstructured map[string]interface{}) {
 	}
 }
 
+// removeNode removes appId from every value it was added with by addNode, unstructured must be the same object
+func (p *TreeNode) removeNode(appId api.Id, unstructured map[string]interface{}) {
+	for k, obj := range unstructured {
+		child, ok := p.children[k]
+		if !ok || obj == nil {
+			continue
+		}
+		value := reflect.ValueOf(obj)
+		kind := value.Type().Kind()
+		switch kind {
+		case reflect.String:
+			child.data.Remove(appId, value.String())
+		case reflect.Slice:
+			for i := 0; i < value.Len(); i++ {
+				m := value.Index(i).Interface().(map[string]interface{})
+				child.removeNode(appId, m)
+			}
+		case reflect.Map:
+			child.removeNode(appId, value.Interface().(map[string]interface{}))
+		}
+	}
+}
+
+// nodeAt returns the node at the given field path, creating the missing nodes
+func (p *TreeNode) nodeAt(fields []string) *TreeNode {
+	node := p
+	for _, field := range fields {
+		child, ok := node.children[field]
+		if !ok {
+			child = newTreeNode(field)
+			node.children[field] = child
+		}
+		node = child
+	}
+	return node
+}
+
+// lookup returns the node at the given field path, nil when it does not exist
+func (p *TreeNode) lookup(fields []string) *TreeNode {
+	node := p
+	for _, field := range fields {
+		child, ok := node.children[field]
+		if !ok {
+			return nil
+		}
+		node = child
+	}
+	return node
+}
+
+// check verifies every Id in the inverted indexes of the node and its children is an indexed App
+func (p *TreeNode) check(indexed map[api.Id]map[string]interface{}, fields []string) error {
+	for key, ref := range p.data {
+		for _, id := range ref {
+			if _, ok := indexed[id]; !ok {
+				return fmt.Errorf("%s %q refers to app %s, which is not stored", strings.Join(fields, "."), key, id)
+			}
+		}
+	}
+	for _, child := range p.children {
+		if err := child.check(indexed, append(fields[:len(fields):len(fields)], child.key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // InvertedIndex represents an index data structure storing a mapping from content
 // lowercase words to its Id in a document or a set of documents
 type InvertedIndex map[string][]api.Id
@@ -86,6 +153,51 @@ func (x *InvertedIndex) Add(appId api.Id, value string) {
 	}
 }
 
+// Remove removes appId from a str and its tokenized words, the words left without any Id are deleted
+func (x *InvertedIndex) Remove(appId api.Id, value string) {
+	value = getLowercase(value)
+	words := strings.Fields(value)
+	if len(words) > 1 {
+		x.removeRef(appId, value)
+	}
+	for _, word := range words {
+		x.removeRef(appId, word)
+	}
+}
+
+func (x *InvertedIndex) removeRef(appId api.Id, key string) {
+	ref, ok := (*x)[key]
+	if !ok {
+		return
+	}
+	rs := make([]api.Id, 0, len(ref))
+	for _, id := range ref {
+		if id != appId {
+			rs = append(rs, id)
+		}
+	}
+	if len(rs) == 0 {
+		delete(*x, key)
+		return
+	}
+	(*x)[key] = rs
+}
+
+// contains tells if appId was added with value, under its full value when it has several words and every word
+func (x *InvertedIndex) contains(appId api.Id, value string) bool {
+	value = getLowercase(value)
+	words := strings.Fields(value)
+	if len(words) > 1 && !exist(appId, (*x)[value]) {
+		return false
+	}
+	for _, word := range words {
+		if !exist(appId, (*x)[word]) {
+			return false
+		}
+	}
+	return true
+}
+
 // Search is the plain text search, and query is a plain text. (The nested query is handled in the tree data structure, not here)
 // e.g. if you stored "this is acat", a query string of "this", "is", "acat" returns positive match,
 // however a query string of "this is", "this acat" will result in not found.
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the size of a Store and of the waits for its lock
+type Stats struct {
+	// Apps is the number of Apps
+	Apps int
+	// RawBytes is the size of the raw contents of the Apps
+	RawBytes int64
+	// Terms is the number of terms in the inverted index of every field path of the search space, e.g. "labels.env"
+	Terms map[string]int
+	// ReadLockWaits are the waits of the reads for the lock, WriteLockWaits those of the writes
+	ReadLockWaits  LockWaits
+	WriteLockWaits LockWaits
+}
+
+// LockWaits is the number of times a lock was acquired, and the total time waited to acquire it
+type LockWaits struct {
+	Count int64
+	Total time.Duration
+}
+
+// timedRWMutex is a sync.RWMutex counting the time its lockers wait
+type timedRWMutex struct {
+	sync.RWMutex
+	// the counters are updated atomically, readers hold the lock together
+	readCount  int64
+	readTotal  int64
+	writeCount int64
+	writeTotal int64
+}
+
+func (m *timedRWMutex) Lock() {
+	start := time.Now()
+	m.RWMutex.Lock()
+	atomic.AddInt64(&m.writeCount, 1)
+	atomic.AddInt64(&m.writeTotal, int64(time.Since(start)))
+}
+
+func (m *timedRWMutex) RLock() {
+	start := time.Now()
+	m.RWMutex.RLock()
+	atomic.AddInt64(&m.readCount, 1)
+	atomic.AddInt64(&m.readTotal, int64(time.Since(start)))
+}
+
+func (m *timedRWMutex) waits() (read LockWaits, write LockWaits) {
+	read = LockWaits{Count: atomic.LoadInt64(&m.readCount), Total: time.Duration(atomic.LoadInt64(&m.readTotal))}
+	write = LockWaits{Count: atomic.LoadInt64(&m.writeCount), Total: time.Duration(atomic.LoadInt64(&m.writeTotal))}
+	return read, write
+}
+
+func (t *storeImpl) Stats() Stats {
+	t.rwLock.RLock()
+	rs := Stats{Apps: len(t.rawData), Terms: make(map[string]int)}
+	for _, raw := range t.rawData {
+		rs.RawBytes += int64(len(raw))
+	}
+	t.searchRoot.countTerms(nil, rs.Terms)
+	t.rwLock.RUnlock()
+
+	// read after unlocking, to include the wait of this read
+	rs.ReadLockWaits, rs.WriteLockWaits = t.rwLock.waits()
+	return rs
+}
+
+// countTerms sets the number of terms of the inverted index of the node's children and their descendants,
+// by field path. The field paths of the values (the leaves) are counted even when their index is empty.
+func (p *TreeNode) countTerms(fields []string, rs map[string]int) {
+	for _, child := range p.children {
+		path := append(fields[:len(fields):len(fields)], child.key)
+		if len(child.data) > 0 || len(child.children) == 0 {
+			rs[strings.Join(path, ".")] = len(child.data)
+		}
+		child.countTerms(path, rs)
+	}
+}
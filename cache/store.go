@@ -2,40 +2,85 @@ package cache
 
 import (
 	"application_metadata_api_server/server/api"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
-	"sync"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// ErrNotFound is returned when an App Id is not in the store
+var ErrNotFound = errors.New("not found")
+
 // Store is the interface of the in-memory data store.
 // stores Struct as a tree data structure, tree node = field name of the struct, tree node value = field values
 type Store interface {
 	// Add inserts an App to the in-memory data store
 	Add(app *api.App, raw []byte) (api.Id, error)
+	// AddBatch inserts Apps under a single lock, readers see either none or all of them.
+	// raws holds the raw content of each App.
+	AddBatch(apps []*api.App, raws [][]byte) ([]api.Id, error)
 	// Get gets an App based on its Id
 	Get(id api.Id) ([]byte, error)
+	// Update replaces an existing App, and reindexes the field paths whose values changed
+	Update(id api.Id, app *api.App, raw []byte) error
+	// Delete removes an App from the data store and the search space
+	Delete(id api.Id) error
+	// List returns the Ids of all the Apps, in insertion order
+	List() []api.Id
+	// Snapshot returns the raw content of all the Apps at a single point in time, in insertion order
+	Snapshot() []Document
 	// Search takes a value str and its field or its nested field
 	Search(value string, fields ...string) []api.Id
 	// SearchStruct takes an App struct, and traverse along the struct with store's tree structure
 	SearchStruct(app *api.App) ([]api.Id, error)
+	// Begin starts a transaction, its writes are applied all together on commit
+	Begin() Txn
+	// Revision returns the number of changes of the store, the Revision of its last Event
+	Revision() int64
+	// Stats returns the number and the size of the Apps, the number of terms of the search space
+	// and the waits for the lock of the store
+	Stats() Stats
+	// Check verifies the search space indexes exactly the stored Apps, it returns an error describing the first
+	// inconsistency found
+	Check() error
+	// Watch returns the changes of the store from now on, until cancel is called. The channel is closed
+	// when cancelled, or when the watcher falls too far behind.
+	Watch() (events <-chan Event, cancel func())
+}
+
+// Document is the raw content of a stored App
+type Document struct {
+	Id  api.Id
+	Raw []byte
 }
 
 type storeImpl struct {
-	rwLock sync.RWMutex
+	// rwLock counts the time waited for it, see Stats
+	rwLock timedRWMutex
 	// searchRoot is the App search space
 	searchRoot *TreeNode
 	// rawData contains direct Id to App mapping
 	rawData map[api.Id][]byte
+	// indexed contains the unstructured App added to the search space, to remove it on update or delete
+	indexed map[api.Id]map[string]interface{}
 	// cnt is used to generate auto increment ids : TODO: could use a gen-id service
 	cnt int
+	// revision is the number of changes, it orders the watch events
+	revision int64
+	// watchers receive the changes, keyed by their registration number
+	watchers    map[int]chan Event
+	nextWatcher int
 }
 
 func InitStore() Store {
 	return &storeImpl{
 		searchRoot: newTreeNode(""),
 		rawData:    make(map[api.Id][]byte),
+		indexed:    make(map[api.Id]map[string]interface{}),
+		watchers:   make(map[int]chan Event),
 		cnt:        0}
 }
 
@@ -46,19 +91,42 @@ func (t *storeImpl) Add(app *api.App, rawContent []byte) (api.Id, error) {
 	id := t.cnt + 1
 	app.Id = api.Id(strconv.Itoa(id))
 
-	// 1. add to raw, overwrite if exists
-	t.rawData[app.Id] = rawContent
 	unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(app)
 	if err != nil {
 		return "", err
 	}
-	// 2. add to search space
-	t.searchRoot.addNode(app.Id, unstructuredObj)
+	t.insert(app.Id, unstructuredObj, rawContent)
 	// only increase if add success
 	t.cnt++
 	return app.Id, nil
 }
 
+func (t *storeImpl) AddBatch(apps []*api.App, raws [][]byte) ([]api.Id, error) {
+	if len(apps) != len(raws) {
+		return nil, fmt.Errorf("got %d apps and %d raw contents", len(apps), len(raws))
+	}
+	t.rwLock.Lock()
+	defer t.rwLock.Unlock()
+
+	// convert everything first, so a failure leaves the store unchanged
+	objs := make([]map[string]interface{}, len(apps))
+	for i, app := range apps {
+		app.Id = api.Id(strconv.Itoa(t.cnt + i + 1))
+		unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(app)
+		if err != nil {
+			return nil, err
+		}
+		objs[i] = unstructuredObj
+	}
+	ids := make([]api.Id, len(apps))
+	for i, app := range apps {
+		t.insert(app.Id, objs[i], raws[i])
+		ids[i] = app.Id
+	}
+	t.cnt += len(apps)
+	return ids, nil
+}
+
 func (t *storeImpl) Get(id api.Id) ([]byte, error) {
 	t.rwLock.RLock()
 	defer t.rwLock.RUnlock()
@@ -67,13 +135,113 @@ func (t *storeImpl) Get(id api.Id) ([]byte, error) {
 	if ok {
 		return rawApp, nil
 	}
-	return nil, fmt.Errorf("%v not found", id)
+	return nil, fmt.Errorf("%v %w", id, ErrNotFound)
+}
+
+func (t *storeImpl) Update(id api.Id, app *api.App, rawContent []byte) error {
+	t.rwLock.Lock()
+	defer t.rwLock.Unlock()
+
+	if _, ok := t.indexed[id]; !ok {
+		return fmt.Errorf("%v %w", id, ErrNotFound)
+	}
+	app.Id = id
+	unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(app)
+	if err != nil {
+		return err
+	}
+	t.replace(id, unstructuredObj, rawContent)
+	return nil
+}
+
+func (t *storeImpl) Delete(id api.Id) error {
+	t.rwLock.Lock()
+	defer t.rwLock.Unlock()
+
+	if _, ok := t.indexed[id]; !ok {
+		return fmt.Errorf("%v %w", id, ErrNotFound)
+	}
+	t.remove(id)
+	return nil
+}
+
+// insert adds an App to the data store and the search space, the write lock must be held
+func (t *storeImpl) insert(id api.Id, unstructuredObj map[string]interface{}, rawContent []byte) {
+	// 1. add to raw, overwrite if exists
+	t.rawData[id] = rawContent
+	// 2. add to search space
+	t.searchRoot.addNode(id, unstructuredObj)
+	t.indexed[id] = unstructuredObj
+	t.publish(EventAdded, id, rawContent, nil)
+}
+
+// replace updates an existing App, the write lock must be held
+func (t *storeImpl) replace(id api.Id, unstructuredObj map[string]interface{}, rawContent []byte) {
+	// only touch the nodes of changed field paths, a patch usually changes a few fields
+	removed, added := diffPaths(GetPaths(t.indexed[id]), GetPaths(unstructuredObj))
+	for _, p := range removed {
+		t.searchRoot.nodeAt(p.fields).data.Remove(id, p.value)
+	}
+	for _, p := range added {
+		t.searchRoot.nodeAt(p.fields).data.Add(id, p.value)
+	}
+	previous := t.rawData[id]
+	t.rawData[id] = rawContent
+	t.indexed[id] = unstructuredObj
+	t.publish(EventModified, id, rawContent, previous)
+}
+
+// remove deletes an existing App, the write lock must be held
+func (t *storeImpl) remove(id api.Id) {
+	previous := t.rawData[id]
+	t.searchRoot.removeNode(id, t.indexed[id])
+	delete(t.rawData, id)
+	delete(t.indexed, id)
+	t.publish(EventDeleted, id, nil, previous)
+}
+
+func (t *storeImpl) List() []api.Id {
+	t.rwLock.RLock()
+	defer t.rwLock.RUnlock()
+	return t.sortedIds()
+}
+
+func (t *storeImpl) Snapshot() []Document {
+	t.rwLock.RLock()
+	defer t.rwLock.RUnlock()
+
+	ids := t.sortedIds()
+	rs := make([]Document, len(ids))
+	for i, id := range ids {
+		// the raw contents are replaced on update, never modified, so they can be shared
+		rs[i] = Document{Id: id, Raw: t.rawData[id]}
+	}
+	return rs
+}
+
+// sortedIds returns the Ids of all the Apps in insertion order, the lock must be held
+func (t *storeImpl) sortedIds() []api.Id {
+	rs := make([]api.Id, 0, len(t.rawData))
+	for id := range t.rawData {
+		rs = append(rs, id)
+	}
+	// ids are auto increment numbers
+	sort.Slice(rs, func(i, j int) bool {
+		n1, _ := strconv.Atoi(string(rs[i]))
+		n2, _ := strconv.Atoi(string(rs[j]))
+		return n1 < n2
+	})
+	return rs
 }
 
 func (t *storeImpl) Search(value string, fields ...string) []api.Id {
 	t.rwLock.RLock()
 	defer t.rwLock.RUnlock()
+	return t.search(value, fields...)
+}
 
+// search is Search, the lock must be held
+func (t *storeImpl) search(value string, fields ...string) []api.Id {
 	rs := make([]api.Id, 0)
 	p := t.searchRoot
 	for _, field := range fields {
@@ -102,13 +270,36 @@ func (t *storeImpl) SearchStruct(app *api.App) ([]api.Id, error) {
 	if len(paths) == 0 {
 		return rs, nil
 	}
-	// get the intersection of result from paths
+	// get the intersection of result from paths, all read under the same lock so a commit is never seen halfway
+	t.rwLock.RLock()
+	defer t.rwLock.RUnlock()
 	p1 := paths[0]
-	r1 := t.Search(p1.value, p1.fields...)
+	r1 := t.search(p1.value, p1.fields...)
 	for i := 1; i < len(paths); i++ {
 		p2 := paths[i]
-		r2 := t.Search(p2.value, p2.fields...)
+		r2 := t.search(p2.value, p2.fields...)
 		r1 = intersect(r1, r2)
 	}
 	return r1, nil
 }
+
+func (t *storeImpl) Check() error {
+	t.rwLock.RLock()
+	defer t.rwLock.RUnlock()
+
+	if len(t.rawData) != len(t.indexed) {
+		return fmt.Errorf("%d apps are stored and %d indexed", len(t.rawData), len(t.indexed))
+	}
+	for id, unstructuredObj := range t.indexed {
+		if _, ok := t.rawData[id]; !ok {
+			return fmt.Errorf("app %s is indexed but not stored", id)
+		}
+		for _, p := range GetPaths(unstructuredObj) {
+			node := t.searchRoot.lookup(p.fields)
+			if node == nil || !node.data.contains(id, p.value) {
+				return fmt.Errorf("app %s is not indexed under %s %q", id, strings.Join(p.fields, "."), p.value)
+			}
+		}
+	}
+	return t.searchRoot.check(t.indexed, nil)
+}
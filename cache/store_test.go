@@ -491,3 +491,172 @@ func TestStoreImpl_SearchStruct(t *testing.T) {
 		})
 	}
 }
+
+func TestStoreImpl_UpdateDelete(t *testing.T) {
+	tree := InitStore()
+	apps := []api.App{
+		{
+			Title: "t1 abc",
+			Maintainers: []api.Maintainer{
+				{Name: "bob david"},
+				{Name: "mary"},
+			},
+		},
+		{
+			Title: "t2 abc",
+			Maintainers: []api.Maintainer{
+				{Name: "bob samuel"},
+			},
+		},
+	}
+	for i := range apps {
+		data, err := yaml.Marshal(&apps[i])
+		assert.Nil(t, err)
+		_, err = tree.Add(&apps[i], data)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, []api.Id{"1", "2"}, tree.Search("bob", "maintainers", "name"))
+
+	// update replaces the indexed values of app 1
+	updated := api.App{
+		Title: "t3",
+		Maintainers: []api.Maintainer{
+			{Name: "mary"},
+		},
+	}
+	data, err := yaml.Marshal(&updated)
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Update("1", &updated, data))
+	assert.Equal(t, api.Id("1"), updated.Id)
+	raw, err := tree.Get("1")
+	assert.Nil(t, err)
+	assert.Equal(t, data, raw)
+	assert.Equal(t, []api.Id{"2"}, tree.Search("abc", "title"))
+	assert.Equal(t, []api.Id{"1"}, tree.Search("t3", "title"))
+	assert.Equal(t, []api.Id{"2"}, tree.Search("bob", "maintainers", "name"))
+	assert.Equal(t, []api.Id{}, tree.Search("bob david", "maintainers", "name"))
+	assert.Equal(t, []api.Id{"1"}, tree.Search("mary", "maintainers", "name"))
+
+	// delete removes app 2 from the data store and the search space
+	assert.Nil(t, tree.Delete("2"))
+	_, err = tree.Get("2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, []api.Id{}, tree.Search("abc", "title"))
+	assert.Equal(t, []api.Id{}, tree.Search("bob", "maintainers", "name"))
+
+	// unknown ids
+	assert.ErrorIs(t, tree.Update("2", &updated, data), ErrNotFound)
+	assert.ErrorIs(t, tree.Delete("2"), ErrNotFound)
+
+	// ids are not reused after a delete
+	id, err := tree.Add(&api.App{Title: "t4"}, []byte("title: t4"))
+	assert.Nil(t, err)
+	assert.Equal(t, api.Id("3"), id)
+}
+
+func TestStoreImpl_UpdateReindexesChangedPaths(t *testing.T) {
+	tree := InitStore()
+	app := api.App{
+		Title:       "t1 abc",
+		Maintainers: []api.Maintainer{{Name: "bob david"}, {Name: "bob samuel"}},
+		Labels:      map[string]string{"env": "dev"},
+	}
+	_, err := tree.Add(&app, []byte("t1"))
+	assert.Nil(t, err)
+
+	// a token shared by two values of a field path stays indexed when one of them is removed
+	updated := api.App{
+		Title:       "t1 abc",
+		Maintainers: []api.Maintainer{{Name: "bob david"}},
+		Labels:      map[string]string{"env": "prod", "team": "payments"},
+	}
+	assert.Nil(t, tree.Update("1", &updated, []byte("t2")))
+	assert.Equal(t, []api.Id{"1"}, tree.Search("abc", "title"))
+	assert.Equal(t, []api.Id{"1"}, tree.Search("bob", "maintainers", "name"))
+	assert.Equal(t, []api.Id{}, tree.Search("samuel", "maintainers", "name"))
+	assert.Equal(t, []api.Id{}, tree.Search("dev", "labels", "env"))
+	assert.Equal(t, []api.Id{"1"}, tree.Search("prod", "labels", "env"))
+	assert.Equal(t, []api.Id{"1"}, tree.Search("payments", "labels", "team"))
+}
+
+func TestStoreImpl_AddBatchList(t *testing.T) {
+	tree := InitStore()
+	_, err := tree.Add(&api.App{Title: "t1"}, []byte("title: t1"))
+	assert.Nil(t, err)
+
+	apps := []*api.App{{Title: "t2 abc"}, {Title: "t3 abc"}}
+	ids, err := tree.AddBatch(apps, [][]byte{[]byte("title: t2 abc"), []byte("title: t3 abc")})
+	assert.Nil(t, err)
+	assert.Equal(t, []api.Id{"2", "3"}, ids)
+	assert.Equal(t, api.Id("3"), apps[1].Id)
+	assert.Equal(t, []api.Id{"2", "3"}, tree.Search("abc", "title"))
+	raw, err := tree.Get("3")
+	assert.Nil(t, err)
+	assert.Equal(t, "title: t3 abc", string(raw))
+
+	_, err = tree.AddBatch(apps, nil)
+	assert.NotNil(t, err)
+
+	for i := 0; i < 8; i++ {
+		_, err = tree.Add(&api.App{Title: "t"}, []byte("title: t"))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tree.Delete("2"))
+	assert.Equal(t, []api.Id{"1", "3", "4", "5", "6", "7", "8", "9", "10", "11"}, tree.List())
+}
+
+func TestStoreImpl_Snapshot(t *testing.T) {
+	tree := InitStore()
+	assert.Equal(t, []Document{}, tree.Snapshot())
+	for i := 0; i < 3; i++ {
+		_, err := tree.Add(&api.App{Title: "t"}, []byte(fmt.Sprintf("title: t%d", i+1)))
+		assert.Nil(t, err)
+	}
+	snapshot := tree.Snapshot()
+	assert.Nil(t, tree.Update("1", &api.App{Title: "t"}, []byte("title: t1 updated")))
+	assert.Nil(t, tree.Delete("2"))
+	// the snapshot is not changed by the later writes
+	assert.Equal(t, []Document{{Id: "1", Raw: []byte("title: t1")}, {Id: "2", Raw: []byte("title: t2")}, {Id: "3", Raw: []byte("title: t3")}}, snapshot)
+	assert.Equal(t, []Document{{Id: "1", Raw: []byte("title: t1 updated")}, {Id: "3", Raw: []byte("title: t3")}}, tree.Snapshot())
+}
+
+func TestStoreImpl_Check(t *testing.T) {
+	tree := InitStore()
+	assert.Nil(t, tree.Check())
+	_, err := tree.Add(&api.App{Title: "t1 abc", Maintainers: []api.Maintainer{{Name: "bob"}}}, []byte("t1"))
+	assert.Nil(t, err)
+	_, err = tree.Add(&api.App{Title: "t2", Labels: map[string]string{"env": "dev"}}, []byte("t2"))
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Update("1", &api.App{Title: "t1", Labels: map[string]string{"env": "prod"}}, []byte("t1")))
+	assert.Nil(t, tree.Delete("2"))
+	assert.Nil(t, tree.Check())
+
+	impl := tree.(*storeImpl)
+	impl.searchRoot.nodeAt([]string{"labels", "env"}).data.Add("2", "dev")
+	assert.EqualError(t, tree.Check(), `labels.env "dev" refers to app 2, which is not stored`)
+	impl.searchRoot.nodeAt([]string{"labels", "env"}).data.Remove("2", "dev")
+	impl.searchRoot.nodeAt([]string{"title"}).data.Remove("1", "t1")
+	assert.EqualError(t, tree.Check(), `app 1 is not indexed under title "t1"`)
+	delete(impl.rawData, "1")
+	assert.EqualError(t, tree.Check(), "0 apps are stored and 1 indexed")
+}
+
+func TestStoreImpl_Stats(t *testing.T) {
+	tree := InitStore()
+	_, err := tree.Add(&api.App{Title: "t1 abc", Labels: map[string]string{"env": "dev"}}, []byte("t1"))
+	assert.Nil(t, err)
+	_, err = tree.Add(&api.App{Title: "t2", Maintainers: []api.Maintainer{{Email: "a@b.com"}}}, []byte("t2 abc"))
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Delete("1"))
+
+	stats := tree.Stats()
+	assert.Equal(t, 1, stats.Apps)
+	assert.Equal(t, int64(6), stats.RawBytes)
+	// the terms of the deleted App are removed, its field paths stay
+	assert.Equal(t, 1, stats.Terms["title"])
+	assert.Equal(t, 1, stats.Terms["maintainers.email"])
+	assert.Equal(t, 0, stats.Terms["labels.env"])
+	assert.Equal(t, int64(3), stats.WriteLockWaits.Count)
+	assert.Equal(t, int64(1), stats.ReadLockWaits.Count)
+	assert.Equal(t, int64(2), tree.Stats().ReadLockWaits.Count)
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"application_metadata_api_server/server/api"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ErrTxnDone is returned when a transaction is used after its commit or rollback
+var ErrTxnDone = errors.New("transaction already committed or rolled back")
+
+// errNilApp is returned when an add or an update is staged without App
+var errNilApp = errors.New("app is required")
+
+// Txn is a transaction on the Store. Its writes are staged, and applied on commit under the store lock,
+// so readers either see all of them or none. Txn is not safe for concurrent use.
+type Txn interface {
+	// Add stages the insertion of an App, its Id is assigned on commit
+	Add(app *api.App, raw []byte) error
+	// Update stages the replacement of an existing App
+	Update(id api.Id, app *api.App, raw []byte) error
+	// Delete stages the removal of an existing App
+	Delete(id api.Id) error
+	// Commit applies the staged writes in order, and returns the Ids of the added Apps.
+	// Nothing is applied if any write fails, e.g. with ErrNotFound.
+	Commit() ([]api.Id, error)
+	// Rollback discards the staged writes
+	Rollback() error
+}
+
+type txnOpKind int
+
+const (
+	txnAdd txnOpKind = iota
+	txnUpdate
+	txnDelete
+)
+
+type txnOp struct {
+	kind txnOpKind
+	id   api.Id
+	app  *api.App
+	raw  []byte
+	// unstructuredObj is set on commit, before anything is applied
+	unstructuredObj map[string]interface{}
+}
+
+type txnImpl struct {
+	store *storeImpl
+	ops   []*txnOp
+	done  bool
+}
+
+func (t *storeImpl) Begin() Txn {
+	return &txnImpl{store: t}
+}
+
+func (x *txnImpl) Add(app *api.App, raw []byte) error {
+	if app == nil {
+		return errNilApp
+	}
+	return x.stage(&txnOp{kind: txnAdd, app: app, raw: raw})
+}
+
+func (x *txnImpl) Update(id api.Id, app *api.App, raw []byte) error {
+	if app == nil {
+		return errNilApp
+	}
+	return x.stage(&txnOp{kind: txnUpdate, id: id, app: app, raw: raw})
+}
+
+func (x *txnImpl) Delete(id api.Id) error {
+	return x.stage(&txnOp{kind: txnDelete, id: id})
+}
+
+func (x *txnImpl) stage(op *txnOp) error {
+	if x.done {
+		return ErrTxnDone
+	}
+	x.ops = append(x.ops, op)
+	return nil
+}
+
+func (x *txnImpl) Commit() ([]api.Id, error) {
+	if x.done {
+		return nil, ErrTxnDone
+	}
+	x.done = true
+	t := x.store
+	t.rwLock.Lock()
+	defer t.rwLock.Unlock()
+
+	// 1. check every write against the store as left by the previous ones, without changing it
+	exists := make(map[api.Id]bool)
+	existsNow := func(id api.Id) bool {
+		if e, ok := exists[id]; ok {
+			return e
+		}
+		_, ok := t.indexed[id]
+		return ok
+	}
+	cnt := t.cnt
+	for i, op := range x.ops {
+		switch op.kind {
+		case txnAdd:
+			cnt++
+			op.id = api.Id(strconv.Itoa(cnt))
+			exists[op.id] = true
+		case txnUpdate, txnDelete:
+			if !existsNow(op.id) {
+				return nil, fmt.Errorf("operation %d: %v %w", i, op.id, ErrNotFound)
+			}
+			exists[op.id] = op.kind == txnUpdate
+		}
+		if op.app != nil {
+			// convert a copy, the caller's App only gets its Id once committed
+			app := *op.app
+			app.Id = op.id
+			unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&app)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+			op.unstructuredObj = unstructuredObj
+		}
+	}
+	// 2. apply, nothing can fail from here
+	ids := make([]api.Id, 0)
+	for _, op := range x.ops {
+		switch op.kind {
+		case txnAdd:
+			op.app.Id = op.id
+			t.insert(op.id, op.unstructuredObj, op.raw)
+			ids = append(ids, op.id)
+		case txnUpdate:
+			op.app.Id = op.id
+			t.replace(op.id, op.unstructuredObj, op.raw)
+		case txnDelete:
+			t.remove(op.id)
+		}
+	}
+	t.cnt = cnt
+	return ids, nil
+}
+
+func (x *txnImpl) Rollback() error {
+	if x.done {
+		return ErrTxnDone
+	}
+	x.done = true
+	x.ops = nil
+	return nil
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"application_metadata_api_server/server/api"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestTxn_Commit(t *testing.T) {
+	tree := InitStore()
+	_, err := tree.Add(&api.App{Title: "t1 abc"}, []byte("title: t1 abc"))
+	assert.Nil(t, err)
+	_, err = tree.Add(&api.App{Title: "t2 abc"}, []byte("title: t2 abc"))
+	assert.Nil(t, err)
+
+	txn := tree.Begin()
+	app3 := &api.App{Title: "t3 abc"}
+	assert.Nil(t, txn.Add(app3, []byte("title: t3 abc")))
+	assert.Nil(t, txn.Update("1", &api.App{Title: "t1 xyz"}, []byte("title: t1 xyz")))
+	assert.Nil(t, txn.Delete("2"))
+	// nothing is visible before the commit
+	assert.Equal(t, []api.Id{"1", "2"}, tree.Search("abc", "title"))
+	assert.Equal(t, api.Id(""), app3.Id)
+
+	ids, err := txn.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, []api.Id{"3"}, ids)
+	assert.Equal(t, api.Id("3"), app3.Id)
+	assert.Equal(t, []api.Id{"3"}, tree.Search("abc", "title"))
+	assert.Equal(t, []api.Id{"1"}, tree.Search("xyz", "title"))
+	_, err = tree.Get("2")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, txn.Add(&api.App{}, nil), ErrTxnDone)
+	_, err = txn.Commit()
+	assert.ErrorIs(t, err, ErrTxnDone)
+	assert.ErrorIs(t, txn.Rollback(), ErrTxnDone)
+}
+
+func TestTxn_AllOrNothing(t *testing.T) {
+	tree := InitStore()
+	_, err := tree.Add(&api.App{Title: "t1"}, []byte("title: t1"))
+	assert.Nil(t, err)
+
+	// the second delete of the same Id fails the whole transaction
+	txn := tree.Begin()
+	assert.Nil(t, txn.Add(&api.App{Title: "t2"}, []byte("title: t2")))
+	assert.Nil(t, txn.Delete("1"))
+	assert.Nil(t, txn.Delete("1"))
+	_, err = txn.Commit()
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, []api.Id{"1"}, tree.List())
+	assert.Equal(t, []api.Id{}, tree.Search("t2", "title"))
+
+	txn = tree.Begin()
+	assert.Nil(t, txn.Update("1", &api.App{Title: "t3"}, []byte("title: t3")))
+	assert.Nil(t, txn.Rollback())
+	assert.Equal(t, []api.Id{"1"}, tree.Search("t1", "title"))
+
+	// an add or an update without App is rejected when staged, the transaction commits without it
+	txn = tree.Begin()
+	assert.Nil(t, txn.Add(&api.App{Title: "t5"}, []byte("title: t5")))
+	assert.ErrorIs(t, txn.Add(nil, []byte("title: t6")), errNilApp)
+	assert.ErrorIs(t, txn.Update("1", nil, []byte("title: t6")), errNilApp)
+	ids, err := txn.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, []api.Id{"2"}, ids)
+	assert.Equal(t, []api.Id{"1"}, tree.Search("t1", "title"))
+
+	// ids are not consumed by failed transactions
+	id, err := tree.Add(&api.App{Title: "t4"}, []byte("title: t4"))
+	assert.Nil(t, err)
+	assert.Equal(t, api.Id("3"), id)
+}
+
+func TestTxn_ReadersNeverSeePartialBatch(t *testing.T) {
+	tree := InitStore()
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if n := len(tree.Search("batch", "title")); n%3 != 0 {
+				t.Errorf("saw a partial batch of %d apps", n)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		txn := tree.Begin()
+		for j := 0; j < 3; j++ {
+			assert.Nil(t, txn.Add(&api.App{Title: "batch"}, []byte("title: batch")))
+		}
+		_, err := txn.Commit()
+		assert.Nil(t, err)
+	}
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, 300, len(tree.Search("batch", "title")))
+
+	// the same for a query on two fields
+	ids := make([]api.Id, 3)
+	for i := range ids {
+		id, err := tree.Add(&api.App{Title: "alpha", Company: "alpha"}, []byte("title: alpha"))
+		assert.Nil(t, err)
+		ids[i] = id
+	}
+	stop = make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// each commit changes both fields of every App, a query on the two fields reads them together
+			for _, query := range []*api.App{{Title: "alpha", Company: "beta"}, {Title: "beta", Company: "alpha"}} {
+				rs, err := tree.SearchStruct(query)
+				assert.Nil(t, err)
+				if len(rs) != 0 {
+					t.Errorf("saw a partial batch, %v match title %s and company %s", rs, query.Title, query.Company)
+					return
+				}
+			}
+			rs, err := tree.SearchStruct(&api.App{Title: "alpha", Company: "alpha"})
+			assert.Nil(t, err)
+			if n := len(rs); n%3 != 0 {
+				t.Errorf("saw a partial batch of %d apps", n)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 2000; i++ {
+		value := []string{"beta", "alpha"}[i%2]
+		txn := tree.Begin()
+		for _, id := range ids {
+			assert.Nil(t, txn.Update(id, &api.App{Title: value, Company: value}, []byte("title: "+value)))
+		}
+		_, err := txn.Commit()
+		assert.Nil(t, err)
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -4,6 +4,7 @@ import (
 	"application_metadata_api_server/server/api"
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 type Path struct {
@@ -77,3 +78,51 @@ func exist(target api.Id, srcList []api.Id) bool {
 	}
 	return false
 }
+
+// diffPaths compares the paths of two versions of an App per field path, and returns the paths to remove
+// and to add so the search space holds the new version. Only the field paths whose values changed are
+// returned, with all their old and new values, as an InvertedIndex node shares tokens between values.
+func diffPaths(oldPaths []Path, newPaths []Path) (removed []Path, added []Path) {
+	oldValues := groupPaths(oldPaths)
+	newValues := groupPaths(newPaths)
+	for key, values := range oldValues {
+		if !sameValues(values, newValues[key]) {
+			removed = append(removed, values...)
+		}
+	}
+	for key, values := range newValues {
+		if !sameValues(oldValues[key], values) {
+			added = append(added, values...)
+		}
+	}
+	return removed, added
+}
+
+// groupPaths groups paths by their field path
+func groupPaths(paths []Path) map[string][]Path {
+	rs := make(map[string][]Path)
+	for _, p := range paths {
+		key := strings.Join(p.fields, "\x00")
+		rs[key] = append(rs[key], p)
+	}
+	return rs
+}
+
+// sameValues tells if two groups of paths hold the same values, ignoring case and order as the index does
+func sameValues(paths1 []Path, paths2 []Path) bool {
+	if len(paths1) != len(paths2) {
+		return false
+	}
+	cnt := make(map[string]int)
+	for _, p := range paths1 {
+		cnt[getLowercase(p.value)]++
+	}
+	for _, p := range paths2 {
+		v := getLowercase(p.value)
+		if cnt[v] == 0 {
+			return false
+		}
+		cnt[v]--
+	}
+	return true
+}
@@ -110,3 +110,25 @@ func getPathByValue(value string, paths []Path) *Path {
 	}
 	return nil
 }
+
+func TestDiffPaths(t *testing.T) {
+	oldPaths := []Path{
+		{value: "t1", fields: []string{"title"}},
+		{value: "v1", fields: []string{"version"}},
+		{value: "a", fields: []string{"maintainers", "name"}},
+		{value: "b", fields: []string{"maintainers", "name"}},
+	}
+	newPaths := []Path{
+		{value: "T1", fields: []string{"title"}},
+		{value: "v2", fields: []string{"version"}},
+		{value: "b", fields: []string{"maintainers", "name"}},
+		{value: "a", fields: []string{"maintainers", "name"}},
+		{value: "dev", fields: []string{"labels", "env"}},
+	}
+	removed, added := diffPaths(oldPaths, newPaths)
+	assert.ElementsMatch(t, []Path{{value: "v1", fields: []string{"version"}}}, removed)
+	assert.ElementsMatch(t, []Path{
+		{value: "v2", fields: []string{"version"}},
+		{value: "dev", fields: []string{"labels", "env"}},
+	}, added)
+}
@@ -0,0 +1,70 @@
+package cache
+
+import "application_metadata_api_server/server/api"
+
+// watchBufferSize is the number of events a watcher may lag behind before it is dropped
+const watchBufferSize = 256
+
+// EventType is the kind of change of an Event
+type EventType string
+
+const (
+	EventAdded    EventType = "ADDED"
+	EventModified EventType = "MODIFIED"
+	EventDeleted  EventType = "DELETED"
+)
+
+// Event is a change of the store, Raw is the raw content of the App after the change, nil when deleted.
+// Previous is the raw content before the change, nil when added.
+type Event struct {
+	Type     EventType
+	Id       api.Id
+	Raw      []byte
+	Previous []byte
+	// Revision increases with every change of the store
+	Revision int64
+}
+
+func (t *storeImpl) Revision() int64 {
+	t.rwLock.RLock()
+	defer t.rwLock.RUnlock()
+	return t.revision
+}
+
+func (t *storeImpl) Watch() (<-chan Event, func()) {
+	t.rwLock.Lock()
+	defer t.rwLock.Unlock()
+
+	ch := make(chan Event, watchBufferSize)
+	key := t.nextWatcher
+	t.nextWatcher++
+	t.watchers[key] = ch
+	cancel := func() {
+		t.rwLock.Lock()
+		defer t.rwLock.Unlock()
+		t.dropWatcher(key)
+	}
+	return ch, cancel
+}
+
+// publish sends an event to every watcher, the write lock must be held.
+// Watchers whose buffer is full are dropped rather than blocking the writers, their channel is closed.
+func (t *storeImpl) publish(eventType EventType, id api.Id, raw, previous []byte) {
+	t.revision++
+	event := Event{Type: eventType, Id: id, Raw: raw, Previous: previous, Revision: t.revision}
+	for key, ch := range t.watchers {
+		select {
+		case ch <- event:
+		default:
+			t.dropWatcher(key)
+		}
+	}
+}
+
+// dropWatcher closes and removes a watcher, the write lock must be held
+func (t *storeImpl) dropWatcher(key int) {
+	if ch, ok := t.watchers[key]; ok {
+		close(ch)
+		delete(t.watchers, key)
+	}
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"application_metadata_api_server/server/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStoreImpl_Watch(t *testing.T) {
+	tree := InitStore()
+	_, err := tree.Add(&api.App{Title: "t0"}, []byte("title: t0"))
+	assert.Nil(t, err)
+
+	events, cancel := tree.Watch()
+	_, err = tree.Add(&api.App{Title: "t1"}, []byte("title: t1"))
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Update("1", &api.App{Title: "t2"}, []byte("title: t2")))
+	assert.Nil(t, tree.Delete("2"))
+	txn := tree.Begin()
+	assert.Nil(t, txn.Add(&api.App{Title: "t3"}, []byte("title: t3")))
+	_, err = txn.Commit()
+	assert.Nil(t, err)
+
+	// changes before Watch are not sent
+	assert.Equal(t, Event{Type: EventAdded, Id: "2", Raw: []byte("title: t1"), Revision: 2}, <-events)
+	assert.Equal(t, Event{Type: EventModified, Id: "1", Raw: []byte("title: t2"), Previous: []byte("title: t0"), Revision: 3}, <-events)
+	assert.Equal(t, Event{Type: EventDeleted, Id: "2", Previous: []byte("title: t1"), Revision: 4}, <-events)
+	assert.Equal(t, Event{Type: EventAdded, Id: "3", Raw: []byte("title: t3"), Revision: 5}, <-events)
+	assert.Equal(t, int64(5), tree.Revision())
+
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+	// cancel twice is a no-op
+	cancel()
+}
+
+func TestStoreImpl_WatchSlowWatcher(t *testing.T) {
+	tree := InitStore()
+	events, cancel := tree.Watch()
+	defer cancel()
+	for i := 0; i <= watchBufferSize; i++ {
+		_, err := tree.Add(&api.App{Title: "t"}, []byte("title: t"))
+		assert.Nil(t, err)
+	}
+	// the buffered events are still delivered, then the channel is closed
+	cnt := 0
+	for range events {
+		cnt++
+	}
+	assert.Equal(t, watchBufferSize, cnt)
+}
@@ -0,0 +1,486 @@
+// Package client is the Go client of the App metadata HTTP API
+package client
+
+import (
+	"application_metadata_api_server/server/api"
+	v2 "application_metadata_api_server/server/api/v2"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mediaTypeJSON   = "application/json"
+	mediaTypeYAML   = "application/yaml"
+	mediaTypeNDJSON = "application/x-ndjson"
+
+	defaultMaxRetries = 3
+	defaultBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+	// Apps are sent and received as v2 documents, the apiVersion holding every field of api.App
+	apiPrefix = "/" + v2.APIVersion
+)
+
+// Client calls the App metadata HTTP API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// header holds the credentials and the namespace sent with every request
+	header http.Header
+}
+
+// Option configures the Client returned by New
+type Option func(c *Client)
+
+// WithHTTPClient sets the http client sending the requests, http.DefaultClient by default
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a failed idempotent request is retried (3 by default), and the backoff
+// before the first retry, doubled at each retry
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// WithMaxBackoff sets the longest wait before a retry (30s by default). A 429 response asking to retry later, e.g.
+// when a daily quota is exceeded, is returned rather than waited for.
+func WithMaxBackoff(maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithAPIKey authenticates the requests with an API key
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.header.Set("X-API-Key", key)
+	}
+}
+
+// WithBearerToken authenticates the requests with a bearer token, such as a JWT
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithNamespace sends the requests to a namespace of the server, the default namespace otherwise.
+// "*" searches every namespace, the other requests are rejected.
+func WithNamespace(namespace string) Option {
+	return func(c *Client) {
+		c.header.Set("X-Namespace", namespace)
+	}
+}
+
+// New returns a Client of the API server at baseURL, e.g. http://localhost:8080
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("base url %q must have a scheme and a host", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+		header:     make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Warning is a non-fatal finding of the validation
+type Warning struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// WriteResult is the response of a put or an update
+type WriteResult struct {
+	Id       api.Id    `json:"id"`
+	Message  string    `json:"message"`
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// Put creates an App, and sets its Id
+func (c *Client) Put(ctx context.Context, app *api.App) (*WriteResult, error) {
+	body, err := encodeApp(app)
+	if err != nil {
+		return nil, err
+	}
+	rs := &WriteResult{}
+	if err := c.do(ctx, http.MethodPost, apiPrefix+"/apps", nil, body, rs); err != nil {
+		return nil, err
+	}
+	app.Id = rs.Id
+	return rs, nil
+}
+
+// Get returns an App by Id
+func (c *Client) Get(ctx context.Context, id api.Id) (*api.App, error) {
+	versioned := &v2.App{}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/apps/"+url.PathEscape(string(id)), nil, nil, versioned); err != nil {
+		return nil, err
+	}
+	app := &api.App{}
+	if err := versioned.ConvertTo(app); err != nil {
+		return nil, err
+	}
+	app.Id = id
+	return app, nil
+}
+
+// Search returns the Ids of the Apps matching every non empty field of query
+func (c *Client) Search(ctx context.Context, query *api.App) ([]api.Id, error) {
+	params := url.Values{}
+	addSearchParams(params, "", reflect.ValueOf(*query))
+	return c.SearchQuery(ctx, params)
+}
+
+// SearchQuery returns the Ids of the Apps matching search query parameters, json paths such as title,
+// maintainers.email or labels.env, and label=key=value
+func (c *Client) SearchQuery(ctx context.Context, params url.Values) ([]api.Id, error) {
+	rs := &struct {
+		ResultList []api.Id `json:"result_list"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/apps", params, nil, rs); err != nil {
+		return nil, err
+	}
+	return rs.ResultList, nil
+}
+
+// Update replaces an existing App
+func (c *Client) Update(ctx context.Context, id api.Id, app *api.App) (*WriteResult, error) {
+	body, err := encodeApp(app)
+	if err != nil {
+		return nil, err
+	}
+	rs := &WriteResult{}
+	if err := c.do(ctx, http.MethodPut, apiPrefix+"/apps/"+url.PathEscape(string(id)), nil, body, rs); err != nil {
+		return nil, err
+	}
+	app.Id = id
+	return rs, nil
+}
+
+// Delete removes an App
+func (c *Client) Delete(ctx context.Context, id api.Id) error {
+	return c.do(ctx, http.MethodDelete, "/apps/"+url.PathEscape(string(id)), nil, nil, nil)
+}
+
+// PutDocument creates an App from a yaml or json document in any apiVersion, the document is stored as is
+func (c *Client) PutDocument(ctx context.Context, doc []byte) (*WriteResult, error) {
+	return c.writeDocument(ctx, http.MethodPost, "/apps", doc)
+}
+
+// UpdateDocument replaces an existing App with a yaml or json document in any apiVersion
+func (c *Client) UpdateDocument(ctx context.Context, id api.Id, doc []byte) (*WriteResult, error) {
+	return c.writeDocument(ctx, http.MethodPut, "/apps/"+url.PathEscape(string(id)), doc)
+}
+
+func (c *Client) writeDocument(ctx context.Context, method, path string, doc []byte) (*WriteResult, error) {
+	data, err := c.read(ctx, call{method: method, path: path, body: doc, contentType: mediaTypeYAML, accept: mediaTypeJSON})
+	if err != nil {
+		return nil, err
+	}
+	rs := &WriteResult{}
+	if err := json.Unmarshal(data, rs); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// GetDocument returns the document of an App as stored, in json or yaml (mediaType application/json or
+// application/yaml), and in an apiVersion when set
+func (c *Client) GetDocument(ctx context.Context, id api.Id, apiVersion string, mediaType string) ([]byte, error) {
+	return c.read(ctx, call{method: http.MethodGet, path: versionPrefix(apiVersion) + "/apps/" + url.PathEscape(string(id)), accept: mediaType})
+}
+
+// Export writes every App to out, as multi-document yaml or ndjson (mediaType application/yaml or
+// application/x-ndjson), and in an apiVersion when set
+func (c *Client) Export(ctx context.Context, out io.Writer, apiVersion string, mediaType string) error {
+	resp, err := c.send(ctx, call{method: http.MethodGet, path: versionPrefix(apiVersion) + "/export", accept: mediaType})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func versionPrefix(apiVersion string) string {
+	if apiVersion == "" {
+		return ""
+	}
+	return "/" + apiVersion
+}
+
+func encodeApp(app *api.App) ([]byte, error) {
+	versioned := &v2.App{}
+	if err := versioned.ConvertFrom(app); err != nil {
+		return nil, err
+	}
+	// the Id is given by the url
+	versioned.Id = ""
+	return json.Marshal(versioned)
+}
+
+// addSearchParams adds the non empty fields of an App as search query parameters, e.g. maintainers.email
+func addSearchParams(params url.Values, prefix string, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.String() != "" && prefix != "id" {
+			params.Add(prefix, v.String())
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			params.Add("label", k.String()+"="+v.MapIndex(k).String())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			addSearchParams(params, prefix, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			addSearchParams(params, name, v.Field(i))
+		}
+	}
+}
+
+// call is a request to the API
+type call struct {
+	method      string
+	path        string
+	params      url.Values
+	body        []byte
+	contentType string
+	accept      string
+}
+
+// do sends a json request, and decodes the json response in out when set
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, body []byte, out interface{}) error {
+	data, err := c.read(ctx, call{method: method, path: path, params: params, body: body, contentType: mediaTypeJSON, accept: mediaTypeJSON})
+	if err != nil {
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// read sends a request and returns the response body
+func (c *Client) read(ctx context.Context, r call) ([]byte, error) {
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// send sends a request, retrying idempotent ones on network errors, 429 and 5xx responses.
+// The response body is closed on error.
+func (c *Client) send(ctx context.Context, r call) (*http.Response, error) {
+	u := c.baseURL.ResolveReference(&url.URL{Path: strings.TrimSuffix(c.baseURL.Path, "/") + r.path, RawQuery: r.params.Encode()})
+	retries := 0
+	if r.method != http.MethodPost {
+		retries = c.maxRetries
+	}
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, r.method, u.String(), bytes.NewReader(r.body))
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range c.header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Accept", r.accept)
+		if r.body != nil {
+			req.Header.Set("Content-Type", r.contentType)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+		wait := backoff
+		if wait > c.maxBackoff {
+			wait = c.maxBackoff
+		}
+		if err == nil {
+			err = decodeError(resp)
+			if !isRetryable(resp.StatusCode) {
+				return nil, err
+			}
+			if after, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil {
+				wait = time.Duration(after) * time.Second
+				if wait > c.maxBackoff {
+					return nil, err
+				}
+			}
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+func isRetryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// decodeError reads an error response and closes its body
+func decodeError(resp *http.Response) error {
+	defer resp.Body.Close()
+	apiErr := &Error{}
+	data, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, apiErr); err != nil {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	apiErr.StatusCode = resp.StatusCode
+	return apiErr
+}
+
+// EventType is the kind of change of an Event
+type EventType string
+
+const (
+	EventAdded    EventType = "ADDED"
+	EventModified EventType = "MODIFIED"
+	EventDeleted  EventType = "DELETED"
+)
+
+// Event is a change of the store, App is unset for EventDeleted
+type Event struct {
+	Type     EventType
+	Id       api.Id
+	Revision int64
+	App      *api.App
+}
+
+// Watcher receives the changes of the store
+type Watcher struct {
+	events chan Event
+	cancel context.CancelFunc
+	err    error
+}
+
+// Events returns the changes, the channel is closed when the watch ends
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns why the watch ended, once Events is closed. It is nil after Stop.
+func (w *Watcher) Err() error {
+	return w.err
+}
+
+// Stop ends the watch
+func (w *Watcher) Stop() {
+	w.cancel()
+}
+
+// Watch streams the changes of the store from the moment it returns, until ctx is done or Stop is called
+func (c *Client) Watch(ctx context.Context) (*Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	resp, err := c.send(ctx, call{method: http.MethodGet, path: apiPrefix + "/watch", accept: mediaTypeNDJSON})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	w := &Watcher{events: make(chan Event), cancel: cancel}
+	go func() {
+		defer close(w.events)
+		defer resp.Body.Close()
+		w.err = w.read(ctx, resp.Body)
+	}()
+	return w, nil
+}
+
+// watchLine is a line of the watch stream, see server.WatchEvent
+type watchLine struct {
+	Type     string          `json:"type"`
+	Id       api.Id          `json:"id"`
+	Revision int64           `json:"revision"`
+	App      json.RawMessage `json:"app"`
+	Error    string          `json:"error"`
+}
+
+func (w *Watcher) read(ctx context.Context, body io.Reader) error {
+	reader := bufio.NewReader(body)
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("watch closed by the server")
+			}
+			return err
+		}
+		line := watchLine{}
+		if err := json.Unmarshal(data, &line); err != nil {
+			return err
+		}
+		if line.Error != "" {
+			return errors.New(line.Error)
+		}
+		event := Event{Type: EventType(line.Type), Id: line.Id, Revision: line.Revision}
+		if len(line.App) > 0 {
+			versioned := &v2.App{}
+			if err := json.Unmarshal(line.App, versioned); err != nil {
+				return err
+			}
+			event.App = &api.App{}
+			if err := versioned.ConvertTo(event.App); err != nil {
+				return err
+			}
+			event.App.Id = line.Id
+		}
+		select {
+		case w.events <- event:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
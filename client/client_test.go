@@ -0,0 +1,220 @@
+package client
+
+import (
+	"application_metadata_api_server/server"
+	"application_metadata_api_server/server/api"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestApp(title string) *api.App {
+	return &api.App{
+		Title:       title,
+		Version:     "1.0.0",
+		Maintainers: []api.Maintainer{{Name: "first last", Email: "apptwo@hotmail.com"}},
+		Company:     "Upbound Inc.",
+		Website:     "https://upbound.io",
+		Source:      "https://github.com/upbound/repo",
+		License:     "Apache-2.0",
+		Description: "a valid app",
+		Labels:      map[string]string{"env": "prod"},
+		Release:     api.Release{Name: "r2", Author: api.Maintainer{Name: "a", Email: "a@b.com"}},
+		ReleaseHistory: []api.Release{
+			{Name: "r1", Author: api.Maintainer{Name: "a", Email: "a@b.com"}},
+		},
+		Dependencies: []api.Dependency{{Name: "postgres", Version: "14"}},
+	}
+}
+
+func newTestClient(t *testing.T, handler http.Handler, opts ...Option) *Client {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	c, err := New(ts.URL, opts...)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestClient(t *testing.T) {
+	c := newTestClient(t, server.NewHttpServer().Handler())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	app := newTestApp("client app")
+	rs, err := c.Put(ctx, app)
+	assert.Nil(t, err)
+	assert.Equal(t, api.Id("1"), rs.Id)
+	assert.Equal(t, api.Id("1"), app.Id)
+
+	got, err := c.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, app, got)
+
+	ids, err := c.Search(ctx, &api.App{Title: "client", Labels: map[string]string{"env": "prod"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []api.Id{"1"}, ids)
+	ids, err = c.Search(ctx, &api.App{Maintainers: []api.Maintainer{{Email: "nobody@b.com"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []api.Id{}, ids)
+
+	_, err = c.Update(ctx, "1", newTestApp("renamed app"))
+	assert.Nil(t, err)
+	got, err = c.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "renamed app", got.Title)
+
+	invalid := newTestApp("invalid")
+	invalid.Maintainers[0].Email = "invalid"
+	_, err = c.Put(ctx, invalid)
+	assert.ErrorIs(t, err, ErrInvalidInput)
+	apiErr := &Error{}
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Message, "email is invalid")
+
+	assert.Nil(t, c.Delete(ctx, "1"))
+	_, err = c.Get(ctx, "1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, c.Delete(ctx, "1"), ErrNotFound)
+}
+
+func TestClient_Retries(t *testing.T) {
+	failures := int32(2)
+	calls := int32(0)
+	handler := server.NewHttpServer().Handler()
+	flaky := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+	c := newTestClient(t, flaky, WithRetries(2, time.Millisecond))
+	ctx := context.Background()
+
+	ids, err := c.Search(ctx, &api.App{Title: "x"})
+	assert.Nil(t, err)
+	assert.Equal(t, []api.Id{}, ids)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// puts are not idempotent, they are never retried
+	atomic.StoreInt32(&failures, 1)
+	_, err = c.Put(ctx, newTestApp("app"))
+	assert.ErrorIs(t, err, ErrInternal)
+
+	// retries stop with the context
+	atomic.StoreInt32(&failures, 100)
+	c = newTestClient(t, flaky, WithRetries(100, time.Hour))
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Get(ctx, "1")
+	assert.ErrorIs(t, err, ErrInternal)
+}
+
+func TestClient_RetryAfter(t *testing.T) {
+	calls := int32(0)
+	retryAfter := int32(1)
+	limited := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(atomic.LoadInt32(&retryAfter))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error_reason":"too many requests"}`))
+	})
+	c := newTestClient(t, limited, WithRetries(1, time.Millisecond), WithMaxBackoff(2*time.Second))
+	ctx := context.Background()
+
+	_, err := c.Get(ctx, "1")
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// a daily quota asks to retry at midnight, longer than the max backoff, the error is returned at once
+	atomic.StoreInt32(&retryAfter, 3600)
+	atomic.StoreInt32(&calls, 0)
+	start := time.Now()
+	_, err = c.Get(ctx, "1")
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_Watch(t *testing.T) {
+	c := newTestClient(t, server.NewHttpServer().Handler())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watcher, err := c.Watch(ctx)
+	assert.Nil(t, err)
+	app := newTestApp("watched app")
+	_, err = c.Put(ctx, app)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Delete(ctx, app.Id))
+
+	event := <-watcher.Events()
+	assert.Equal(t, EventAdded, event.Type)
+	assert.Equal(t, app, event.App)
+	event = <-watcher.Events()
+	assert.Equal(t, Event{Type: EventDeleted, Id: app.Id, Revision: 2}, event)
+
+	watcher.Stop()
+	for range watcher.Events() {
+	}
+	assert.Nil(t, watcher.Err())
+}
+
+func TestClient_Authentication(t *testing.T) {
+	hash := sha256.Sum256([]byte("secret"))
+	authn, err := server.NewAuthenticator(&server.AuthFile{APIKeys: []server.APIKey{{Name: "ci", SHA256: hex.EncodeToString(hash[:])}}})
+	assert.Nil(t, err)
+	handler := server.NewHttpServer(server.WithAuthenticator(authn)).Handler()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = newTestClient(t, handler).Put(ctx, newTestApp("client app"))
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	_, err = newTestClient(t, handler, WithBearerToken("token")).Put(ctx, newTestApp("client app"))
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+
+	rs, err := newTestClient(t, handler, WithAPIKey("secret")).Put(ctx, newTestApp("client app"))
+	assert.Nil(t, err)
+	assert.Equal(t, api.Id("1"), rs.Id)
+}
+
+func TestClient_Namespace(t *testing.T) {
+	handler := server.NewHttpServer().Handler()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	payments := newTestClient(t, handler, WithNamespace("payments"))
+
+	_, err := newTestClient(t, handler).Put(ctx, newTestApp("default app"))
+	assert.Nil(t, err)
+	rs, err := payments.Put(ctx, newTestApp("payments app"))
+	assert.Nil(t, err)
+	assert.Equal(t, api.Id("1"), rs.Id)
+	got, err := payments.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "payments app", got.Title)
+
+	ids, err := newTestClient(t, handler, WithNamespace("*")).Search(ctx, &api.App{Company: "Upbound Inc."})
+	assert.Nil(t, err)
+	assert.Equal(t, []api.Id{"default/1", "payments/1"}, ids)
+	_, err = newTestClient(t, handler, WithNamespace("Payments")).Get(ctx, "1")
+	assert.ErrorIs(t, err, ErrInvalidInput)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("localhost:8080")
+	assert.NotNil(t, err)
+	c, err := New("http://localhost:8080/prefix/")
+	assert.Nil(t, err)
+	assert.Equal(t, "/prefix/", c.baseURL.Path)
+}
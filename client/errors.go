@@ -0,0 +1,74 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// The errors of the API, by error_reason. Use errors.Is on the errors returned by the Client.
+var (
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+	ErrNotFound             = errors.New("not found")
+	ErrInvalidInput         = errors.New("invalid input yaml")
+	ErrPolicyViolation      = errors.New("policy violation")
+	ErrMethodNotAllowed     = errors.New("method not allowed")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrNotAcceptable        = errors.New("not acceptable")
+	ErrRequestTooLarge      = errors.New("request entity too large")
+	ErrTooManyRequests      = errors.New("too many requests")
+	ErrInternal             = errors.New("internal server error")
+)
+
+// reasons maps the error_reason of the responses (see server/error.go) to their error
+var reasons = map[string]error{
+	ErrUnauthorized.Error():         ErrUnauthorized,
+	ErrForbidden.Error():            ErrForbidden,
+	ErrNotFound.Error():             ErrNotFound,
+	ErrInvalidInput.Error():         ErrInvalidInput,
+	ErrPolicyViolation.Error():      ErrPolicyViolation,
+	ErrMethodNotAllowed.Error():     ErrMethodNotAllowed,
+	ErrUnsupportedMediaType.Error(): ErrUnsupportedMediaType,
+	ErrNotAcceptable.Error():        ErrNotAcceptable,
+	ErrRequestTooLarge.Error():      ErrRequestTooLarge,
+	ErrTooManyRequests.Error():      ErrTooManyRequests,
+	ErrInternal.Error():             ErrInternal,
+}
+
+// PolicyViolation is a violated organisation policy, returned with ErrPolicyViolation
+type PolicyViolation struct {
+	Policy  string `json:"policy"`
+	Mode    string `json:"mode"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode       int               `json:"-"`
+	Reason           string            `json:"error_reason"`
+	Message          string            `json:"error_message"`
+	PolicyViolations []PolicyViolation `json:"policy_violations,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
+}
+
+// Unwrap returns the error of the reason, e.g. ErrNotFound
+func (e *Error) Unwrap() error {
+	if err, ok := reasons[e.Reason]; ok {
+		return err
+	}
+	switch {
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+// Command appctl manages the App metadata of an API server, and validates App documents offline
+package main
+
+import (
+	"application_metadata_api_server/client"
+	"application_metadata_api_server/server"
+	"application_metadata_api_server/server/api"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const usage = `appctl manages the App metadata of an API server.
+
+Usage:
+  appctl [global flags] <command> [flags]
+
+Commands:
+  apply -f FILE [--id ID]                create the Apps of a yaml or json file, or update the ones with an id
+  get ID [-o yaml|json]                  print an App
+  search [--title T] [--label K=V]...    print the Ids of the matching Apps
+  delete ID...                           delete Apps
+  validate -f FILE                       validate the Apps of a file offline, without a server
+  export [-o yaml|ndjson]                print every App
+
+Global flags:
+`
+
+// errUsage is returned for invalid command lines, the usage is already printed
+var errUsage = errors.New("usage")
+
+// errInvalid is returned by validate when a document is invalid, the findings are already printed
+var errInvalid = errors.New("invalid")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs a command line, and returns the exit code: 0 on success, 1 on error and 2 on usage error
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("appctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	serverURL := global.String("server", envOr("APPCTL_SERVER", "http://localhost:8080"), "url of the API server, also set by $APPCTL_SERVER")
+	timeout := global.Duration("timeout", 30*time.Second, "timeout of the command")
+	apiKey := global.String("api-key", os.Getenv("APPCTL_API_KEY"), "API key authenticating the requests, also set by $APPCTL_API_KEY")
+	token := global.String("token", os.Getenv("APPCTL_TOKEN"), "bearer token (JWT) authenticating the requests, also set by $APPCTL_TOKEN")
+	namespace := global.String("namespace", os.Getenv("APPCTL_NAMESPACE"), "namespace of the Apps, * searches every namespace, also set by $APPCTL_NAMESPACE")
+	global.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		global.PrintDefaults()
+	}
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	cmd := &command{stdin: stdin, stdout: stdout, stderr: stderr, serverURL: *serverURL}
+	if *apiKey != "" {
+		cmd.clientOpts = append(cmd.clientOpts, client.WithAPIKey(*apiKey))
+	}
+	if *token != "" {
+		cmd.clientOpts = append(cmd.clientOpts, client.WithBearerToken(*token))
+	}
+	if *namespace != "" {
+		cmd.clientOpts = append(cmd.clientOpts, client.WithNamespace(*namespace))
+	}
+	commands := map[string]func(ctx context.Context, args []string) error{
+		"apply":    cmd.apply,
+		"get":      cmd.get,
+		"search":   cmd.search,
+		"delete":   cmd.delete,
+		"validate": cmd.validate,
+		"export":   cmd.export,
+	}
+	fn, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", global.Arg(0))
+		global.Usage()
+		return 2
+	}
+	err := fn(ctx, global.Args()[1:])
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	case errors.Is(err, errInvalid):
+		return 1
+	}
+	fmt.Fprintf(stderr, "error: %v\n", err)
+	return 1
+}
+
+type command struct {
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	serverURL  string
+	clientOpts []client.Option
+}
+
+func (c *command) client() (*client.Client, error) {
+	return client.New(c.serverURL, c.clientOpts...)
+}
+
+// flagSet returns the flag set of a command, printing its usage on errors
+func (c *command) flagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: appctl %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses flags placed before or after the positional arguments, and returns the positional arguments
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func (c *command) apply(ctx context.Context, args []string) error {
+	fs := c.flagSet("apply", "-f FILE [--id ID]")
+	file := fs.String("f", "", "yaml or json file of one or more Apps, - for stdin")
+	id := fs.String("id", "", "Id of the App to update, when the file has a single App without id")
+	if rest, err := parse(fs, args); err != nil || len(rest) > 0 || *file == "" {
+		fs.Usage()
+		return errUsage
+	}
+	docs, err := c.readDocuments(*file)
+	if err != nil {
+		return err
+	}
+	if *id != "" && len(docs) != 1 {
+		return fmt.Errorf("--id needs a file of a single App, %s has %d", *file, len(docs))
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		appId := api.Id(*id)
+		if appId == "" {
+			meta := struct {
+				Id api.Id `json:"id"`
+			}{}
+			if err := yaml.Unmarshal(doc, &meta); err != nil {
+				return err
+			}
+			appId = meta.Id
+		}
+		var rs *client.WriteResult
+		action := "created"
+		if appId == "" {
+			rs, err = cl.PutDocument(ctx, doc)
+		} else {
+			rs, err = cl.UpdateDocument(ctx, appId, doc)
+			action = "configured"
+		}
+		if err != nil {
+			return err
+		}
+		if rs.Id == "" {
+			rs.Id = appId
+		}
+		c.printWarnings(rs.Warnings)
+		fmt.Fprintf(c.stdout, "app %s %s\n", rs.Id, action)
+	}
+	return nil
+}
+
+func (c *command) get(ctx context.Context, args []string) error {
+	fs := c.flagSet("get", "ID [-o yaml|json] [--api-version VERSION]")
+	output := fs.String("o", "yaml", "output format, yaml or json")
+	apiVersion := fs.String("api-version", "", "apiVersion to print the App in, as stored by default")
+	rest, err := parse(fs, args)
+	if err != nil || len(rest) != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	mediaType, err := mediaTypeOf(*output, "yaml", "json")
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	doc, err := cl.GetDocument(ctx, api.Id(rest[0]), *apiVersion, mediaType)
+	if err != nil {
+		return err
+	}
+	c.stdout.Write(doc)
+	if !bytes.HasSuffix(doc, []byte("\n")) {
+		fmt.Fprintln(c.stdout)
+	}
+	return nil
+}
+
+func (c *command) search(ctx context.Context, args []string) error {
+	fs := c.flagSet("search", "[--title T] [--label K=V]... [--field PATH=VALUE]... [-o ids|json]")
+	params := url.Values{}
+	for _, name := range []string{"title", "version", "company", "website", "source", "license", "description"} {
+		name := name
+		fs.Func(name, "match the "+name, func(v string) error {
+			params.Add(name, v)
+			return nil
+		})
+	}
+	fs.Func("label", "match a label, KEY=VALUE, may be repeated", func(v string) error {
+		params.Add("label", v)
+		return nil
+	})
+	fs.Func("field", "match any field by its json path, PATH=VALUE e.g. maintainers.email=a@b.com, may be repeated", func(v string) error {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%q must be PATH=VALUE", v)
+		}
+		params.Add(kv[0], kv[1])
+		return nil
+	})
+	output := fs.String("o", "ids", "output format, ids (one per line) or json")
+	if rest, err := parse(fs, args); err != nil || len(rest) > 0 {
+		fs.Usage()
+		return errUsage
+	}
+	if _, err := mediaTypeOf(*output, "ids", "json"); err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	ids, err := cl.SearchQuery(ctx, params)
+	if err != nil {
+		return err
+	}
+	if *output == "json" {
+		return json.NewEncoder(c.stdout).Encode(ids)
+	}
+	for _, id := range ids {
+		fmt.Fprintln(c.stdout, id)
+	}
+	return nil
+}
+
+func (c *command) delete(ctx context.Context, args []string) error {
+	fs := c.flagSet("delete", "ID...")
+	rest, err := parse(fs, args)
+	if err != nil || len(rest) == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range rest {
+		if err := cl.Delete(ctx, api.Id(id)); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "app %s deleted\n", id)
+	}
+	return nil
+}
+
+func (c *command) validate(ctx context.Context, args []string) error {
+	fs := c.flagSet("validate", "-f FILE [--schema-validation] [--lenient] [--api-version VERSION]")
+	file := fs.String("f", "", "yaml or json file of one or more Apps, - for stdin")
+	schemaValidation := fs.Bool("schema-validation", false, "also validate against the published App JSON Schema")
+	lenient := fs.Bool("lenient", false, "report unknown and duplicate keys as warnings instead of errors")
+	apiVersion := fs.String("api-version", "", "apiVersion the Apps must be in, their own apiVersion by default")
+	if rest, err := parse(fs, args); err != nil || len(rest) > 0 || *file == "" {
+		fs.Usage()
+		return errUsage
+	}
+	docs, err := c.readDocuments(*file)
+	if err != nil {
+		return err
+	}
+	opts := make([]server.Option, 0)
+	if *schemaValidation {
+		opts = append(opts, server.WithSchemaValidation())
+	}
+	putOpts := server.PutOptions{Mode: server.DecodeStrict, APIVersion: *apiVersion}
+	if *lenient {
+		putOpts.Mode = server.DecodeLenient
+	}
+	validator := server.NewValidator(opts...)
+	valid := true
+	for i, doc := range docs {
+		name := *file
+		if len(docs) > 1 {
+			name = fmt.Sprintf("%s[%d]", *file, i)
+		}
+		app, warnings, validationErr := validator.ValidatePutWithOptions(doc, putOpts)
+		if validationErr != nil {
+			fmt.Fprintf(c.stdout, "%s: invalid: %s\n", name, validationErr.Error())
+			valid = false
+			continue
+		}
+		for _, w := range append(warnings, validator.Lint(&app)...) {
+			fmt.Fprintf(c.stdout, "%s: warning: %s\n", name, w.String())
+		}
+		fmt.Fprintf(c.stdout, "%s: valid\n", name)
+	}
+	if !valid {
+		return errInvalid
+	}
+	return nil
+}
+
+func (c *command) export(ctx context.Context, args []string) error {
+	fs := c.flagSet("export", "[-o yaml|ndjson] [--api-version VERSION]")
+	output := fs.String("o", "yaml", "output format, yaml or ndjson")
+	apiVersion := fs.String("api-version", "", "apiVersion to print the Apps in, as stored by default")
+	if rest, err := parse(fs, args); err != nil || len(rest) > 0 {
+		fs.Usage()
+		return errUsage
+	}
+	mediaType, err := mediaTypeOf(*output, "yaml", "ndjson")
+	if err != nil {
+		return err
+	}
+	cl, err := c.client()
+	if err != nil {
+		return err
+	}
+	return cl.Export(ctx, c.stdout, *apiVersion, mediaType)
+}
+
+// readDocuments reads the documents of a multi-document yaml file, or of stdin for -
+func (c *command) readDocuments(file string) ([][]byte, error) {
+	var in io.Reader = c.stdin
+	if file != "-" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		in = bytes.NewReader(data)
+	}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(in))
+	docs := make([][]byte, 0)
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// skip documents holding only blank lines or comments
+		if jsonDoc, err := yaml.YAMLToJSON(doc); err == nil && string(jsonDoc) == "null" {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no App in %s", file)
+	}
+	return docs, nil
+}
+
+func (c *command) printWarnings(warnings []client.Warning) {
+	for _, w := range warnings {
+		if w.Field != "" {
+			fmt.Fprintf(c.stderr, "warning: %s: %s\n", w.Field, w.Message)
+			continue
+		}
+		fmt.Fprintf(c.stderr, "warning: %s\n", w.Message)
+	}
+}
+
+var mediaTypes = map[string]string{
+	"yaml":   "application/yaml",
+	"json":   "application/json",
+	"ndjson": "application/x-ndjson",
+	"ids":    "",
+}
+
+// mediaTypeOf returns the media type of an output format, which must be one of allowed
+func mediaTypeOf(output string, allowed ...string) (string, error) {
+	for _, a := range allowed {
+		if output == a {
+			return mediaTypes[a], nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q, expecting %s", output, strings.Join(allowed, " or "))
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
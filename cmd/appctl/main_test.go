@@ -0,0 +1,122 @@
+package main
+
+import (
+	"application_metadata_api_server/server"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// appctl runs a command line against the server, and returns its exit code, stdout and stderr
+func appctl(serverURL, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"--server", serverURL}, args...), strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestAppctl(t *testing.T) {
+	ts := httptest.NewServer(server.NewHttpServer().Handler())
+	defer ts.Close()
+
+	code, out, _ := appctl(ts.URL, "", "apply", "-f", "../../testdata/valid-payload1.yaml")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "app 1 created\n", out)
+
+	code, out, _ = appctl(ts.URL, "", "get", "1", "-o", "json")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, `"title":"Valid App 1"`)
+
+	code, out, _ = appctl(ts.URL, "", "search", "--title", "Valid", "--label", "k=xyz")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "1\n", out)
+
+	code, out, _ = appctl(ts.URL, "", "search", "--field", "maintainers.email=bob@example.com", "-o", "json")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "[]\n", out)
+
+	// a document with an id updates the App, stdin is read for -
+	doc := "id: \"1\"\n" + strings.Replace(readFile(t, "../../testdata/valid-payload1.yaml"), "Valid App 1", "Renamed App", 1)
+	code, out, _ = appctl(ts.URL, doc+"\n---\n"+readFile(t, "../../testdata/valid-payload2.yaml"), "apply", "-f", "-")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "app 1 configured\napp 2 created\n", out)
+
+	code, out, _ = appctl(ts.URL, "", "export", "-o", "ndjson")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, 2, strings.Count(out, "\n"))
+	assert.Contains(t, out, "Renamed App")
+
+	code, out, _ = appctl(ts.URL, "", "delete", "1", "2")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "app 1 deleted\napp 2 deleted\n", out)
+
+	code, _, errOut := appctl(ts.URL, "", "get", "1")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "not found")
+
+	code, _, errOut = appctl(ts.URL, "", "apply", "-f", "../../testdata/invalid-payload1.yaml")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "Version is required")
+}
+
+func TestAppctl_Authentication(t *testing.T) {
+	hash := sha256.Sum256([]byte("secret"))
+	authn, err := server.NewAuthenticator(&server.AuthFile{APIKeys: []server.APIKey{{Name: "ci", SHA256: hex.EncodeToString(hash[:])}}})
+	assert.Nil(t, err)
+	ts := httptest.NewServer(server.NewHttpServer(server.WithAuthenticator(authn)).Handler())
+	defer ts.Close()
+
+	code, _, errOut := appctl(ts.URL, "", "apply", "-f", "../../testdata/valid-payload1.yaml")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "unauthorized: authentication required")
+
+	code, out, _ := appctl(ts.URL, "", "--api-key", "secret", "apply", "-f", "../../testdata/valid-payload1.yaml")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "app 1 created\n", out)
+}
+
+func TestAppctl_Validate(t *testing.T) {
+	// validate works offline, the server is never called
+	code, out, _ := appctl("http://127.0.0.1:0", "", "validate", "-f", "../../testdata/valid-payload1.yaml")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "valid-payload1.yaml: valid\n")
+
+	code, out, _ = appctl("http://127.0.0.1:0", "", "validate", "-f", "../../testdata/invalid-payload1.yaml")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out, "invalid-payload1.yaml: invalid: ")
+
+	code, out, _ = appctl("http://127.0.0.1:0", readFile(t, "../../testdata/valid-payload1.yaml")+"\n---\nunknown: key\n", "validate", "-f", "-")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out, "-[0]: valid\n")
+	assert.Contains(t, out, "-[1]: invalid: ")
+}
+
+func TestAppctl_Usage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"get"},
+		{"apply"},
+		{"search", "extra"},
+		{"get", "1", "--unknown"},
+	} {
+		code, _, errOut := appctl("http://127.0.0.1:0", "", args...)
+		assert.Equal(t, 2, code, args)
+		assert.Contains(t, errOut, "Usage", args)
+	}
+
+	code, _, errOut := appctl("http://127.0.0.1:0", "", "get", "1", "-o", "xml")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, `unknown output format "xml"`)
+}
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	assert.Nil(t, err)
+	return string(data)
+}
@@ -1,20 +1,220 @@
 package main
 
 import (
+	"application_metadata_api_server/cache"
 	"application_metadata_api_server/server"
+	"application_metadata_api_server/server/pb"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
+	schemaValidation := flag.Bool("schema-validation", false, "validate put requests against the published App JSON Schema")
+	maxDescriptionSize := flag.Int("max-description-size", server.DefaultMaxDescriptionBytes, "description size in bytes above which validation reports a warning")
+	policyFile := flag.String("policy-file", "", "path of the organisation policy rules file, policies are disabled if empty")
+	policyReloadInterval := flag.Duration("policy-reload-interval", 10*time.Second, "how often the policy rules file is checked for changes")
+	grpcAddr := flag.String("grpc-addr", "0.0.0.0:9090", "address of the gRPC server")
+	authFile := flag.String("auth-file", "", "path of the authentication file, write requests are not authenticated if empty")
+	rbacFile := flag.String("rbac-file", "", "path of the role-based access control file, authenticated principals may write every App if empty")
+	rbacReloadInterval := flag.Duration("rbac-reload-interval", 10*time.Second, "how often the role-based access control file is checked for changes")
+	rateLimitFile := flag.String("rate-limit-file", "", "path of the rate limit file, requests are not rate limited if empty")
+	rateLimitReloadInterval := flag.Duration("rate-limit-reload-interval", 10*time.Second, "how often the rate limit file is checked for changes")
+	auditFile := flag.String("audit-file", "", "path of the audit log of the writes, in json lines, writes are not audited if empty")
+	auditMaxSize := flag.Int64("audit-max-size", 100*1024*1024, "size in bytes above which the audit log is rotated, 0 never rotates")
+	auditMaxBackups := flag.Int("audit-max-backups", 10, "number of rotated audit log files kept, at least 1 unless -audit-max-size is 0")
+	tlsCertFile := flag.String("tls-cert-file", "", "path of the server certificate, TLS is off if empty")
+	tlsKeyFile := flag.String("tls-key-file", "", "path of the server certificate key")
+	tlsClientCAFile := flag.String("tls-client-ca-file", "", "path of the CA bundle verifying client certificates, for mTLS")
+	tlsReloadInterval := flag.Duration("tls-reload-interval", time.Minute, "how often the tls files are checked for changes")
+	indexCheckInterval := flag.Duration("index-check-interval", time.Minute, "how often the search index is checked for the readiness probe")
+	maxBodySize := flag.Int64("max-body-size", 10*1024*1024, "size in bytes above which request bodies are answered 413, 0 does not limit them")
+	maxHeaderBytes := flag.Int("max-header-bytes", http.DefaultMaxHeaderBytes, "size in bytes of the request line and headers above which requests are answered 431")
+	readTimeout := flag.Duration("read-timeout", 30*time.Second, "maximum duration to read a request, body included, 0 never times out")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 10*time.Second, "maximum duration to read the headers of a request")
+	writeTimeout := flag.Duration("write-timeout", 0, "maximum duration to write a response, 0 never times out. A timeout also ends the /watch streams")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "how long a keep-alive connection waits for the next request")
+	shutdownDelay := flag.Duration("shutdown-delay", 0, "how long the servers keep serving, failing readiness, before draining on SIGTERM, so load balancers stop routing to them")
+	swaggerUIDir := flag.String("swagger-ui-dir", "", "directory of the swagger-ui-dist release served on /docs, installed by make swagger-ui, /docs is off if empty")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long the in-flight requests are waited for on SIGTERM, before their connections are closed")
+	flag.Parse()
+
+	// closed on SIGTERM, it fails /readyz, ends the watch streams and stops the reloads
+	shutdown := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	// the http and grpc servers serve the same Apps, in every namespace
+	namespaces := cache.InitNamespaces()
+	opts := []server.Option{
+		server.WithNamespaces(namespaces),
+		server.WithMaxDescriptionSize(*maxDescriptionSize),
+		server.WithMaxBodySize(*maxBodySize),
+		server.WithShutdown(shutdown),
+		server.WithSwaggerUIDir(*swaggerUIDir),
+	}
+	if *schemaValidation {
+		opts = append(opts, server.WithSchemaValidation())
+	}
+	if *policyFile != "" {
+		policies, err := server.LoadPolicyEngine(*policyFile)
+		if err != nil {
+			log.Fatalf("failed to load policy file %s: %+v", *policyFile, err)
+		}
+		go policies.Watch(*policyReloadInterval, shutdown)
+		opts = append(opts, server.WithPolicyEngine(policies))
+	}
+
+	grpcOpts := make([]grpc.ServerOption, 0)
+	if *maxBodySize > 0 {
+		grpcOpts = append(grpcOpts, grpc.MaxRecvMsgSize(int(*maxBodySize)))
+	}
+	unaryInterceptors := make([]grpc.UnaryServerInterceptor, 0)
+	streamInterceptors := make([]grpc.StreamServerInterceptor, 0)
+	var limiter *server.RateLimiter
+	if *rateLimitFile != "" {
+		var err error
+		if limiter, err = server.LoadRateLimiter(*rateLimitFile); err != nil {
+			log.Fatalf("failed to load rate limit file %s: %+v", *rateLimitFile, err)
+		}
+		go limiter.Watch(*rateLimitReloadInterval, shutdown)
+		opts = append(opts, server.WithRateLimiter(limiter))
+	}
+	if *authFile != "" {
+		authn, err := server.LoadAuthenticator(*authFile)
+		if err != nil {
+			log.Fatalf("failed to load auth file %s: %+v", *authFile, err)
+		}
+		opts = append(opts, server.WithAuthenticator(authn))
+		// the failed authentications are charged to the limiter
+		unary, stream := server.GrpcAuthInterceptors(authn, limiter)
+		unaryInterceptors, streamInterceptors = append(unaryInterceptors, unary), append(streamInterceptors, stream)
+	}
+	if limiter != nil {
+		// after authentication, to limit the principals
+		unary, stream := server.GrpcRateLimitInterceptors(limiter)
+		unaryInterceptors, streamInterceptors = append(unaryInterceptors, unary), append(streamInterceptors, stream)
+	}
+	grpcOpts = append(grpcOpts, grpc.ChainUnaryInterceptor(unaryInterceptors...), grpc.ChainStreamInterceptor(streamInterceptors...))
+	if *rbacFile != "" {
+		authz, err := server.LoadAuthorizer(*rbacFile)
+		if err != nil {
+			log.Fatalf("failed to load rbac file %s: %+v", *rbacFile, err)
+		}
+		go authz.Watch(*rbacReloadInterval, shutdown)
+		opts = append(opts, server.WithAuthorizer(authz))
+	}
+	var audit *server.AuditLog
+	if *auditFile != "" {
+		var err error
+		audit, err = server.OpenAuditLog(*auditFile, *auditMaxSize, *auditMaxBackups)
+		if err != nil {
+			log.Fatalf("failed to open audit log %s: %+v", *auditFile, err)
+		}
+		opts = append(opts, server.WithAuditLog(audit))
+	}
+	metrics := server.NewMetrics(namespaces)
+	opts = append(opts, server.WithMetrics(metrics))
+	var tlsConfig *tls.Config
+	if *tlsCertFile != "" {
+		tlsFiles, err := server.LoadTLSFiles(*tlsCertFile, *tlsKeyFile, *tlsClientCAFile)
+		if err != nil {
+			log.Fatalf("failed to load tls files: %+v", err)
+		}
+		go tlsFiles.Watch(*tlsReloadInterval, shutdown)
+		tlsConfig = tlsFiles.Config()
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	log.Infof("Starting grpc server on %s...", *grpcAddr)
+	listener, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %+v", *grpcAddr, err)
+	}
+	grpcServer := grpc.NewServer(grpcOpts...)
+	pb.RegisterAppServiceServer(grpcServer, server.NewGrpcServer(opts...))
+	go func() {
+		// returns nil once stopped
+		if err := grpcServer.Serve(listener); err != nil {
+			log.Fatalf("grpc server failed: %+v", err)
+		}
+	}()
+
 	log.Infof("Starting http httpServer...")
-	httpServer := server.NewHttpServer()
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok\n"))
-	})
-	http.HandleFunc("/put", httpServer.PutHandler)
-	http.HandleFunc("/get", httpServer.GetHandler)
-	http.HandleFunc("/query", httpServer.SearchHandler)
-	http.ListenAndServe("0.0.0.0:8080", nil)
+	httpServer := server.NewHttpServer(opts...)
+	mux := http.NewServeMux()
+	health := server.NewHealth()
+	health.AddLivenessCheck("store", server.StoreLockCheck(namespaces))
+	health.AddReadinessCheck("shutdown", server.ShutdownCheck(shutdown))
+	health.AddReadinessCheck("index", server.IndexCheck(namespaces, *indexCheckInterval, shutdown))
+	if audit != nil {
+		health.AddReadinessCheck("audit-log", audit.Check)
+	}
+	healthHandler := health.Handler()
+	for _, pattern := range []string{"/livez", "/livez/", "/readyz", "/readyz/"} {
+		mux.Handle(pattern, healthHandler)
+	}
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/", httpServer.Handler())
+	srv := &http.Server{
+		Addr:              "0.0.0.0:8080",
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       *readTimeout,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+	}
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("http server failed: %+v", err)
+		}
+	}()
+
+	sig := <-signals
+	log.Infof("Received %s, shutting down...", sig)
+	close(shutdown)
+	time.Sleep(*shutdownDelay)
+	// drain the in-flight requests of both servers, the watch streams are ended by shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warnf("http requests still in flight after %s, closing their connections: %+v", *shutdownTimeout, err)
+		srv.Close()
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		log.Warnf("grpc calls still in flight after %s, closing their connections", *shutdownTimeout)
+		grpcServer.Stop()
+	}
+	// the Apps are in memory, the audit log is the only file to flush
+	if audit != nil {
+		if err := audit.Close(); err != nil {
+			log.Errorf("failed to flush audit log %s: %+v", *auditFile, err)
+		}
+	}
+	log.Infof("Stopped")
 }
@@ -0,0 +1,15 @@
+package api
+
+// Kind is the kind of every versioned App document
+const Kind = "App"
+
+// Convertible is implemented by the versioned App types (v1, v2...).
+// App in this package is the hub: it is what gets stored and indexed, and every version converts through it.
+type Convertible interface {
+	// GetAPIVersion returns the apiVersion of the document
+	GetAPIVersion() string
+	// ConvertTo converts this version to the hub
+	ConvertTo(hub *App) error
+	// ConvertFrom converts the hub to this version, dropping what the version cannot represent
+	ConvertFrom(hub *App) error
+}
@@ -36,6 +36,17 @@ type App struct {
 	Labels map[string]string `json:"labels,omitempty"`
 
 	Release Release `json:"release,omitempty"`
+
+	// ReleaseHistory holds the releases before Release, newest first
+	ReleaseHistory []Release `json:"releaseHistory,omitempty"`
+
+	Dependencies []Dependency `json:"dependencies,omitempty" validate:"dive"`
+}
+
+type Dependency struct {
+	Name string `json:"name" validate:"required"`
+
+	Version string `json:"version,omitempty"`
 }
 
 type Id string
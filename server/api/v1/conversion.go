@@ -0,0 +1,55 @@
+package v1
+
+import "application_metadata_api_server/server/api"
+
+func (a *App) GetAPIVersion() string {
+	return APIVersion
+}
+
+// ConvertTo converts a v1 App to the hub
+func (a *App) ConvertTo(hub *api.App) error {
+	hub.Id = a.Id
+	hub.Title = a.Title
+	hub.Version = a.Version
+	hub.Maintainers = make([]api.Maintainer, 0, len(a.Maintainers))
+	for _, m := range a.Maintainers {
+		hub.Maintainers = append(hub.Maintainers, api.Maintainer(m))
+	}
+	hub.Company = a.Company
+	hub.Website = a.Website
+	hub.Source = a.Source
+	hub.License = a.License
+	hub.Description = a.Description
+	hub.Labels = a.Labels
+	hub.Release = api.Release{
+		Name:    a.Release.Name,
+		Comment: a.Release.Comment,
+		Author:  api.Maintainer(a.Release.Author),
+	}
+	return nil
+}
+
+// ConvertFrom converts the hub to a v1 App, the release history and dependencies are dropped
+func (a *App) ConvertFrom(hub *api.App) error {
+	a.APIVersion = APIVersion
+	a.Kind = api.Kind
+	a.Id = hub.Id
+	a.Title = hub.Title
+	a.Version = hub.Version
+	a.Maintainers = make([]Maintainer, 0, len(hub.Maintainers))
+	for _, m := range hub.Maintainers {
+		a.Maintainers = append(a.Maintainers, Maintainer(m))
+	}
+	a.Company = hub.Company
+	a.Website = hub.Website
+	a.Source = hub.Source
+	a.License = hub.License
+	a.Description = hub.Description
+	a.Labels = hub.Labels
+	a.Release = Release{
+		Name:    hub.Release.Name,
+		Comment: hub.Release.Comment,
+		Author:  Maintainer(hub.Release.Author),
+	}
+	return nil
+}
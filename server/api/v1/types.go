@@ -0,0 +1,48 @@
+package v1
+
+import "application_metadata_api_server/server/api"
+
+// APIVersion is the apiVersion of this package, documents without apiVersion are v1
+const APIVersion = "v1"
+
+type Maintainer struct {
+	Name string `json:"name" validate:"required"`
+
+	Email string `json:"email" validate:"required,email"`
+}
+
+type Release struct {
+	Name string `json:"name,omitempty"`
+
+	Comment string `json:"comment,omitempty"`
+
+	Author Maintainer `json:"author,omitempty"`
+}
+
+type App struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	Kind string `json:"kind,omitempty"`
+
+	Id api.Id `json:"id,omitempty"`
+
+	Title string `json:"title" validate:"required"`
+
+	Version string `json:"version" validate:"required"`
+
+	Maintainers []Maintainer `json:"maintainers" validate:"required"`
+
+	Company string `json:"company" validate:"required"`
+
+	Website string `json:"website" validate:"required"`
+
+	Source string `json:"source" validate:"required"`
+
+	License string `json:"license" validate:"required"`
+
+	Description string `json:"description"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Release Release `json:"release,omitempty"`
+}
@@ -0,0 +1,82 @@
+package v2
+
+import "application_metadata_api_server/server/api"
+
+func (a *App) GetAPIVersion() string {
+	return APIVersion
+}
+
+// ConvertTo converts a v2 App to the hub, the first release becomes the hub Release and the others its history
+func (a *App) ConvertTo(hub *api.App) error {
+	hub.Id = a.Id
+	hub.Title = a.Title
+	hub.Version = a.Version
+	hub.Maintainers = make([]api.Maintainer, 0, len(a.Maintainers))
+	for _, m := range a.Maintainers {
+		hub.Maintainers = append(hub.Maintainers, api.Maintainer(m))
+	}
+	hub.Company = a.Company
+	hub.Website = a.Website
+	hub.Source = a.Source
+	hub.License = a.License
+	hub.Description = a.Description
+	hub.Labels = a.Labels
+	hub.Release = api.Release{}
+	hub.ReleaseHistory = nil
+	for i, r := range a.Releases {
+		release := api.Release{
+			Name:    r.Name,
+			Comment: r.Comment,
+			Author:  api.Maintainer(r.Author),
+		}
+		if i == 0 {
+			hub.Release = release
+			continue
+		}
+		hub.ReleaseHistory = append(hub.ReleaseHistory, release)
+	}
+	hub.Dependencies = nil
+	for _, d := range a.Dependencies {
+		hub.Dependencies = append(hub.Dependencies, api.Dependency(d))
+	}
+	return nil
+}
+
+// ConvertFrom converts the hub to a v2 App
+func (a *App) ConvertFrom(hub *api.App) error {
+	a.APIVersion = APIVersion
+	a.Kind = api.Kind
+	a.Id = hub.Id
+	a.Title = hub.Title
+	a.Version = hub.Version
+	a.Maintainers = make([]Maintainer, 0, len(hub.Maintainers))
+	for _, m := range hub.Maintainers {
+		a.Maintainers = append(a.Maintainers, Maintainer(m))
+	}
+	a.Company = hub.Company
+	a.Website = hub.Website
+	a.Source = hub.Source
+	a.License = hub.License
+	a.Description = hub.Description
+	a.Labels = hub.Labels
+	a.Releases = nil
+	if hub.Release != (api.Release{}) {
+		a.Releases = append(a.Releases, Release{
+			Name:    hub.Release.Name,
+			Comment: hub.Release.Comment,
+			Author:  Maintainer(hub.Release.Author),
+		})
+	}
+	for _, r := range hub.ReleaseHistory {
+		a.Releases = append(a.Releases, Release{
+			Name:    r.Name,
+			Comment: r.Comment,
+			Author:  Maintainer(r.Author),
+		})
+	}
+	a.Dependencies = nil
+	for _, d := range hub.Dependencies {
+		a.Dependencies = append(a.Dependencies, Dependency(d))
+	}
+	return nil
+}
@@ -0,0 +1,524 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultAuditLimit is the number of records returned by an audit query without limit
+	defaultAuditLimit = 100
+	// maxAuditLimit is the largest limit of an audit query
+	maxAuditLimit = 10000
+)
+
+// AuditRecord is a line of the audit log, one per App written by a put, update or delete
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Principal is the authenticated principal of the write, nil for anonymous requests
+	Principal *Principal `json:"principal,omitempty"`
+	// Action is the write, one of VerbCreate, VerbUpdate or VerbDelete
+	Action    Verb   `json:"action"`
+	Namespace string `json:"namespace"`
+	Id        api.Id `json:"id"`
+	// Revision is the revision of the store after the write, the Revision of its watch event
+	Revision int64 `json:"revision"`
+	// Diff is the RFC 6902 JSON patch from the old document to the new one. Every replace and remove is
+	// preceded by a test of the old value, so the old document can be rebuilt from the new one.
+	Diff []jsonPatchOp `json:"diff"`
+}
+
+// AuditFilter selects the records of an audit query, the zero value of a field matches every record
+type AuditFilter struct {
+	// Principal is the name of the principal, "-" selects the anonymous writes
+	Principal string
+	Action    Verb
+	Namespace string
+	Id        api.Id
+	Since     time.Time
+	Until     time.Time
+	// Limit is the number of records returned, the most recent ones
+	Limit int
+}
+
+// AuditLog is an append-only log of the writes on the Apps, in json lines, rotated when it grows over
+// a maximum size. Share it between the http and grpc servers with WithAuditLog.
+type AuditLog struct {
+	// lock guards the file
+	lock sync.Mutex
+	file *rotatingFile
+	// storeLocks serialize the audited writes of each store, for each record to have the revision of its write
+	storeLocksLock sync.Mutex
+	storeLocks     map[cache.Store]*sync.Mutex
+	now            func() time.Time
+}
+
+// OpenAuditLog appends to the audit log at path, moved to path.1 when it exceeds maxSize bytes,
+// path.1 to path.2 and so on up to maxBackups files. A maxSize of 0 never rotates, otherwise at least one backup is
+// kept for the records of the rotated file to remain readable.
+func OpenAuditLog(path string, maxSize int64, maxBackups int) (*AuditLog, error) {
+	if maxSize > 0 && maxBackups < 1 {
+		return nil, fmt.Errorf("invalid audit log max backups %d, expecting at least 1 when the log is rotated", maxBackups)
+	}
+	file := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: file, storeLocks: make(map[cache.Store]*sync.Mutex), now: time.Now}, nil
+}
+
+// Close flushes the records to the disk and closes the audit log file, call it once the servers are stopped
+func (a *AuditLog) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.file.close()
+}
+
+// Check fails when the audit log cannot be written: its file was removed or replaced, or the records
+// cannot be flushed to the disk. It is a readiness check, the records written while it fails may be lost.
+func (a *AuditLog) Check(context.Context) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.file.check()
+}
+
+// auditedWrite is a write to record, raw is the new document of creates and updates
+type auditedWrite struct {
+	verb Verb
+	id   api.Id
+	raw  []byte
+}
+
+// auditCreate runs add, storing a new App in store, and records it
+func (a *AuditLog) auditCreate(ctx context.Context, store cache.Store, raw []byte, add func() (api.Id, error)) (api.Id, error) {
+	ids, err := a.audit(ctx, store, []auditedWrite{{verb: VerbCreate, raw: raw}}, func() ([]api.Id, error) {
+		id, err := add()
+		return []api.Id{id}, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// auditWrite runs write, updating or deleting the App id of store, and records it
+func (a *AuditLog) auditWrite(ctx context.Context, store cache.Store, verb Verb, id api.Id, raw []byte, write func() error) error {
+	_, err := a.audit(ctx, store, []auditedWrite{{verb: verb, id: id, raw: raw}}, func() ([]api.Id, error) {
+		return nil, write()
+	})
+	return err
+}
+
+// audit runs write, applying writes in order to store and returning the Ids of the created Apps, and records
+// every write with the principal and the namespace of ctx. Without AuditLog write just runs.
+// A record failing to be written is logged, the write is done already.
+func (a *AuditLog) audit(ctx context.Context, store cache.Store, writes []auditedWrite, write func() ([]api.Id, error)) ([]api.Id, error) {
+	if a == nil {
+		return write()
+	}
+	lock := a.storeLock(store)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// the documents as left by the previous writes
+	docs := make(map[api.Id][]byte)
+	for _, w := range writes {
+		if _, ok := docs[w.id]; !ok && w.verb != VerbCreate {
+			// a missing App fails the write
+			docs[w.id], _ = store.Get(w.id)
+		}
+	}
+	ids, err := write()
+	if err != nil {
+		return ids, err
+	}
+
+	// every write is an event of the store, none by other writers since the lock is held
+	revision := store.Revision() - int64(len(writes))
+	created := ids
+	principal, namespace := PrincipalFromContext(ctx), NamespaceFromContext(ctx)
+	now := a.now().UTC()
+	for _, w := range writes {
+		revision++
+		id := w.id
+		if w.verb == VerbCreate {
+			id, created = created[0], created[1:]
+		}
+		diff, err := diffDocuments(docs[id], w.raw)
+		if err != nil {
+			log.Errorf("failed to diff app %s for the audit log: %+v", id, err)
+		}
+		docs[id] = w.raw
+		record := &AuditRecord{Time: now, Principal: principal, Action: w.verb, Namespace: namespace, Id: id,
+			Revision: revision, Diff: diff}
+		if err := a.append(record); err != nil {
+			log.Errorf("failed to write the audit record of %s app %s: %+v", w.verb, id, err)
+		}
+	}
+	return ids, nil
+}
+
+func (a *AuditLog) storeLock(store cache.Store) *sync.Mutex {
+	a.storeLocksLock.Lock()
+	defer a.storeLocksLock.Unlock()
+	lock, ok := a.storeLocks[store]
+	if !ok {
+		lock = &sync.Mutex{}
+		a.storeLocks[store] = lock
+	}
+	return lock
+}
+
+func (a *AuditLog) append(record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.file.write(append(line, '\n'))
+}
+
+// Query returns the most recent records matching filter, oldest first, from the audit log and its backups
+func (a *AuditLog) Query(filter AuditFilter) ([]AuditRecord, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditLimit
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	rs := make([]AuditRecord, 0)
+	for _, path := range a.file.paths() {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rs, err = readAuditRecords(f, filter, rs)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	return rs, nil
+}
+
+// readAuditRecords appends the records of r matching filter to rs, keeping the last filter.Limit ones
+func readAuditRecords(r io.Reader, filter AuditFilter, rs []AuditRecord) ([]AuditRecord, error) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			record := AuditRecord{}
+			if err := json.Unmarshal(line, &record); err != nil {
+				return nil, err
+			}
+			if filter.matches(&record) {
+				rs = append(rs, record)
+				if len(rs) > filter.Limit {
+					rs = rs[1:]
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return rs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *AuditFilter) matches(record *AuditRecord) bool {
+	switch {
+	case f.Principal == "-" && record.Principal != nil:
+		return false
+	case f.Principal != "" && f.Principal != "-" && (record.Principal == nil || record.Principal.Name != f.Principal):
+		return false
+	case f.Action != "" && record.Action != f.Action:
+		return false
+	case f.Namespace != "" && record.Namespace != f.Namespace:
+		return false
+	case f.Id != "" && record.Id != f.Id:
+		return false
+	case !f.Since.IsZero() && record.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !record.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// auditFilterFromQuery reads an AuditFilter from the query parameters principal, action, namespace, id,
+// since and until (RFC 3339) and limit
+func auditFilterFromQuery(query map[string][]string) (AuditFilter, error) {
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	filter := AuditFilter{
+		Principal: get("principal"),
+		Action:    Verb(get("action")),
+		Namespace: get("namespace"),
+		Id:        api.Id(get("id")),
+		Limit:     defaultAuditLimit,
+	}
+	switch filter.Action {
+	case "", VerbCreate, VerbUpdate, VerbDelete:
+	default:
+		return filter, fmt.Errorf("invalid action %q, expecting %s, %s or %s", filter.Action, VerbCreate, VerbUpdate, VerbDelete)
+	}
+	for key, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := get(key); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s %q, expecting an RFC 3339 time", key, value)
+			}
+			*t = parsed
+		}
+	}
+	if value := get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			return filter, fmt.Errorf("invalid limit %q, expecting a number from 1 to %d", value, maxAuditLimit)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// AuditHandler responds with the records of the audit log matching the query parameters.
+// It needs an authenticated principal when requests are authenticated, allowed to search every namespace
+// when they are authorized.
+func (h *httpServerImpl) AuditHandler(w http.ResponseWriter, req *http.Request) {
+	if h.audit == nil {
+		handleNotFoundError(w, errors.New("the audit log is not enabled"))
+		return
+	}
+	if h.authn != nil && PrincipalFromContext(req.Context()) == nil {
+		handleUnauthorizedError(w, errAuthenticationRequired)
+		return
+	}
+	if err := authorizeAllNamespaces(h.authz, req.Context()); err != nil {
+		handleForbiddenError(w, err)
+		return
+	}
+	filter, err := auditFilterFromQuery(req.URL.Query())
+	if err != nil {
+		handleValidationError(w, err)
+		return
+	}
+	records, err := h.audit.Query(filter)
+	if err != nil {
+		handleInternalError(w, err, "failed to query the audit log")
+		return
+	}
+	items := make([]interface{}, 0, len(records))
+	for i := range records {
+		items = append(items, &records[i])
+	}
+	writeList(w, req, "records", items)
+}
+
+// diffDocuments returns the JSON patch from the yaml or json document old to new, either of them nil
+// for creates and deletes
+func diffDocuments(old, new []byte) ([]jsonPatchOp, error) {
+	var oldDoc, newDoc interface{}
+	var err error
+	if old != nil {
+		if oldDoc, err = decodeGeneric(old); err != nil {
+			return nil, err
+		}
+	}
+	if new != nil {
+		if newDoc, err = decodeGeneric(new); err != nil {
+			return nil, err
+		}
+	}
+	ops := make([]jsonPatchOp, 0)
+	switch {
+	case old == nil:
+		return appendPatchOp(ops, "add", "", newDoc)
+	case new == nil:
+		if ops, err = appendPatchOp(ops, "test", "", oldDoc); err != nil {
+			return nil, err
+		}
+		return appendPatchOp(ops, "remove", "", nil)
+	}
+	return diffValues(ops, "", oldDoc, newDoc)
+}
+
+// diffValues appends the operations changing old to new at path. Objects are compared key by key,
+// arrays and scalars are replaced as a whole.
+func diffValues(ops []jsonPatchOp, path string, old, new interface{}) ([]jsonPatchOp, error) {
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if !oldIsMap || !newIsMap {
+		if reflect.DeepEqual(old, new) {
+			return ops, nil
+		}
+		ops, err := appendPatchOp(ops, "test", path, old)
+		if err != nil {
+			return nil, err
+		}
+		return appendPatchOp(ops, "replace", path, new)
+	}
+	keys := make([]string, 0, len(oldMap)+len(newMap))
+	for k := range oldMap {
+		keys = append(keys, k)
+	}
+	for k := range newMap {
+		if _, ok := oldMap[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var err error
+	for _, k := range keys {
+		child := path + "/" + escapePointerToken(k)
+		oldValue, inOld := oldMap[k]
+		newValue, inNew := newMap[k]
+		switch {
+		case !inOld:
+			ops, err = appendPatchOp(ops, "add", child, newValue)
+		case !inNew:
+			if ops, err = appendPatchOp(ops, "test", child, oldValue); err == nil {
+				ops, err = appendPatchOp(ops, "remove", child, nil)
+			}
+		default:
+			ops, err = diffValues(ops, child, oldValue, newValue)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// appendPatchOp appends an operation, with value unless it is a remove
+func appendPatchOp(ops []jsonPatchOp, op, path string, value interface{}) ([]jsonPatchOp, error) {
+	patchOp := jsonPatchOp{Op: op, Path: path}
+	if op != "remove" {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		patchOp.Value = data
+	}
+	return append(ops, patchOp), nil
+}
+
+// escapePointerToken escapes a key as an RFC 6901 JSON pointer token
+func escapePointerToken(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// rotatingFile is an append-only file moved to numbered backups when it grows over maxSize bytes
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) write(data []byte) error {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(data)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+	return err
+}
+
+// rotate moves the file to path.1, the backups to the next number, and drops the backups beyond maxBackups
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(r.backup(i), r.backup(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(r.path, r.backup(1)); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+// paths returns the backups and the file, oldest first
+func (r *rotatingFile) paths() []string {
+	rs := make([]string, 0, r.maxBackups+1)
+	for i := r.maxBackups; i >= 1; i-- {
+		rs = append(rs, r.backup(i))
+	}
+	return append(rs, r.path)
+}
+
+// check verifies the open file is still the file at path, and flushes it
+func (r *rotatingFile) check() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	openInfo, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, openInfo) {
+		return fmt.Errorf("%s was replaced since it was opened", r.path)
+	}
+	return r.file.Sync()
+}
+
+// close flushes the records to the disk and closes the file
+func (r *rotatingFile) close() error {
+	if err := r.file.Sync(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
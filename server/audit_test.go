@@ -0,0 +1,217 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"application_metadata_api_server/server/pb"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"sigs.k8s.io/yaml"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	for _, tc := range []struct {
+		old, new string
+		expected string
+	}{
+		{"", "title: a", `[{"op": "add", "path": "", "value": {"title": "a"}}]`},
+		{"title: a", "", `[{"op": "test", "path": "", "value": {"title": "a"}}, {"op": "remove", "path": ""}]`},
+		{"title: a", `{"title": "a"}`, `[]`},
+		{
+			"title: a\nversion: 1.0.0\nlabels: {env: prod, a/b: c}\nmaintainers: [{email: a@b.com}]",
+			"title: b\nlabels: {env: prod}\nmaintainers: [{email: a@b.com}, {email: c@d.com}]\ndescription: d",
+			`[
+				{"op": "add", "path": "/description", "value": "d"},
+				{"op": "test", "path": "/labels/a~1b", "value": "c"},
+				{"op": "remove", "path": "/labels/a~1b"},
+				{"op": "test", "path": "/maintainers", "value": [{"email": "a@b.com"}]},
+				{"op": "replace", "path": "/maintainers", "value": [{"email": "a@b.com"}, {"email": "c@d.com"}]},
+				{"op": "test", "path": "/title", "value": "a"},
+				{"op": "replace", "path": "/title", "value": "b"},
+				{"op": "test", "path": "/version", "value": "1.0.0"},
+				{"op": "remove", "path": "/version"}
+			]`,
+		},
+	} {
+		var old, new []byte
+		if tc.old != "" {
+			old = []byte(tc.old)
+		}
+		if tc.new != "" {
+			new = []byte(tc.new)
+		}
+		diff, err := diffDocuments(old, new)
+		assert.Nil(t, err)
+		data, err := json.Marshal(diff)
+		assert.Nil(t, err)
+		assert.JSONEq(t, tc.expected, string(data), "%s -> %s", tc.old, tc.new)
+
+		// the diff is a JSON patch from old to new
+		if old != nil && new != nil {
+			patched, err := applyJSONPatch(old, data)
+			assert.Nil(t, err)
+			expected, _ := decodeGeneric(new)
+			actual, _ := decodeGeneric(patched)
+			assert.Equal(t, expected, actual)
+		}
+	}
+}
+
+func TestAuditLog_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	_, err := OpenAuditLog(path, 400, 0)
+	assert.NotNil(t, err)
+
+	audit, err := OpenAuditLog(path, 400, 2)
+	assert.Nil(t, err)
+	defer audit.Close()
+	store := cache.InitStore()
+	ctx := withPrincipal(context.Background(), &Principal{Name: "ci", Method: AuthMethodAPIKey})
+
+	for i := 0; i < 10; i++ {
+		raw := []byte(fmt.Sprintf("title: app %d", i))
+		_, err := audit.auditCreate(ctx, store, raw, func() (api.Id, error) {
+			return store.Add(&api.App{Title: string(raw)}, raw)
+		})
+		assert.Nil(t, err)
+	}
+	for _, backup := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(backup)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(400))
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	// the records of the dropped backups are lost, the others are read oldest first
+	records, err := audit.Query(AuditFilter{})
+	assert.Nil(t, err)
+	assert.Less(t, len(records), 10)
+	assert.Equal(t, int64(10), records[len(records)-1].Revision)
+	for i := 1; i < len(records); i++ {
+		assert.Equal(t, records[i-1].Revision+1, records[i].Revision)
+	}
+	records, err = audit.Query(AuditFilter{Limit: 2, Principal: "ci", Action: VerbCreate})
+	assert.Nil(t, err)
+	assert.Equal(t, []api.Id{"9", "10"}, []api.Id{records[0].Id, records[1].Id})
+	records, err = audit.Query(AuditFilter{Principal: "-"})
+	assert.Nil(t, err)
+	assert.Empty(t, records)
+}
+
+func TestHttpServerImpl_Handler_Audit(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{
+		{Name: "root", SHA256: apiKeySHA256("root"), Groups: []string{"platform"}},
+		{Name: "ci", SHA256: apiKeySHA256("ci"), Groups: []string{"ci"}},
+	}})
+	assert.Nil(t, err)
+	authz, err := NewAuthorizer(&RBACFile{Bindings: []RoleBinding{
+		{Name: "platform", Role: RoleAdmin, Subjects: RoleSubjects{Groups: []string{"platform"}}},
+		{Name: "ci", Role: RoleEditor, Subjects: RoleSubjects{Groups: []string{"ci"}}},
+	}})
+	assert.Nil(t, err)
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+	assert.Nil(t, err)
+	defer audit.Close()
+	namespaces := cache.InitNamespaces()
+	handler := NewHttpServer(WithNamespaces(namespaces), WithAuthenticator(authn), WithAuthorizer(authz), WithAuditLog(audit)).Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	do := func(method, path, apiKey, namespace, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(apiKeyHeader, apiKey)
+		req.Header.Set(namespaceHeader, namespace)
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	events, cancel := namespaces.Store("payments").Watch()
+	defer cancel()
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/apps", "ci", "payments", "", string(data)).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPatch, "/apps/1", "ci", "payments", mediaTypeMergePatch, `{"description": "patched"}`).Code)
+	jsonData, err := yaml.YAMLToJSON(data)
+	assert.Nil(t, err)
+	rr := do(http.MethodPost, "/batch", "ci", "payments", mediaTypeJSON,
+		fmt.Sprintf(`{"operations": [{"op": "create", "document": %s}, {"op": "delete", "id": "1"}]}`, jsonData))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	// denied and failed writes are not recorded
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/apps/1", "ci", "payments", "", "").Code)
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/apps", "root", "", "", string(data)).Code)
+
+	query := func(apiKey, params string) []AuditRecord {
+		rr := do(http.MethodGet, "/audit"+params, apiKey, "", "", "")
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		resp := auditResponse{}
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp.Records
+	}
+	records := query("root", "?namespace=payments")
+	assert.Len(t, records, 4)
+	for i, expected := range []struct {
+		action Verb
+		id     api.Id
+	}{{VerbCreate, "1"}, {VerbUpdate, "1"}, {VerbCreate, "2"}, {VerbDelete, "1"}} {
+		assert.Equal(t, expected.action, records[i].Action)
+		assert.Equal(t, expected.id, records[i].Id)
+		assert.Equal(t, "ci", records[i].Principal.Name)
+		assert.Equal(t, "payments", records[i].Namespace)
+		// the revisions are those of the watch events
+		event := <-events
+		assert.Equal(t, event.Revision, records[i].Revision)
+		assert.Equal(t, event.Id, records[i].Id)
+	}
+	diff, err := json.Marshal(records[1].Diff)
+	assert.Nil(t, err)
+	assert.Contains(t, string(diff), `{"op":"replace","path":"/description","value":"patched"}`)
+
+	assert.Len(t, query("root", "?principal=root"), 1)
+	assert.Len(t, query("root", "?action=delete&id=1"), 1)
+	assert.Len(t, query("root", "?limit=2"), 2)
+	assert.Empty(t, query("root", "?since="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/audit?action=read", "root", "", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/audit?limit=0", "root", "", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/audit", "ci", "", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/audit", "", "", "", "").Code)
+}
+
+func TestGrpcServerImpl_Audit(t *testing.T) {
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+	assert.Nil(t, err)
+	defer audit.Close()
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{{Name: "ci", SHA256: apiKeySHA256("secret")}}})
+	assert.Nil(t, err)
+	unary, stream := GrpcAuthInterceptors(authn, nil)
+	client := newGrpcTestClientWithServerOptions(t, []grpc.ServerOption{grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream)},
+		WithAuditLog(audit))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "secret")
+
+	resp, err := client.Put(ctx, &pb.PutRequest{App: newTestProtoApp("grpc app")})
+	assert.Nil(t, err)
+	_, err = client.Update(ctx, &pb.UpdateRequest{Id: resp.GetId(), App: newTestProtoApp("grpc app 2")})
+	assert.Nil(t, err)
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Id: resp.GetId()})
+	assert.Nil(t, err)
+
+	records, err := audit.Query(AuditFilter{Principal: "ci", Id: api.Id(resp.GetId())})
+	assert.Nil(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, []Verb{VerbCreate, VerbUpdate, VerbDelete}, []Verb{records[0].Action, records[1].Action, records[2].Action})
+	assert.Equal(t, int64(3), records[2].Revision)
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"application_metadata_api_server/server/pb"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// apiKeyHeader is the request header (and grpc metadata key, lower case) carrying an API key
+	apiKeyHeader = "X-API-Key"
+
+	// AuthMethodAPIKey is the Method of principals authenticated by an API key
+	AuthMethodAPIKey = "api-key"
+	// AuthMethodJWT is the Method of principals authenticated by a bearer JWT
+	AuthMethodJWT = "jwt"
+	// AuthMethodMTLS is the Method of principals authenticated by a client certificate
+	AuthMethodMTLS = "mtls"
+)
+
+// errAuthenticationRequired is returned for write requests without credentials
+var errAuthenticationRequired = errors.New("authentication required")
+
+// Principal is the authenticated identity of a request
+type Principal struct {
+	// Name is the name of the API key, the sub claim of the JWT or the common name of the client certificate
+	Name string `json:"name"`
+	// Method is how the principal was authenticated, one of the AuthMethod constants
+	Method string `json:"method"`
+	// Email is the email of the API key, the email claim of the JWT or the email address of the certificate, if any
+	Email string `json:"email,omitempty"`
+	// Groups are the groups of the API key, the groups claim of the JWT or the organizational units of the certificate
+	Groups []string `json:"groups,omitempty"`
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal authenticated for a request, nil for anonymous requests
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// Credentials are what a request presents to authenticate, each of them is optional
+type Credentials struct {
+	APIKey      string
+	BearerToken string
+	// Certificates is the verified chain of the client certificate, leaf first, when TLS is on
+	Certificates []*x509.Certificate
+}
+
+// Authenticator authenticates the credentials of requests
+type Authenticator interface {
+	// Authenticate returns the principal of credentials, nil for empty credentials, and an error for invalid ones
+	Authenticate(creds Credentials) (*Principal, error)
+}
+
+// AuthFile is the authentication file loaded by LoadAuthenticator, e.g.
+//
+//	apiKeys:
+//	  - name: ci
+//	    # sha256 of the key, hex encoded: echo -n "$KEY" | sha256sum
+//	    sha256: c018c41c1afaf2c0b66c64f97d0ee135657b699ad260f299234cd40a5d625e0e
+//	    groups: [writers]
+//	jwt:
+//	  jwksFile: /etc/application_metadata_api_server/jwks.json
+//	  issuer: https://issuer.example.com
+//	  audience: application_metadata_api_server
+//	clientCertificates: true
+type AuthFile struct {
+	APIKeys []APIKey   `json:"apiKeys,omitempty"`
+	JWT     *JWTConfig `json:"jwt,omitempty"`
+	// ClientCertificates authenticates the requests presenting a client certificate verified by the TLS client CA
+	ClientCertificates bool `json:"clientCertificates,omitempty"`
+}
+
+// APIKey is a static API key, only its hash is configured
+type APIKey struct {
+	Name string `json:"name"`
+	// SHA256 is the hex encoded sha256 of the key
+	SHA256 string   `json:"sha256"`
+	Email  string   `json:"email,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// FileAuthenticator is the Authenticator configured by an AuthFile
+type FileAuthenticator struct {
+	apiKeys            []apiKeyHash
+	jwt                *jwtVerifier
+	clientCertificates bool
+}
+
+type apiKeyHash struct {
+	hash      []byte
+	principal *Principal
+}
+
+// LoadAuthenticator reads the authentication file at path, and the JWKS file it refers to
+func LoadAuthenticator(path string) (*FileAuthenticator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &AuthFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, err
+	}
+	return NewAuthenticator(file)
+}
+
+// NewAuthenticator returns the Authenticator of an AuthFile
+func NewAuthenticator(file *AuthFile) (*FileAuthenticator, error) {
+	a := &FileAuthenticator{clientCertificates: file.ClientCertificates}
+	names := make(map[string]bool)
+	for i, key := range file.APIKeys {
+		hash, err := hex.DecodeString(key.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("apiKeys[%d]: sha256 must be a hex encoded sha256", i)
+		}
+		if key.Name == "" || names[key.Name] {
+			return nil, fmt.Errorf("apiKeys[%d]: name must be set and unique", i)
+		}
+		names[key.Name] = true
+		a.apiKeys = append(a.apiKeys, apiKeyHash{
+			hash:      hash,
+			principal: &Principal{Name: key.Name, Method: AuthMethodAPIKey, Email: key.Email, Groups: key.Groups},
+		})
+	}
+	if file.JWT != nil {
+		var err error
+		if a.jwt, err = newJWTVerifier(*file.JWT); err != nil {
+			return nil, fmt.Errorf("jwt: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// Authenticate checks the API key or the bearer token when set, and the client certificate otherwise
+func (a *FileAuthenticator) Authenticate(creds Credentials) (*Principal, error) {
+	switch {
+	case creds.APIKey != "" && creds.BearerToken != "":
+		return nil, errors.New("only one of an API key and a bearer token can be set")
+	case creds.APIKey != "":
+		hash := sha256.Sum256([]byte(creds.APIKey))
+		var principal *Principal
+		// compare with every key, in constant time, to not leak which hash prefix matched
+		for _, key := range a.apiKeys {
+			if subtle.ConstantTimeCompare(hash[:], key.hash) == 1 {
+				principal = key.principal
+			}
+		}
+		if principal == nil {
+			return nil, errors.New("invalid API key")
+		}
+		return principal, nil
+	case creds.BearerToken != "":
+		if a.jwt == nil {
+			return nil, errors.New("bearer tokens are not accepted")
+		}
+		return a.jwt.verify(creds.BearerToken)
+	case len(creds.Certificates) > 0 && a.clientCertificates:
+		leaf := creds.Certificates[0]
+		name := leaf.Subject.CommonName
+		if name == "" && len(leaf.DNSNames) > 0 {
+			name = leaf.DNSNames[0]
+		}
+		if name == "" {
+			return nil, errors.New("client certificate has no common name")
+		}
+		principal := &Principal{Name: name, Method: AuthMethodMTLS, Groups: leaf.Subject.OrganizationalUnit}
+		if len(leaf.EmailAddresses) > 0 {
+			principal.Email = leaf.EmailAddresses[0]
+		}
+		return principal, nil
+	}
+	return nil, nil
+}
+
+// isWriteRequest tells if a request modifies the Apps, by its route: the legacy /put endpoints which accept any
+// method, and the other routes but GET, HEAD and OPTIONS and the reads taking a body, the legacy /get and /query
+// endpoints, /validate and /graphql. GraphQL mutations are writes too, GraphQLHandler tells them apart.
+func isWriteRequest(method, path string) bool {
+	_, path = splitVersionPrefix(path)
+	switch strings.Trim(path, "/") {
+	case "put":
+		return true
+	case "get", "query", "validate", "graphql":
+		return false
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// mayWrite tells if a request may modify the Apps: the write requests, and the GraphQL requests on POST,
+// the only method accepting mutations
+func mayWrite(method, path string) bool {
+	return isWriteRequest(method, path) || (method == http.MethodPost && strings.Trim(path, "/") == "graphql")
+}
+
+// authenticate authenticates the requests with credentials, with their principal in the request context.
+// Write requests must be authenticated, others may be anonymous. With a RateLimiter, the failed authentications are
+// charged to the writes of the address of the client, which is answered 429 over its limits before its credentials
+// are checked.
+func authenticate(authn Authenticator, limiter *RateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		address := ""
+		if limiter != nil && hasCredentials(req) {
+			address = requestClient(req, limiter.forwardedFor())
+			if err := limiter.checkAuthentication(address); err != nil {
+				log.Warnf("rate limited the authentication of %s %s of %s: %+v", req.Method, req.URL.Path, address, err)
+				handleTooManyRequestsError(w, err)
+				return
+			}
+		}
+		creds, err := credentialsFromRequest(req)
+		if err != nil {
+			limiter.failedAuthentication(address)
+			handleUnauthorizedError(w, err)
+			return
+		}
+		principal, err := authn.Authenticate(creds)
+		if err != nil {
+			log.Warnf("authentication failed for %s %s: %+v", req.Method, req.URL.Path, err)
+			limiter.failedAuthentication(address)
+			handleUnauthorizedError(w, err)
+			return
+		}
+		if principal == nil {
+			if isWriteRequest(req.Method, req.URL.Path) {
+				handleUnauthorizedError(w, errAuthenticationRequired)
+				return
+			}
+			next.ServeHTTP(w, req)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(withPrincipal(req.Context(), principal)))
+	})
+}
+
+// hasCredentials tells if a request carries an API key, an Authorization header or a verified client certificate
+func hasCredentials(req *http.Request) bool {
+	return req.Header.Get(apiKeyHeader) != "" || req.Header.Get("Authorization") != "" ||
+		(req.TLS != nil && len(req.TLS.VerifiedChains) > 0)
+}
+
+func credentialsFromRequest(req *http.Request) (Credentials, error) {
+	creds := Credentials{APIKey: req.Header.Get(apiKeyHeader)}
+	if authorization := req.Header.Get("Authorization"); authorization != "" {
+		scheme, token, _ := strings.Cut(authorization, " ")
+		if !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return creds, fmt.Errorf("unsupported Authorization scheme %q, expecting Bearer", scheme)
+		}
+		creds.BearerToken = strings.TrimSpace(token)
+	}
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		creds.Certificates = req.TLS.VerifiedChains[0]
+	}
+	return creds, nil
+}
+
+// grpcWriteMethods are the AppService methods which modify the Apps
+var grpcWriteMethods = map[string]bool{
+	pb.AppService_Put_FullMethodName:    true,
+	pb.AppService_Update_FullMethodName: true,
+	pb.AppService_Delete_FullMethodName: true,
+}
+
+// GrpcAuthInterceptors return the interceptors authenticating gRPC calls like the http requests,
+// with the x-api-key or authorization metadata and the client certificate. The failed authentications are charged
+// to limiter when not nil.
+func GrpcAuthInterceptors(authn Authenticator, limiter *RateLimiter) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticateGrpc(ctx, authn, limiter, info.FullMethod, grpc.SetHeader)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		setHeader := func(_ context.Context, md metadata.MD) error {
+			return ss.SetHeader(md)
+		}
+		ctx, err := authenticateGrpc(ss.Context(), authn, limiter, info.FullMethod, setHeader)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+	return unary, stream
+}
+
+func authenticateGrpc(ctx context.Context, authn Authenticator, limiter *RateLimiter, method string, setHeader func(context.Context, metadata.MD) error) (context.Context, error) {
+	creds := Credentials{}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys, authorization := md.Get(strings.ToLower(apiKeyHeader)), md.Get("authorization")
+	address := ""
+	if limiter != nil && (len(keys) > 0 || len(authorization) > 0) {
+		address = peerAddress(ctx)
+		if err := limiter.checkAuthentication(address); err != nil {
+			log.Warnf("rate limited the authentication of %s of %s: %+v", method, address, err)
+			setHeader(ctx, metadata.Pairs("retry-after", retryAfterSeconds(err)))
+			return ctx, status.Error(codes.ResourceExhausted, err.Error())
+		}
+	}
+	if len(keys) > 0 {
+		creds.APIKey = keys[0]
+	}
+	if len(authorization) > 0 {
+		scheme, token, _ := strings.Cut(authorization[0], " ")
+		if !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			limiter.failedAuthentication(address)
+			return ctx, status.Errorf(codes.Unauthenticated, "unsupported authorization scheme %q, expecting Bearer", scheme)
+		}
+		creds.BearerToken = strings.TrimSpace(token)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			creds.Certificates = tlsInfo.State.VerifiedChains[0]
+		}
+	}
+	principal, err := authn.Authenticate(creds)
+	if err != nil {
+		log.Warnf("authentication failed for %s: %+v", method, err)
+		limiter.failedAuthentication(address)
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if principal == nil {
+		if grpcWriteMethods[method] {
+			return ctx, status.Error(codes.Unauthenticated, errAuthenticationRequired.Error())
+		}
+		return ctx, nil
+	}
+	return withPrincipal(ctx, principal), nil
+}
+
+// authenticatedStream is a ServerStream with the principal in its context
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
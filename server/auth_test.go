@@ -0,0 +1,220 @@
+package server
+
+import (
+	"application_metadata_api_server/server/pb"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func apiKeySHA256(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestLoadAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	jwksFile := writeJWKS(t, map[string]crypto.Signer{"k1": rsaKey})
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`
+apiKeys:
+  - name: ci
+    sha256: `+apiKeySHA256("secret")+`
+    groups: [writers]
+jwt:
+  jwksFile: `+jwksFile+`
+clientCertificates: true
+`), 0600))
+	a, err := LoadAuthenticator(path)
+	assert.Nil(t, err)
+
+	p, err := a.Authenticate(Credentials{APIKey: "secret"})
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{Name: "ci", Method: AuthMethodAPIKey, Groups: []string{"writers"}}, p)
+	_, err = a.Authenticate(Credentials{APIKey: "wrong"})
+	assert.EqualError(t, err, "invalid API key")
+
+	token := signJWT(t, "RS256", rsaKey, "k1", map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	p, err = a.Authenticate(Credentials{BearerToken: token})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", p.Name)
+	_, err = a.Authenticate(Credentials{BearerToken: token, APIKey: "secret"})
+	assert.EqualError(t, err, "only one of an API key and a bearer token can be set")
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "deployer", OrganizationalUnit: []string{"platform"}}}
+	p, err = a.Authenticate(Credentials{Certificates: []*x509.Certificate{cert}})
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{Name: "deployer", Method: AuthMethodMTLS, Groups: []string{"platform"}}, p)
+
+	p, err = a.Authenticate(Credentials{})
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+
+	for content, expected := range map[string]string{
+		"apiKeys: [{name: ci, sha256: abc}]": "apiKeys[0]: sha256 must be a hex encoded sha256",
+		"apiKeys: [{name: ci, sha256: " + apiKeySHA256("a") + "}, {name: ci, sha256: " + apiKeySHA256("b") + "}]": "apiKeys[1]: name must be set and unique",
+		"jwt: {issuer: x}": "jwt: jwksFile is required",
+		"unknown: true":    `error unmarshaling JSON: while decoding JSON: json: unknown field "unknown"`,
+	} {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+		_, err := LoadAuthenticator(path)
+		assert.EqualError(t, err, expected, content)
+	}
+}
+
+func TestHttpServerImpl_Handler_Authentication(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{{Name: "ci", SHA256: apiKeySHA256("secret")}}})
+	assert.Nil(t, err)
+	handler := NewHttpServer(WithAuthenticator(authn)).Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+
+	for _, tc := range []struct {
+		method, path, apiKey, authorization string
+		expected                            int
+	}{
+		{http.MethodPost, "/apps", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/apps", "wrong", "", http.StatusUnauthorized},
+		{http.MethodPost, "/apps", "", "Basic Y2k6c2VjcmV0", http.StatusUnauthorized},
+		{http.MethodPost, "/apps", "", "Bearer token", http.StatusUnauthorized},
+		// the legacy put endpoint is a write with any method
+		{http.MethodGet, "/v1/put", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/apps", "secret", "", http.StatusCreated},
+		{http.MethodGet, "/v1/put", "secret", "", http.StatusOK},
+		// reads may be anonymous
+		{http.MethodGet, "/apps/1", "", "", http.StatusOK},
+		{http.MethodGet, "/apps/1", "wrong", "", http.StatusUnauthorized},
+		{http.MethodDelete, "/apps/1", "", "", http.StatusUnauthorized},
+		{http.MethodDelete, "/apps/1", "secret", "", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(string(data)))
+		if tc.apiKey != "" {
+			req.Header.Set(apiKeyHeader, tc.apiKey)
+		}
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, tc.expected, rr.Code, "%s %s %s", tc.method, tc.path, rr.Body.String())
+		if rr.Code == http.StatusUnauthorized {
+			assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Bearer")
+			assert.Contains(t, rr.Body.String(), `"error_reason":"unauthorized"`)
+		}
+	}
+}
+
+func TestHttpServerImpl_Handler_AnonymousReads(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{{Name: "ci", SHA256: apiKeySHA256("secret")}}})
+	assert.Nil(t, err)
+	handler := NewHttpServer(WithAuthenticator(authn)).Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	do := func(method, path, apiKey, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/apps", "secret", "", string(data)).Code)
+
+	mutation := `{"query": "mutation { deleteApp(id: \"1\") }"}`
+	for _, tc := range []struct {
+		method, path, contentType, body string
+		expected                        int
+		expectedBody                    string
+	}{
+		{http.MethodGet, "/apps?title=valid", "", "", http.StatusOK, `"1"`},
+		{http.MethodPost, "/query", "", "title: Valid App 1", http.StatusOK, `"1"`},
+		{http.MethodPost, "/v1/get", "", "1", http.StatusOK, "Valid App 1"},
+		{http.MethodPost, "/validate", "", string(data), http.StatusOK, ""},
+		{http.MethodPost, "/graphql", mediaTypeJSON, `{"query": "{ apps(filter: {title: \"valid\"}) { id } }"}`, http.StatusOK, `"1"`},
+		// the writes are not
+		{http.MethodPost, "/graphql", mediaTypeJSON, mutation, http.StatusUnauthorized, ""},
+		{http.MethodPost, "/import", mediaTypeYAML, string(data), http.StatusUnauthorized, ""},
+		{http.MethodPost, "/batch", mediaTypeJSON, `{"operations": [{"op": "delete", "id": "1"}]}`, http.StatusUnauthorized, ""},
+		{http.MethodPost, "/v1/put", "", string(data), http.StatusUnauthorized, ""},
+	} {
+		rr := do(tc.method, tc.path, "", tc.contentType, tc.body)
+		assert.Equal(t, tc.expected, rr.Code, "%s %s: %s", tc.method, tc.path, rr.Body.String())
+		assert.Contains(t, rr.Body.String(), tc.expectedBody, "%s %s", tc.method, tc.path)
+	}
+	rr := do(http.MethodPost, "/graphql", "secret", mediaTypeJSON, mutation)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"data": {"deleteApp": "1"}}`, rr.Body.String())
+}
+
+func TestAuthenticate_Principal(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{
+		APIKeys:            []APIKey{{Name: "ci", SHA256: apiKeySHA256("secret"), Groups: []string{"writers"}}},
+		ClientCertificates: true,
+	})
+	assert.Nil(t, err)
+	var principal *Principal
+	handler := authenticate(authn, nil, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		principal = PrincipalFromContext(req.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/apps", nil)
+	req.Header.Set(apiKeyHeader, "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, &Principal{Name: "ci", Method: AuthMethodAPIKey, Groups: []string{"writers"}}, principal)
+
+	req = httptest.NewRequest(http.MethodPost, "/apps", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "deployer"}},
+	}}}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, &Principal{Name: "deployer", Method: AuthMethodMTLS}, principal)
+
+	// an unverified certificate is no credential
+	req = httptest.NewRequest(http.MethodGet, "/apps", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "deployer"}}}}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Nil(t, principal)
+}
+
+func TestGrpcAuthInterceptors(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{{Name: "ci", SHA256: apiKeySHA256("secret")}}})
+	assert.Nil(t, err)
+	unary, stream := GrpcAuthInterceptors(authn, nil)
+	client := newGrpcTestClientWithServerOptions(t, []grpc.ServerOption{grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream)})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = client.Put(ctx, &pb.PutRequest{App: newTestProtoApp("grpc app")})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Put(metadata.AppendToOutgoingContext(ctx, "x-api-key", "wrong"), &pb.PutRequest{App: newTestProtoApp("grpc app")})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	resp, err := client.Put(metadata.AppendToOutgoingContext(ctx, "x-api-key", "secret"), &pb.PutRequest{App: newTestProtoApp("grpc app")})
+	assert.Nil(t, err)
+	_, err = client.Get(ctx, &pb.GetRequest{Id: resp.GetId()})
+	assert.Nil(t, err)
+	_, err = client.Delete(metadata.AppendToOutgoingContext(ctx, "authorization", "Basic abc"), &pb.DeleteRequest{Id: resp.GetId()})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	batchOpCreate = "create"
+	batchOpUpdate = "update"
+	batchOpDelete = "delete"
+)
+
+// BatchOperation is one write of a batch request, Document is the App of create and update operations
+type BatchOperation struct {
+	Op       string          `json:"op"`
+	Id       api.Id          `json:"id,omitempty"`
+	Document json.RawMessage `json:"document,omitempty"`
+}
+
+// BatchRequest is the body of a batch request, its operations are applied in order, all or none
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchResult is the outcome of one operation of a batch, Index is its position in the request
+type BatchResult struct {
+	Index            int               `json:"index"`
+	Op               string            `json:"op"`
+	Id               api.Id            `json:"id,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	PolicyViolations []PolicyViolation `json:"policy_violations,omitempty"`
+	Warnings         []Warning         `json:"warnings,omitempty"`
+}
+
+// BatchResponse is the body of a batch response, Committed tells if the operations were applied
+type BatchResponse struct {
+	Committed bool          `json:"committed"`
+	Results   []BatchResult `json:"results"`
+}
+
+func (h *httpServerImpl) BatchHandler(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		handleReadBodyError(w, err)
+		return
+	}
+	if err := checkContentType(req, body, mediaTypeJSON, mediaTypeYAML); err != nil {
+		handleContentTypeError(w, err)
+		return
+	}
+	batch, err := decodeBatchRequest(body)
+	if err != nil {
+		handleValidationError(w, err)
+		return
+	}
+
+	// a namespace is only created by a batch of authorized creates, its Apps cannot be updated nor deleted
+	store, exists := h.lookupStore(req.Context())
+	if !exists {
+		for _, op := range batch.Operations {
+			if op.Op == batchOpUpdate || op.Op == batchOpDelete {
+				handleNotFoundError(w, errNamespaceNotFound(NamespaceFromContext(req.Context())))
+				return
+			}
+		}
+	}
+	resp, ops, valid := h.prepareBatch(req, store, body, batch)
+	if !valid {
+		writeNegotiated(w, req, http.StatusBadRequest, resp)
+		return
+	}
+	if !exists {
+		store = h.createStoreOf(req.Context())
+	}
+	txn, writes, ok := stageBatch(store, ops, &resp)
+	if !ok {
+		txn.Rollback()
+		writeNegotiated(w, req, http.StatusBadRequest, resp)
+		return
+	}
+	ids, err := h.audit.audit(req.Context(), store, writes, txn.Commit)
+	if err != nil {
+		if errors.Is(err, cache.ErrNotFound) {
+			log.Warnf("batch not committed: %+v", err)
+			handleNotFoundError(w, err)
+			return
+		}
+		handleInternalError(w, err, "failed to commit batch")
+		return
+	}
+	// the created Ids are returned in the order of the create operations
+	for i := range resp.Results {
+		if resp.Results[i].Op == batchOpCreate {
+			resp.Results[i].Id, ids = ids[0], ids[1:]
+		}
+	}
+	resp.Committed = true
+	log.Infof("Successfully committed a batch of %d operations", len(resp.Results))
+	writeNegotiated(w, req, http.StatusOK, resp)
+}
+
+// preparedOperation is a batch operation validated and authorized, with the App and document to stage
+type preparedOperation struct {
+	BatchOperation
+	app api.App
+	doc []byte
+}
+
+// prepareBatch validates and authorizes every operation of a batch, false is returned when an operation is invalid.
+// store is only read by the updates and deletes.
+func (h *httpServerImpl) prepareBatch(req *http.Request, store cache.Store, body []byte, batch *BatchRequest) (BatchResponse, []preparedOperation, bool) {
+	resp := BatchResponse{Results: make([]BatchResult, len(batch.Operations))}
+	ops := make([]preparedOperation, len(batch.Operations))
+	valid := true
+	for i, op := range batch.Operations {
+		result := &resp.Results[i]
+		result.Index, result.Op, result.Id = i, op.Op, op.Id
+		ops[i].BatchOperation = op
+		if err := h.prepareBatchOperation(req, store, body, &ops[i], result); err != nil {
+			result.Error = err.Error()
+			valid = false
+		}
+	}
+	return resp, ops, valid
+}
+
+// stageBatch adds the prepared operations to a transaction of store, and returns the writes to audit.
+// false is returned when an operation fails to stage, its error is reported in resp.
+func stageBatch(store cache.Store, ops []preparedOperation, resp *BatchResponse) (cache.Txn, []auditedWrite, bool) {
+	txn := store.Begin()
+	writes := make([]auditedWrite, 0, len(ops))
+	for i := range ops {
+		op := &ops[i]
+		var err error
+		switch op.Op {
+		case batchOpCreate:
+			err = txn.Add(&op.app, op.doc)
+		case batchOpUpdate:
+			err = txn.Update(op.Id, &op.app, op.doc)
+		case batchOpDelete:
+			err = txn.Delete(op.Id)
+		}
+		if err != nil {
+			resp.Results[i].Error = err.Error()
+			return txn, nil, false
+		}
+		// the batch ops are named after the verbs
+		writes = append(writes, auditedWrite{verb: Verb(op.Op), id: op.Id, raw: op.doc})
+	}
+	return txn, writes, true
+}
+
+// prepareBatchOperation validates and authorizes an operation, and sets the App and the document staged by creates
+// and updates. The warnings and policy violations are reported in result.
+func (h *httpServerImpl) prepareBatchOperation(req *http.Request, store cache.Store, body []byte, op *preparedOperation, result *BatchResult) error {
+	switch op.Op {
+	case batchOpCreate, batchOpUpdate:
+		if op.Op == batchOpUpdate && op.Id == "" {
+			return fmt.Errorf("id is required")
+		}
+		if op.Op == batchOpCreate && op.Id != "" {
+			return fmt.Errorf("id is assigned by the server")
+		}
+		if len(op.Document) == 0 {
+			return fmt.Errorf("document is required")
+		}
+		// store the document in the format of the request
+		doc := []byte(op.Document)
+		if !isJSON(body) {
+			var err error
+			if doc, err = yaml.JSONToYAML(doc); err != nil {
+				return err
+			}
+		}
+		app, warnings, validationErr, violations := h.evaluate(req, doc)
+		if validationErr != nil {
+			return validationErr
+		}
+		if len(violations) > 0 {
+			result.PolicyViolations = violations
+			return errors.New(policyViolationMsg)
+		}
+		result.Warnings = warnings
+		if op.Op == batchOpCreate {
+			if err := authorize(h.authz, req.Context(), VerbCreate, &app); err != nil {
+				return fmt.Errorf("%s: %w", forbiddenMsg, err)
+			}
+		} else if err := authorizeUpdate(h.authz, store, req.Context(), op.Id, &app); err != nil {
+			return fmt.Errorf("%s: %w", forbiddenMsg, err)
+		}
+		op.app, op.doc = app, doc
+		return nil
+	case batchOpDelete:
+		if op.Id == "" {
+			return fmt.Errorf("id is required")
+		}
+		if len(op.Document) > 0 {
+			return fmt.Errorf("document is not expected")
+		}
+		if err := authorizeStored(h.authz, store, req.Context(), VerbDelete, op.Id); err != nil {
+			return fmt.Errorf("%s: %w", forbiddenMsg, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown op %q, expecting %s, %s or %s", op.Op, batchOpCreate, batchOpUpdate, batchOpDelete)
+}
+
+func decodeBatchRequest(body []byte) (*BatchRequest, error) {
+	if err := checkDocument(body); err != nil {
+		return nil, fmt.Errorf("invalid batch request: %w", err)
+	}
+	jsonBody, err := yaml.YAMLToJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBody))
+	decoder.DisallowUnknownFields()
+	batch := &BatchRequest{}
+	if err := decoder.Decode(batch); err != nil {
+		return nil, fmt.Errorf("invalid batch request: %w", err)
+	}
+	if len(batch.Operations) == 0 {
+		return nil, fmt.Errorf("invalid batch request: operations is required")
+	}
+	return batch, nil
+}
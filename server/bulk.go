@@ -0,0 +1,197 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// ImportResult is the outcome of one document of an import, Index is its position in the stream
+type ImportResult struct {
+	Index            int               `json:"index"`
+	Id               api.Id            `json:"id,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	PolicyViolations []PolicyViolation `json:"policy_violations,omitempty"`
+	Warnings         []Warning         `json:"warnings,omitempty"`
+}
+
+// ImportResponse is the body of an import response
+type ImportResponse struct {
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+func (h *httpServerImpl) ImportHandler(w http.ResponseWriter, req *http.Request) {
+	docs, err := readDocuments(req)
+	if isRequestTooLarge(err) {
+		handleRequestTooLargeError(w, err)
+		return
+	}
+	if err != nil {
+		handleContentTypeError(w, err)
+		return
+	}
+	resp := ImportResponse{Results: make([]ImportResult, len(docs))}
+	apps := make([]*api.App, 0, len(docs))
+	raws := make([][]byte, 0, len(docs))
+	// positions of the valid documents in the results
+	positions := make([]int, 0, len(docs))
+	for i, doc := range docs {
+		resp.Results[i].Index = i
+		app, warnings, validationErr, violations := h.evaluate(req, doc)
+		switch {
+		case validationErr != nil:
+			resp.Results[i].Error = validationErr.Error()
+		case len(violations) > 0:
+			resp.Results[i].Error = policyViolationMsg
+			resp.Results[i].PolicyViolations = violations
+		default:
+			if err := authorize(h.authz, req.Context(), VerbCreate, &app); err != nil {
+				resp.Results[i].Error = fmt.Sprintf("%s: %v", forbiddenMsg, err)
+				continue
+			}
+			resp.Results[i].Warnings = warnings
+			apps = append(apps, &app)
+			raws = append(raws, doc)
+			positions = append(positions, i)
+		}
+	}
+	store := h.storeOf(req.Context())
+	if len(raws) > 0 {
+		store = h.createStoreOf(req.Context())
+	}
+	writes := make([]auditedWrite, len(raws))
+	for i, raw := range raws {
+		writes[i] = auditedWrite{verb: VerbCreate, raw: raw}
+	}
+	ids, err := h.audit.audit(req.Context(), store, writes, func() ([]api.Id, error) {
+		return store.AddBatch(apps, raws)
+	})
+	if err != nil {
+		handleInternalError(w, err, "failed to import apps")
+		return
+	}
+	for i, id := range ids {
+		resp.Results[positions[i]].Id = id
+	}
+	resp.Imported = len(ids)
+	resp.Failed = len(docs) - len(ids)
+	log.Infof("Imported %d apps, %d failed", resp.Imported, resp.Failed)
+	writeNegotiated(w, req, http.StatusOK, resp)
+}
+
+// readDocuments splits a request body into documents, ndjson when declared so and multi-document yaml otherwise.
+// Empty documents and blank lines are skipped.
+func readDocuments(req *http.Request) ([][]byte, error) {
+	mediaType := ""
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, fmt.Errorf("invalid Content-Type %q: %w", contentType, err)
+		}
+	}
+	mediaType = normalizeMediaType(mediaType)
+	docs := make([][]byte, 0)
+	switch {
+	case mediaType == mediaTypeNDJSON:
+		reader := bufio.NewReader(req.Body)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				docs = append(docs, line)
+			}
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	case mediaType == mediaTypeYAML || sniffedContentTypes[mediaType]:
+		reader := utilyaml.NewYAMLReader(bufio.NewReader(req.Body))
+		for {
+			doc, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			// skip documents holding only blank lines or comments
+			if jsonDoc, err := yaml.YAMLToJSON(doc); err == nil && string(jsonDoc) == "null" {
+				continue
+			}
+			docs = append(docs, doc)
+		}
+	}
+	return nil, &unsupportedMediaTypeError{mediaType: mediaType, accepted: []string{mediaTypeYAML, mediaTypeNDJSON}}
+}
+
+func (h *httpServerImpl) ExportHandler(w http.ResponseWriter, req *http.Request) {
+	mediaType, ok := negotiate(req, mediaTypeYAML, mediaTypeNDJSON)
+	if !ok {
+		handleNotAcceptableError(w, fmt.Errorf("no acceptable media type in %q, expecting %s or %s", req.Header.Get("Accept"), mediaTypeYAML, mediaTypeNDJSON))
+		return
+	}
+	version := apiVersionFromRequest(req)
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	// the status is sent, from here errors can only be logged and the app skipped
+	cnt := 0
+	// a snapshot, the writes during the export are not in it
+	for _, doc := range h.storeOf(req.Context()).Snapshot() {
+		id, rawApp := doc.Id, doc.Raw
+		if !readable(h.authz, req.Context(), id, rawApp) {
+			continue
+		}
+		var err error
+		if version != "" {
+			if rawApp, err = convertDocument(rawApp, version); err != nil {
+				log.Errorf("failed to convert app %s to %s: %+v", id, version, err)
+				continue
+			}
+		}
+		data, err := exportDocument(rawApp, mediaType)
+		if err != nil {
+			log.Errorf("failed to export app %s: %+v", id, err)
+			continue
+		}
+		if mediaType == mediaTypeYAML && cnt > 0 {
+			w.Write([]byte("---\n"))
+		}
+		if _, err := w.Write(data); err != nil {
+			log.Warnf("export interrupted: %+v", err)
+			return
+		}
+		cnt++
+	}
+	log.Infof("Exported %d apps", cnt)
+}
+
+// exportDocument converts a stored document to a yaml document or a single json line
+func exportDocument(raw []byte, mediaType string) ([]byte, error) {
+	if mediaType == mediaTypeNDJSON {
+		data, err := yaml.YAMLToJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	if isJSON(raw) {
+		return yaml.JSONToYAML(raw)
+	}
+	if !bytes.HasSuffix(raw, []byte("\n")) {
+		raw = append(append([]byte{}, raw...), '\n')
+	}
+	return raw, nil
+}
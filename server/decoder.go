@@ -0,0 +1,245 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// DecodeMode controls how unknown and duplicate keys of a put request are handled
+type DecodeMode int
+
+const (
+	// DecodeStrict rejects documents with unknown or duplicate keys
+	DecodeStrict DecodeMode = iota
+	// DecodeLenient accepts such documents and reports the keys as warnings
+	DecodeLenient
+)
+
+// Warning is a non-fatal finding about an input document
+type Warning struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (w Warning) String() string {
+	if w.Line > 0 {
+		return fmt.Sprintf("line %d, column %d: %s", w.Line, w.Column, w.Message)
+	}
+	return w.Message
+}
+
+const (
+	// maxDocumentDepth is the nesting depth of mappings and sequences above which a document is rejected
+	maxDocumentDepth = 64
+	// maxDocumentNodes is the number of nodes above which a document is rejected, counting each alias as
+	// the nodes it expands into, so a "billion laughs" document is rejected before anything expands it
+	maxDocumentNodes = 100000
+)
+
+// checkDocument rejects a yaml (or json) document exceeding the decode limits, before it is decoded
+func checkDocument(doc []byte) error {
+	_, err := parseDocument(doc)
+	return err
+}
+
+// parseDocument parses a yaml (or json) document without expanding its aliases,
+// and rejects it when it is nested deeper than maxDocumentDepth or expands into more than maxDocumentNodes nodes
+func parseDocument(doc []byte) (*yamlv3.Node, error) {
+	root := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(doc, root); err != nil {
+		return nil, err
+	}
+	sizes := make(map[*yamlv3.Node]documentSize)
+	size := measureNode(root, sizes)
+	if size.depth > maxDocumentDepth {
+		return nil, fmt.Errorf("document is nested deeper than %d levels", maxDocumentDepth)
+	}
+	if size.nodes > maxDocumentNodes {
+		return nil, fmt.Errorf("document expands into more than %d nodes", maxDocumentNodes)
+	}
+	return root, nil
+}
+
+// documentSize is the expanded size of a node
+type documentSize struct {
+	depth int
+	nodes int
+}
+
+// measureNode returns the depth and the node count of a node once its aliases are expanded.
+// The sizes of the nodes are memoized, so an anchor is measured once however many times it is aliased,
+// and the counts stop growing past the limits.
+func measureNode(node *yamlv3.Node, sizes map[*yamlv3.Node]documentSize) documentSize {
+	if node == nil {
+		return documentSize{}
+	}
+	if size, ok := sizes[node]; ok {
+		return size
+	}
+	if node.Kind == yamlv3.AliasNode {
+		size := measureNode(node.Alias, sizes)
+		sizes[node] = size
+		return size
+	}
+	size := documentSize{nodes: 1}
+	for _, c := range node.Content {
+		child := measureNode(c, sizes)
+		if child.depth > size.depth {
+			size.depth = child.depth
+		}
+		size.nodes += child.nodes
+		if size.nodes > maxDocumentNodes {
+			size.nodes = maxDocumentNodes + 1
+			break
+		}
+	}
+	if node.Kind == yamlv3.MappingNode || node.Kind == yamlv3.SequenceNode {
+		size.depth++
+	}
+	sizes[node] = size
+	return size
+}
+
+// checkKeys walks a yaml (or json) document along the Go type it is decoded into,
+// and returns a finding for every key that is unknown to the type or defined twice in a mapping
+func checkKeys(root *yamlv3.Node, t reflect.Type) []Warning {
+	rs := make([]Warning, 0)
+	walkKeys(root, t, "", &rs)
+	return rs
+}
+
+func walkKeys(node *yamlv3.Node, t reflect.Type, path string, rs *[]Warning) {
+	if node == nil {
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range node.Content {
+			walkKeys(c, t, path, rs)
+		}
+	case yamlv3.AliasNode:
+		walkKeys(node.Alias, t, path, rs)
+	case yamlv3.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return
+		}
+		for i, c := range node.Content {
+			walkKeys(c, t.Elem(), fmt.Sprintf("%s[%d]", path, i), rs)
+		}
+	case yamlv3.MappingNode:
+		if t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+			return
+		}
+		seen := make(map[string]*yamlv3.Node)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			key := keyNode.Value
+			fieldPath := joinPath(path, key)
+			if first, ok := seen[key]; ok {
+				*rs = append(*rs, Warning{
+					Line:    keyNode.Line,
+					Column:  keyNode.Column,
+					Field:   fieldPath,
+					Message: fmt.Sprintf("duplicate key %q (first defined at line %d)", fieldPath, first.Line),
+				})
+				continue
+			}
+			seen[key] = keyNode
+			if t.Kind() == reflect.Map {
+				walkKeys(valueNode, t.Elem(), fieldPath, rs)
+				continue
+			}
+			field, ok := lookupJSONField(t, key)
+			if !ok {
+				*rs = append(*rs, Warning{
+					Line:    keyNode.Line,
+					Column:  keyNode.Column,
+					Field:   fieldPath,
+					Message: fmt.Sprintf("unknown field %q", fieldPath),
+				})
+				continue
+			}
+			walkKeys(valueNode, field.Type, fieldPath, rs)
+		}
+	}
+}
+
+// dropUnknownKeys removes from a parsed document the keys that are unknown to the Go type it is decoded into,
+// and every definition of a duplicate key but the last one, which is the one decoded.
+// What remains is the document as it is indexed, with its values as written.
+func dropUnknownKeys(node *yamlv3.Node, t reflect.Type) {
+	if node == nil {
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range node.Content {
+			dropUnknownKeys(c, t)
+		}
+	case yamlv3.AliasNode:
+		dropUnknownKeys(node.Alias, t)
+	case yamlv3.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return
+		}
+		for _, c := range node.Content {
+			dropUnknownKeys(c, t.Elem())
+		}
+	case yamlv3.MappingNode:
+		if t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+			return
+		}
+		last := make(map[string]int)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			last[node.Content[i].Value] = i
+		}
+		content := make([]*yamlv3.Node, 0, len(node.Content))
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			if last[keyNode.Value] != i {
+				continue
+			}
+			if t.Kind() == reflect.Map {
+				dropUnknownKeys(valueNode, t.Elem())
+			} else if field, ok := lookupJSONField(t, keyNode.Value); ok {
+				dropUnknownKeys(valueNode, field.Type)
+			} else {
+				continue
+			}
+			content = append(content, keyNode, valueNode)
+		}
+		node.Content = content
+	}
+}
+
+// lookupJSONField finds the struct field a json key decodes into, matching case-insensitively like encoding/json
+func lookupJSONField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := getJSONName(field)
+		if name != "" && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// joinWarnings renders findings as a single error message
+func joinWarnings(ws []Warning) string {
+	msgs := make([]string, 0, len(ws))
+	for _, w := range ws {
+		msgs = append(msgs, w.String())
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -2,22 +2,41 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"strings"
 )
 
 const (
 	httpErrReasonKey  = "error_reason"
 	httpErrMessageKey = "error_message"
+	httpErrPolicyKey  = "policy_violations"
 
+	unauthorizedMsg        = "unauthorized"
+	forbiddenMsg           = "forbidden"
 	notFoundMsg            = "not found"
+	methodNotAllowedMsg    = "method not allowed"
+	unsupportedMediaMsg    = "unsupported media type"
+	notAcceptableMsg       = "not acceptable"
+	requestTooLargeMsg     = "request entity too large"
+	tooManyRequestsMsg     = "too many requests"
 	invalidInputMsg        = "invalid input yaml"
+	policyViolationMsg     = "policy violation"
 	internalServerErrorMsg = "internal server error"
+	shuttingDownMsg        = "server is shutting down"
 
 	errorInvalidSpec = "InvalidSpec"
 )
 
+// ErrorResponse is the body of every error response written by the handlers below
+type ErrorResponse struct {
+	Reason           string            `json:"error_reason"`
+	Message          string            `json:"error_message"`
+	PolicyViolations []PolicyViolation `json:"policy_violations,omitempty"`
+}
+
 // ValidationError is the interface for validation error
 type ValidationError interface {
 	Error() string
@@ -30,6 +49,8 @@ type errImpl struct {
 	err    error
 	code   int32
 	reason string
+	// rule is the validation rule the document breaks, empty when unknown
+	rule string
 }
 
 func (e *errImpl) Error() string {
@@ -49,16 +70,31 @@ func (e *errImpl) Raw() error {
 }
 
 func NewInvalidSpec(err error) ValidationError {
+	return newRuleViolation(validationRule(err), err)
+}
+
+// newRuleViolation is NewInvalidSpec for a document breaking a validation rule, such as ruleSchema or a validate tag
+func newRuleViolation(rule string, err error) ValidationError {
 	return &errImpl{
 		err:    err,
 		code:   http.StatusBadRequest,
 		reason: errorInvalidSpec,
+		rule:   rule,
+	}
+}
+
+// validationRule returns the rule broken by a validation error, empty when unknown
+func validationRule(err error) string {
+	var e *errImpl
+	if errors.As(err, &e) {
+		return e.rule
 	}
+	return ""
 }
 
 func handleValidationError(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusBadRequest)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
 	resp := make(map[string]string)
 	resp[httpErrReasonKey] = invalidInputMsg
 	resp[httpErrMessageKey] = fmt.Sprintf("%+v", err)
@@ -70,8 +106,8 @@ func handleValidationError(w http.ResponseWriter, err error) {
 }
 
 func handleNotFoundError(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusNotFound)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
 	resp := make(map[string]string)
 	resp[httpErrReasonKey] = notFoundMsg
 	resp[httpErrMessageKey] = fmt.Sprintf("%+v", err)
@@ -82,12 +118,85 @@ func handleNotFoundError(w http.ResponseWriter, err error) {
 	w.Write(jsonResp)
 }
 
+// handleUnauthorizedError writes a 401, asking for a bearer token
+func handleUnauthorizedError(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="application_metadata_api_server"`)
+	writeError(w, http.StatusUnauthorized, unauthorizedMsg, err)
+}
+
+// handleForbiddenError writes a 403, for the authenticated requests the Authorizer denies
+func handleForbiddenError(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusForbidden, forbiddenMsg, err)
+}
+
+// handleRequestTooLargeError writes a 413, for the request bodies longer than the max body size.
+// The connection is closed, the rest of the body is not read.
+func handleRequestTooLargeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Connection", "close")
+	writeError(w, http.StatusRequestEntityTooLarge, requestTooLargeMsg, err)
+}
+
+// handleTooManyRequestsError writes a 429, with the Retry-After seconds of a rate limited request
+func handleTooManyRequestsError(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", retryAfterSeconds(err))
+	writeError(w, http.StatusTooManyRequests, tooManyRequestsMsg, err)
+}
+
+func handleMethodNotAllowedError(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusMethodNotAllowed, methodNotAllowedMsg, err)
+}
+
+func handleUnsupportedMediaTypeError(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusUnsupportedMediaType, unsupportedMediaMsg, err)
+}
+
+func handleNotAcceptableError(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusNotAcceptable, notAcceptableMsg, err)
+}
+
+// writeError writes the json error response with its reason and the error message
+func writeError(w http.ResponseWriter, code int, reason string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	resp := make(map[string]string)
+	resp[httpErrReasonKey] = reason
+	resp[httpErrMessageKey] = fmt.Sprintf("%+v", err)
+	jsonResp, err := json.Marshal(resp)
+	if err != nil {
+		log.Errorf("failed to json marshal response")
+	}
+	w.Write(jsonResp)
+}
+
 func handleInternalError(w http.ResponseWriter, err error, errorMsg string) {
-	w.WriteHeader(http.StatusInternalServerError)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
 	resp := make(map[string]string)
 	resp[httpErrReasonKey] = internalServerErrorMsg
 	resp[httpErrMessageKey] = fmt.Sprintf("%s error: %+v", errorMsg, err)
 	jsonResp, _ := json.Marshal(resp)
 	w.Write(jsonResp)
 }
+
+func handlePolicyViolationError(w http.ResponseWriter, violations []PolicyViolation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	resp := make(map[string]interface{})
+	resp[httpErrReasonKey] = policyViolationMsg
+	resp[httpErrMessageKey] = joinViolations(violations)
+	resp[httpErrPolicyKey] = violations
+	jsonResp, err := json.Marshal(resp)
+	if err != nil {
+		log.Errorf("failed to json marshal response")
+	}
+	w.Write(jsonResp)
+}
+
+// joinViolations formats policy violations as a single message
+func joinViolations(violations []PolicyViolation) string {
+	msgs := make([]string, 0, len(violations))
+	for _, v := range violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", v.Policy, v.Message))
+	}
+	return strings.Join(msgs, "; ")
+}
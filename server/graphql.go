@@ -0,0 +1,437 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+// graphQLRequest is the body of a GraphQL POST request
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// label is a labels entry, GraphQL has no map type
+type label struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// graphQLTypes builds the GraphQL output and input types of a Go type, honouring its "json" and "validate" tags
+type graphQLTypes struct {
+	outputs map[string]*graphql.Object
+	inputs  map[string]*graphql.InputObject
+}
+
+var labelsType = reflect.TypeOf(map[string]string{})
+
+func (g *graphQLTypes) output(t reflect.Type) graphql.Output {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.output(t.Elem())
+	case reflect.String:
+		if t == reflect.TypeOf(api.Id("")) {
+			return graphql.ID
+		}
+		return graphql.String
+	case reflect.Slice:
+		return graphql.NewList(graphql.NewNonNull(g.output(t.Elem())))
+	case reflect.Map:
+		return graphql.NewList(graphql.NewNonNull(g.output(reflect.TypeOf(label{}))))
+	case reflect.Struct:
+		if obj, ok := g.outputs[t.Name()]; ok {
+			return obj
+		}
+		fields := graphql.Fields{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := getJSONName(f)
+			if name == "" {
+				continue
+			}
+			field := &graphql.Field{Type: g.output(f.Type)}
+			if isRequiredField(f) {
+				field.Type = graphql.NewNonNull(field.Type)
+			}
+			if f.Type == labelsType {
+				index := i
+				field.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+					return labelList(reflect.Indirect(reflect.ValueOf(p.Source)).Field(index).Interface().(map[string]string)), nil
+				}
+			}
+			fields[name] = field
+		}
+		obj := graphql.NewObject(graphql.ObjectConfig{Name: t.Name(), Fields: fields})
+		g.outputs[t.Name()] = obj
+		return obj
+	}
+	panic(fmt.Sprintf("%s has no graphql type", t))
+}
+
+func (g *graphQLTypes) input(t reflect.Type) graphql.Input {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.input(t.Elem())
+	case reflect.String:
+		return graphql.String
+	case reflect.Slice:
+		return graphql.NewList(graphql.NewNonNull(g.input(t.Elem())))
+	case reflect.Map:
+		return graphql.NewList(graphql.NewNonNull(g.input(reflect.TypeOf(label{}))))
+	case reflect.Struct:
+		name := t.Name() + "Input"
+		if obj, ok := g.inputs[name]; ok {
+			return obj
+		}
+		fields := graphql.InputObjectConfigFieldMap{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			jsonName := getJSONName(f)
+			// ids are given as arguments, never in the App
+			if jsonName == "" || f.Type == reflect.TypeOf(api.Id("")) {
+				continue
+			}
+			fields[jsonName] = &graphql.InputObjectFieldConfig{Type: g.input(f.Type)}
+		}
+		obj := graphql.NewInputObject(graphql.InputObjectConfig{Name: name, Fields: fields})
+		g.inputs[name] = obj
+		return obj
+	}
+	panic(fmt.Sprintf("%s has no graphql input type", t))
+}
+
+// isRequiredField tells if a field is always set on a valid App
+func isRequiredField(f reflect.StructField) bool {
+	for _, vTag := range getValidateTags(f) {
+		if vTag == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+func labelList(labels map[string]string) []label {
+	rs := make([]label, 0, len(labels))
+	for k, v := range labels {
+		rs = append(rs, label{Key: k, Value: v})
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Key < rs[j].Key
+	})
+	return rs
+}
+
+// appFromInput converts an AppInput argument to an App, turning the labels list back into a map
+func appFromInput(input interface{}) (*api.App, error) {
+	app := &api.App{}
+	fields, ok := input.(map[string]interface{})
+	if !ok {
+		return app, nil
+	}
+	if labels, ok := fields["labels"].([]interface{}); ok {
+		m := make(map[string]interface{}, len(labels))
+		for _, l := range labels {
+			entry, _ := l.(map[string]interface{})
+			key, _ := entry["key"].(string)
+			m[key] = entry["value"]
+		}
+		fields["labels"] = m
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, app); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// newGraphQLSchema builds the GraphQL schema of the server, the App types are derived from api.App
+func (h *httpServerImpl) newGraphQLSchema() (graphql.Schema, error) {
+	types := &graphQLTypes{outputs: make(map[string]*graphql.Object), inputs: make(map[string]*graphql.InputObject)}
+	appType := types.output(reflect.TypeOf(api.App{}))
+	appInputType := types.input(reflect.TypeOf(api.App{}))
+	writeResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "WriteResult",
+		Fields: graphql.Fields{
+			"id":       &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"warnings": &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"app": &graphql.Field{
+				Type:        appType,
+				Description: "an App by id, null if it does not exist",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					app, err := h.getHub(p.Context, api.Id(p.Args["id"].(string)))
+					if errors.Is(err, errAppNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, err
+					}
+					if err := authorizeRead(h.authz, p.Context, app); err != nil {
+						return nil, err
+					}
+					return app, nil
+				},
+			},
+			"apps": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(appType))),
+				Description: "the Apps matching every field of filter, or all of them without filter",
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: appInputType},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					store := h.storeOf(p.Context)
+					ids := store.List()
+					if filter, ok := p.Args["filter"]; ok {
+						query, err := appFromInput(filter)
+						if err != nil {
+							return nil, err
+						}
+						if ids, err = store.SearchStruct(query); err != nil {
+							return nil, err
+						}
+					}
+					ids = filterReadable(h.authz, store, p.Context, ids)
+					apps := make([]*api.App, 0, len(ids))
+					for _, id := range ids {
+						app, err := h.getHub(p.Context, id)
+						if errors.Is(err, errAppNotFound) {
+							// deleted meanwhile
+							continue
+						}
+						if err != nil {
+							return nil, err
+						}
+						apps = append(apps, app)
+					}
+					return apps, nil
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"putApp": &graphql.Field{
+				Type:        graphql.NewNonNull(writeResultType),
+				Description: "validates and stores a new App",
+				Args: graphql.FieldConfigArgument{
+					"app": &graphql.ArgumentConfig{Type: graphql.NewNonNull(appInputType)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					app, doc, warnings, err := h.admitInput(p.Args["app"])
+					if err != nil {
+						return nil, err
+					}
+					if err := authorize(h.authz, p.Context, VerbCreate, &app); err != nil {
+						return nil, err
+					}
+					store := h.createStoreOf(p.Context)
+					appId, err := h.audit.auditCreate(p.Context, store, doc, func() (api.Id, error) {
+						return store.Add(&app, doc)
+					})
+					if err != nil {
+						return nil, err
+					}
+					log.Infof("Successfully added app %s to the store", string(appId))
+					return map[string]interface{}{"id": string(appId), "warnings": warningStrings(warnings)}, nil
+				},
+			},
+			"updateApp": &graphql.Field{
+				Type:        graphql.NewNonNull(writeResultType),
+				Description: "validates and replaces an existing App",
+				Args: graphql.FieldConfigArgument{
+					"id":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"app": &graphql.ArgumentConfig{Type: graphql.NewNonNull(appInputType)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id := p.Args["id"].(string)
+					app, doc, warnings, err := h.admitInput(p.Args["app"])
+					if err != nil {
+						return nil, err
+					}
+					store := h.storeOf(p.Context)
+					if err := authorizeUpdate(h.authz, store, p.Context, api.Id(id), &app); err != nil {
+						return nil, err
+					}
+					err = h.audit.auditWrite(p.Context, store, VerbUpdate, api.Id(id), doc, func() error {
+						return store.Update(api.Id(id), &app, doc)
+					})
+					if err != nil {
+						return nil, err
+					}
+					log.Infof("Successfully updated app %s", id)
+					return map[string]interface{}{"id": id, "warnings": warningStrings(warnings)}, nil
+				},
+			},
+			"deleteApp": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "deletes an App, returns its id",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id := p.Args["id"].(string)
+					store := h.storeOf(p.Context)
+					if err := authorizeStored(h.authz, store, p.Context, VerbDelete, api.Id(id)); err != nil {
+						return nil, err
+					}
+					err := h.audit.auditWrite(p.Context, store, VerbDelete, api.Id(id), nil, func() error {
+						return store.Delete(api.Id(id))
+					})
+					if err != nil {
+						return nil, err
+					}
+					log.Infof("Successfully deleted app %s", id)
+					return id, nil
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+var errAppNotFound = errors.New("app not found")
+
+// getHub returns a stored App as the hub type
+func (h *httpServerImpl) getHub(ctx context.Context, id api.Id) (*api.App, error) {
+	rawApp, err := h.storeOf(ctx).Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errAppNotFound, err)
+	}
+	app, err := decodeToHub(rawApp)
+	if err != nil {
+		return nil, err
+	}
+	app.Id = id
+	return app, nil
+}
+
+// admitInput validates an AppInput argument like a put request, and returns it with the document to store
+func (h *httpServerImpl) admitInput(input interface{}) (api.App, []byte, []Warning, error) {
+	hub, err := appFromInput(input)
+	if err != nil {
+		return api.App{}, nil, nil, err
+	}
+	app, doc, warnings, validationErr, violations := evaluateHub(h.validator, h.policies, hub)
+	if validationErr != nil {
+		return app, nil, nil, fmt.Errorf("%s: %s", invalidInputMsg, validationErr.Error())
+	}
+	if len(violations) > 0 {
+		return app, nil, nil, fmt.Errorf("%s: %s", policyViolationMsg, joinViolations(violations))
+	}
+	return app, doc, warnings, nil
+}
+
+// GraphQLHandler is the handler for /graphql, queries come in a json POST body or in the query parameters of a GET.
+// Mutations are only accepted on POST.
+func (h *httpServerImpl) GraphQLHandler(w http.ResponseWriter, req *http.Request) {
+	gqlReq := graphQLRequest{}
+	if req.Method == http.MethodGet {
+		gqlReq.Query = req.URL.Query().Get("query")
+		gqlReq.OperationName = req.URL.Query().Get("operationName")
+		if variables := req.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &gqlReq.Variables); err != nil {
+				handleValidationError(w, fmt.Errorf("invalid variables: %w", err))
+				return
+			}
+		}
+	} else {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			handleReadBodyError(w, err)
+			return
+		}
+		if err := checkContentType(req, body, mediaTypeJSON); err != nil {
+			handleContentTypeError(w, err)
+			return
+		}
+		if err := json.Unmarshal(body, &gqlReq); err != nil {
+			handleValidationError(w, fmt.Errorf("invalid graphql request: %w", err))
+			return
+		}
+	}
+	if gqlReq.Query == "" {
+		handleValidationError(w, fmt.Errorf("query is required"))
+		return
+	}
+	schema, err := h.graphQLSchema()
+	if err != nil {
+		handleInternalError(w, err, "failed to build graphql schema")
+		return
+	}
+	if isMutation(gqlReq) {
+		if req.Method == http.MethodGet {
+			w.Header().Set("Allow", http.MethodPost)
+			handleMethodNotAllowedError(w, fmt.Errorf("mutations are only accepted on POST"))
+			return
+		}
+		// the queries may be anonymous, like the other reads
+		if h.authn != nil && PrincipalFromContext(req.Context()) == nil {
+			handleUnauthorizedError(w, errAuthenticationRequired)
+			return
+		}
+	}
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  gqlReq.Query,
+		VariableValues: gqlReq.Variables,
+		OperationName:  gqlReq.OperationName,
+		Context:        req.Context(),
+	})
+	// GraphQL errors are part of the result, the status is 200 as long as the request could be executed
+	writeNegotiated(w, req, http.StatusOK, result)
+}
+
+// graphQLSchema returns the GraphQL schema, built on first use
+func (h *httpServerImpl) graphQLSchema() (graphql.Schema, error) {
+	h.graphQLOnce.Do(func() {
+		h.graphQL, h.graphQLErr = h.newGraphQLSchema()
+	})
+	return h.graphQL, h.graphQLErr
+}
+
+// isMutation tells if the operation of a request is a mutation, invalid queries are left to the execution to report
+func isMutation(gqlReq graphQLRequest) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: gqlReq.Query})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if gqlReq.OperationName != "" && (op.Name == nil || op.Name.Value != gqlReq.OperationName) {
+			continue
+		}
+		if op.Operation == ast.OperationTypeMutation {
+			return true
+		}
+	}
+	return false
+}
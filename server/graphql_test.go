@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpServerImpl_GraphQLHandler(t *testing.T) {
+	handler := NewHttpServer().Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	req := httptest.NewRequest("POST", "/apps", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	do := func(query string, variables map[string]interface{}) (int, string) {
+		body, err := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+		assert.Nil(t, err)
+		req := httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	// nested field selection on a filtered list
+	code, body := do(`{ apps(filter: {title: "valid"}) { title version maintainers { email } } }`, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"data":{"apps":[{"title":"Valid App 1","version":"1.0.1","maintainers":[{"email":"firstmaintainer@hotmail.com"},{"email":"secondmaintainer@gmail.com"}]}]}}`, body)
+
+	code, body = do(`{ app(id: "1") { id labels { key value } } missing: app(id: "9") { id } }`, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"data":{"app":{"id":"1","labels":[{"key":"k","value":"xyz"}]},"missing":null}}`, body)
+
+	app := map[string]interface{}{
+		"title":       "GraphQL App",
+		"version":     "1.0.0",
+		"maintainers": []map[string]string{{"name": "a", "email": "a@b.com"}},
+		"company":     "c",
+		"website":     "https://c.com",
+		"source":      "https://github.com/c/app",
+		"license":     "MIT",
+		"description": "d",
+		"labels":      []map[string]string{{"key": "env", "value": "prod"}},
+	}
+	code, body = do(`mutation($app: AppInput!) { putApp(app: $app) { id } }`, map[string]interface{}{"app": app})
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"data":{"putApp":{"id":"2"}}}`, body)
+	code, body = do(`{ apps(filter: {labels: [{key: "env", value: "prod"}]}) { id title } }`, nil)
+	assert.JSONEq(t, `{"data":{"apps":[{"id":"2","title":"GraphQL App"}]}}`, body)
+
+	app["title"] = "Renamed App"
+	code, body = do(`mutation($app: AppInput!) { updateApp(id: "2", app: $app) { id } }`, map[string]interface{}{"app": app})
+	assert.JSONEq(t, `{"data":{"updateApp":{"id":"2"}}}`, body)
+	code, body = do(`{ app(id: "2") { title } }`, nil)
+	assert.JSONEq(t, `{"data":{"app":{"title":"Renamed App"}}}`, body)
+
+	// validation errors are GraphQL errors
+	app["maintainers"] = []map[string]string{{"name": "a", "email": "invalid"}}
+	code, body = do(`mutation($app: AppInput!) { putApp(app: $app) { id } }`, map[string]interface{}{"app": app})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "email is invalid")
+
+	code, body = do(`mutation { deleteApp(id: "2") }`, nil)
+	assert.JSONEq(t, `{"data":{"deleteApp":"2"}}`, body)
+	code, body = do(`{ apps { id } }`, nil)
+	assert.JSONEq(t, `{"data":{"apps":[{"id":"1"}]}}`, body)
+
+	code, body = do(`{ apps { licence } }`, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `Cannot query field \"licence\" on type \"App\"`)
+
+	// queries on GET, not mutations
+	req = httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`{ app(id: "1") { title } }`), nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.JSONEq(t, `{"data":{"app":{"title":"Valid App 1"}}}`, w.Body.String())
+	req = httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`mutation { deleteApp(id: "1") }`), nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	code, _ = do("", nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
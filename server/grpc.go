@@ -0,0 +1,315 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"application_metadata_api_server/server/pb"
+	"context"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServerImpl is the gRPC API of the store, it accepts the same Options as the HttpServer
+type grpcServerImpl struct {
+	pb.UnimplementedAppServiceServer
+	// namespaces holds the Apps, the namespace of a call is the x-namespace metadata
+	namespaces cache.Namespaces
+	validator  Validator
+	policies   PolicyEngine
+	authz      Authorizer
+	audit      *AuditLog
+	shutdown   <-chan struct{}
+}
+
+// NewGrpcServer returns the AppService implementation, to register on a grpc.Server.
+// Pass WithNamespaces to serve the same Apps as an HttpServer.
+func NewGrpcServer(opts ...Option) pb.AppServiceServer {
+	o := newServerOptions(opts...)
+	return &grpcServerImpl{
+		namespaces: o.namespaces,
+		validator:  o.metrics.validator(newAppValidator(o.validatorOpts...)),
+		policies:   o.policies,
+		authz:      o.authz,
+		audit:      o.audit,
+		shutdown:   o.shutdown,
+	}
+}
+
+func (g *grpcServerImpl) Put(ctx context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	ctx, store, err := g.namespaced(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	app, doc, warnings, err := g.admit(req.GetApp())
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(g.authz, ctx, VerbCreate, &app); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	store = g.namespaces.Store(NamespaceFromContext(ctx))
+	appId, err := g.audit.auditCreate(ctx, store, doc, func() (api.Id, error) {
+		return store.Add(&app, doc)
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to put app: %v", err)
+	}
+	log.Infof("Successfully added app %s to the store", string(appId))
+	return &pb.PutResponse{Id: string(appId), Warnings: warningStrings(warnings)}, nil
+}
+
+func (g *grpcServerImpl) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	ctx, store, err := g.namespaced(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	rawApp, err := store.Get(api.Id(req.GetId()))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	app, err := decodeToHub(rawApp)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to decode app %s: %v", req.GetId(), err)
+	}
+	if err := authorizeRead(g.authz, ctx, app); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	app.Id = api.Id(req.GetId())
+	return &pb.GetResponse{App: appToProto(app)}, nil
+}
+
+func (g *grpcServerImpl) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.UpdateResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	ctx, store, err := g.namespaced(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	app, doc, warnings, err := g.admit(req.GetApp())
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeUpdate(g.authz, store, ctx, api.Id(req.GetId()), &app); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	err = g.audit.auditWrite(ctx, store, VerbUpdate, api.Id(req.GetId()), doc, func() error {
+		return store.Update(api.Id(req.GetId()), &app, doc)
+	})
+	if err != nil {
+		return nil, storeError(err)
+	}
+	log.Infof("Successfully updated app %s", req.GetId())
+	return &pb.UpdateResponse{Warnings: warningStrings(warnings)}, nil
+}
+
+func (g *grpcServerImpl) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	ctx, store, err := g.namespaced(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeStored(g.authz, store, ctx, VerbDelete, api.Id(req.GetId())); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	err = g.audit.auditWrite(ctx, store, VerbDelete, api.Id(req.GetId()), nil, func() error {
+		return store.Delete(api.Id(req.GetId()))
+	})
+	if err != nil {
+		return nil, storeError(err)
+	}
+	log.Infof("Successfully deleted app %s", req.GetId())
+	return &pb.DeleteResponse{}, nil
+}
+
+func (g *grpcServerImpl) Search(ctx context.Context, req *pb.SearchRequest) (*pb.SearchResponse, error) {
+	ctx, store, err := g.namespaced(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	query := appFromProto(req.GetQuery())
+	var ids []api.Id
+	if store == nil {
+		if err := authorizeAllNamespaces(g.authz, ctx); err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		ids, err = searchAllNamespaces(g.authz, g.namespaces, ctx, query)
+	} else {
+		if ids, err = store.SearchStruct(query); err == nil {
+			ids = filterReadable(g.authz, store, ctx, ids)
+		}
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "search failed: %v", err)
+	}
+	resp := &pb.SearchResponse{Ids: make([]string, 0, len(ids))}
+	for _, id := range ids {
+		resp.Ids = append(resp.Ids, string(id))
+	}
+	return resp, nil
+}
+
+func (g *grpcServerImpl) Watch(req *pb.WatchRequest, stream pb.AppService_WatchServer) error {
+	ctx, _, err := g.namespaced(stream.Context(), false)
+	if err != nil {
+		return err
+	}
+	store, ok := g.namespaces.Lookup(NamespaceFromContext(ctx))
+	if !ok {
+		return status.Error(codes.NotFound, errNamespaceNotFound(NamespaceFromContext(ctx)).Error())
+	}
+	events, cancel := store.Watch()
+	defer cancel()
+	// the headers tell the client the watch is registered, the changes from now on are sent
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-g.shutdown:
+			return status.Error(codes.Unavailable, shuttingDownMsg+", watch again")
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.Aborted, "watch fell behind the changes of the store, get the apps and watch again")
+			}
+			if event, ok = filterEvent(g.authz, ctx, event); !ok {
+				continue
+			}
+			pbEvent, err := eventToProto(event)
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to decode app %s: %v", event.Id, err)
+			}
+			if err := stream.Send(pbEvent); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// admit validates an App like a put request, and returns it with the document to store
+func (g *grpcServerImpl) admit(pbApp *pb.App) (api.App, []byte, []Warning, error) {
+	if pbApp == nil {
+		return api.App{}, nil, nil, status.Error(codes.InvalidArgument, "app is required")
+	}
+	app, doc, warnings, validationErr, violations := evaluateHub(g.validator, g.policies, appFromProto(pbApp))
+	if validationErr != nil {
+		return app, nil, nil, status.Errorf(codes.InvalidArgument, "%s: %s", invalidInputMsg, validationErr.Error())
+	}
+	if len(violations) > 0 {
+		return app, nil, nil, status.Errorf(codes.FailedPrecondition, "%s: %s", policyViolationMsg, joinViolations(violations))
+	}
+	return app, doc, warnings, nil
+}
+
+func storeError(err error) error {
+	if errors.Is(err, cache.ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func warningStrings(warnings []Warning) []string {
+	rs := make([]string, 0, len(warnings))
+	for _, w := range warnings {
+		rs = append(rs, w.String())
+	}
+	return rs
+}
+
+var eventTypes = map[cache.EventType]pb.WatchEvent_Type{
+	cache.EventAdded:    pb.WatchEvent_ADDED,
+	cache.EventModified: pb.WatchEvent_MODIFIED,
+	cache.EventDeleted:  pb.WatchEvent_DELETED,
+}
+
+func eventToProto(event cache.Event) (*pb.WatchEvent, error) {
+	pbEvent := &pb.WatchEvent{
+		Type:     eventTypes[event.Type],
+		Id:       string(event.Id),
+		Revision: event.Revision,
+	}
+	if event.Raw != nil {
+		app, err := decodeToHub(event.Raw)
+		if err != nil {
+			return nil, err
+		}
+		app.Id = event.Id
+		pbEvent.App = appToProto(app)
+	}
+	return pbEvent, nil
+}
+
+func appFromProto(in *pb.App) *api.App {
+	app := &api.App{}
+	if in == nil {
+		return app
+	}
+	app.Id = api.Id(in.GetId())
+	app.Title = in.GetTitle()
+	app.Version = in.GetVersion()
+	for _, m := range in.GetMaintainers() {
+		app.Maintainers = append(app.Maintainers, maintainerFromProto(m))
+	}
+	app.Company = in.GetCompany()
+	app.Website = in.GetWebsite()
+	app.Source = in.GetSource()
+	app.License = in.GetLicense()
+	app.Description = in.GetDescription()
+	app.Labels = in.GetLabels()
+	app.Release = releaseFromProto(in.GetRelease())
+	for _, r := range in.GetReleaseHistory() {
+		app.ReleaseHistory = append(app.ReleaseHistory, releaseFromProto(r))
+	}
+	for _, d := range in.GetDependencies() {
+		app.Dependencies = append(app.Dependencies, api.Dependency{Name: d.GetName(), Version: d.GetVersion()})
+	}
+	return app
+}
+
+func maintainerFromProto(in *pb.Maintainer) api.Maintainer {
+	return api.Maintainer{Name: in.GetName(), Email: in.GetEmail()}
+}
+
+func releaseFromProto(in *pb.Release) api.Release {
+	return api.Release{Name: in.GetName(), Comment: in.GetComment(), Author: maintainerFromProto(in.GetAuthor())}
+}
+
+func appToProto(app *api.App) *pb.App {
+	out := &pb.App{
+		Id:          string(app.Id),
+		Title:       app.Title,
+		Version:     app.Version,
+		Company:     app.Company,
+		Website:     app.Website,
+		Source:      app.Source,
+		License:     app.License,
+		Description: app.Description,
+		Labels:      app.Labels,
+	}
+	for _, m := range app.Maintainers {
+		out.Maintainers = append(out.Maintainers, maintainerToProto(m))
+	}
+	if app.Release != (api.Release{}) {
+		out.Release = releaseToProto(app.Release)
+	}
+	for _, r := range app.ReleaseHistory {
+		out.ReleaseHistory = append(out.ReleaseHistory, releaseToProto(r))
+	}
+	for _, d := range app.Dependencies {
+		out.Dependencies = append(out.Dependencies, &pb.Dependency{Name: d.Name, Version: d.Version})
+	}
+	return out
+}
+
+func maintainerToProto(m api.Maintainer) *pb.Maintainer {
+	return &pb.Maintainer{Name: m.Name, Email: m.Email}
+}
+
+func releaseToProto(r api.Release) *pb.Release {
+	return &pb.Release{Name: r.Name, Comment: r.Comment, Author: maintainerToProto(r.Author)}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/pb"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// newGrpcTestClient serves a grpc server on an in-process listener, and returns a client connected to it
+func newGrpcTestClient(t *testing.T, opts ...Option) pb.AppServiceClient {
+	return newGrpcTestClientWithServerOptions(t, nil, opts...)
+}
+
+// newGrpcTestClientWithServerOptions is newGrpcTestClient with options of the grpc server, such as interceptors
+func newGrpcTestClientWithServerOptions(t *testing.T, serverOpts []grpc.ServerOption, opts ...Option) pb.AppServiceClient {
+	listener := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer(serverOpts...)
+	pb.RegisterAppServiceServer(s, NewGrpcServer(opts...))
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewAppServiceClient(conn)
+}
+
+func newTestProtoApp(title string) *pb.App {
+	return &pb.App{
+		Title:       title,
+		Version:     "1.0.0",
+		Maintainers: []*pb.Maintainer{{Name: "first last", Email: "apptwo@hotmail.com"}},
+		Company:     "Upbound Inc.",
+		Website:     "https://upbound.io",
+		Source:      "https://github.com/upbound/repo",
+		License:     "Apache-2.0",
+		Description: "a valid app",
+		Labels:      map[string]string{"env": "prod"},
+		Release:     &pb.Release{Name: "r2", Author: &pb.Maintainer{Name: "a", Email: "a@b.com"}},
+		ReleaseHistory: []*pb.Release{
+			{Name: "r1", Author: &pb.Maintainer{Name: "a", Email: "a@b.com"}},
+		},
+		Dependencies: []*pb.Dependency{{Name: "postgres", Version: "14"}},
+	}
+}
+
+func TestGrpcServerImpl(t *testing.T) {
+	client := newGrpcTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	putResp, err := client.Put(ctx, &pb.PutRequest{App: newTestProtoApp("grpc app")})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", putResp.Id)
+
+	getResp, err := client.Get(ctx, &pb.GetRequest{Id: "1"})
+	assert.Nil(t, err)
+	expected := newTestProtoApp("grpc app")
+	expected.Id = "1"
+	assert.True(t, proto.Equal(expected, getResp.App), "got %v", getResp.App)
+
+	invalid := newTestProtoApp("invalid")
+	invalid.Maintainers[0].Email = "not an email"
+	_, err = client.Put(ctx, &pb.PutRequest{App: invalid})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Put(ctx, &pb.PutRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	searchResp, err := client.Search(ctx, &pb.SearchRequest{Query: &pb.App{Labels: map[string]string{"env": "prod"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1"}, searchResp.Ids)
+
+	_, err = client.Update(ctx, &pb.UpdateRequest{Id: "1", App: newTestProtoApp("renamed")})
+	assert.Nil(t, err)
+	searchResp, err = client.Search(ctx, &pb.SearchRequest{Query: &pb.App{Title: "renamed"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1"}, searchResp.Ids)
+	_, err = client.Update(ctx, &pb.UpdateRequest{Id: "9", App: newTestProtoApp("renamed")})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Id: "1"})
+	assert.Nil(t, err)
+	_, err = client.Get(ctx, &pb.GetRequest{Id: "1"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Id: "1"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGrpcServerImpl_Watch(t *testing.T) {
+	store := cache.InitStore()
+	client := newGrpcTestClient(t, WithStore(store))
+	httpHandler := NewHttpServer(WithStore(store)).Handler()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &pb.WatchRequest{})
+	assert.Nil(t, err)
+	// the watch is registered once the stream headers are received
+	_, err = stream.Header()
+	assert.Nil(t, err)
+
+	_, err = client.Put(ctx, &pb.PutRequest{App: newTestProtoApp("grpc app")})
+	assert.Nil(t, err)
+	event, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, pb.WatchEvent_ADDED, event.Type)
+	assert.Equal(t, "1", event.Id)
+	assert.Equal(t, "grpc app", event.App.Title)
+
+	// writes through the http server sharing the store are watched too
+	doDelete(t, httpHandler, "/apps/1")
+	event, err = stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, pb.WatchEvent_DELETED, event.Type)
+	assert.Equal(t, "1", event.Id)
+	assert.Nil(t, event.App)
+	assert.Equal(t, int64(2), event.Revision)
+}
+
+func TestGrpcServerImpl_Watch_Shutdown(t *testing.T) {
+	shutdown := make(chan struct{})
+	client := newGrpcTestClient(t, WithShutdown(shutdown))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &pb.WatchRequest{})
+	assert.Nil(t, err)
+	_, err = stream.Header()
+	assert.Nil(t, err)
+	close(shutdown)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, "server is shutting down, watch again", status.Convert(err).Message())
+}
+
+func doDelete(t *testing.T, handler http.Handler, target string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, target, nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
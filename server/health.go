@@ -0,0 +1,194 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheck returns why the server is not healthy, nil when it is. ctx is done when the probe gives up.
+type HealthCheck func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// Health serves the liveness probe /livez, failing when the server must be restarted, and the readiness probe
+// /readyz, failing when the server must not get traffic. Each runs the checks added to it, in order.
+type Health struct {
+	lock   sync.RWMutex
+	livez  []namedCheck
+	readyz []namedCheck
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// AddLivenessCheck adds a check to /livez, served alone on /livez/<name>
+func (h *Health) AddLivenessCheck(name string, check HealthCheck) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.livez = append(h.livez, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck adds a check to /readyz, served alone on /readyz/<name>
+func (h *Health) AddReadinessCheck(name string, check HealthCheck) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.readyz = append(h.readyz, namedCheck{name: name, check: check})
+}
+
+// Handler serves /livez and /readyz, answering 200 "ok" when every check passes and 503 otherwise.
+// With the verbose query parameter, or when a check fails, the response has a line per check,
+// the reasons of the failures are only given in verbose mode.
+func (h *Health) Handler() http.Handler {
+	r := newRouter()
+	for _, probe := range []string{"livez", "readyz"} {
+		probe := probe
+		r.handle(http.MethodGet, "/"+probe, func(w http.ResponseWriter, req *http.Request) {
+			h.serveChecks(w, req, probe, h.checks(probe))
+		})
+		r.handle(http.MethodGet, "/"+probe+"/{check}", func(w http.ResponseWriter, req *http.Request) {
+			name := pathParam(req, "check")
+			for _, c := range h.checks(probe) {
+				if c.name == name {
+					h.serveChecks(w, req, probe, []namedCheck{c})
+					return
+				}
+			}
+			handleNotFoundError(w, fmt.Errorf("no %s check %q", probe, name))
+		})
+	}
+	return r
+}
+
+func (h *Health) checks(probe string) []namedCheck {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if probe == "livez" {
+		return h.livez
+	}
+	return h.readyz
+}
+
+func (h *Health) serveChecks(w http.ResponseWriter, req *http.Request, probe string, checks []namedCheck) {
+	_, verbose := req.URL.Query()["verbose"]
+	var out strings.Builder
+	failed := false
+	for _, c := range checks {
+		err := runCheck(req.Context(), c.check)
+		switch {
+		case err == nil:
+			fmt.Fprintf(&out, "[+]%s ok\n", c.name)
+		case verbose:
+			failed = true
+			fmt.Fprintf(&out, "[-]%s failed: %v\n", c.name, err)
+		default:
+			failed = true
+			fmt.Fprintf(&out, "[-]%s failed: reason withheld\n", c.name)
+		}
+	}
+	w.Header().Set("Content-Type", mediaTypeText)
+	w.Header().Set("Cache-Control", "no-store")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%s%s check failed\n", out.String(), probe)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if verbose {
+		fmt.Fprintf(w, "%s%s check passed\n", out.String(), probe)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// runCheck runs check until ctx is done, so a check blocked on a lock fails rather than hanging the probe
+func runCheck(ctx context.Context, check HealthCheck) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+}
+
+// ShutdownCheck fails once shutdown is closed, so the load balancers stop routing to a server shutting down
+func ShutdownCheck(shutdown <-chan struct{}) HealthCheck {
+	return func(context.Context) error {
+		select {
+		case <-shutdown:
+			return errors.New(shuttingDownMsg)
+		default:
+			return nil
+		}
+	}
+}
+
+// StoreLockCheck fails when the Store of a namespace does not answer, e.g. its lock is never released,
+// as a liveness check
+func StoreLockCheck(namespaces cache.Namespaces) HealthCheck {
+	return func(ctx context.Context) error {
+		for _, namespace := range namespaces.List() {
+			namespaces.Store(namespace).Revision()
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// IndexCheck checks every interval, until stop is closed, that the search space of each namespace indexes exactly
+// its Apps, and fails with the result of the last check, so the searches of a server with a corrupted index are
+// routed to another one. A check reads every App under the lock of its Store, the probes only read its result.
+// It fails until the first check, run at once, is done.
+func IndexCheck(namespaces cache.Namespaces, interval time.Duration, stop <-chan struct{}) HealthCheck {
+	var lock sync.Mutex
+	last := errors.New("the index is not checked yet")
+	check := func() {
+		err := checkIndexes(namespaces)
+		lock.Lock()
+		defer lock.Unlock()
+		last = err
+	}
+	go func() {
+		check()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+	return func(context.Context) error {
+		lock.Lock()
+		defer lock.Unlock()
+		return last
+	}
+}
+
+// checkIndexes returns an error when the search space of a namespace does not index exactly its Apps
+func checkIndexes(namespaces cache.Namespaces) error {
+	for _, namespace := range namespaces.List() {
+		if err := namespaces.Store(namespace).Check(); err != nil {
+			return fmt.Errorf("namespace %s: %w", namespace, err)
+		}
+	}
+	return nil
+}
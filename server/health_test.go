@@ -0,0 +1,116 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/cache/mocks"
+	"application_metadata_api_server/server/api"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth_Handler(t *testing.T) {
+	shutdown := make(chan struct{})
+	namespaces := cache.InitNamespaces()
+	health := NewHealth()
+	health.AddLivenessCheck("store", StoreLockCheck(namespaces))
+	health.AddReadinessCheck("shutdown", ShutdownCheck(shutdown))
+	health.AddReadinessCheck("index", IndexCheck(namespaces, 10*time.Millisecond, shutdown))
+	var replicationErr error
+	health.AddReadinessCheck("replication", func(context.Context) error {
+		return replicationErr
+	})
+	handler := health.Handler()
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		return rr
+	}
+	_, err := namespaces.Store("payments").Add(&api.App{Title: "t1"}, []byte("title: t1"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return get("/readyz/index").Code == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	for _, target := range []string{"/livez", "/readyz", "/readyz/index"} {
+		rr := get(target)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "ok\n", rr.Body.String())
+	}
+	rr := get("/readyz?verbose")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "[+]shutdown ok\n[+]index ok\n[+]replication ok\nreadyz check passed\n", rr.Body.String())
+	assert.Equal(t, http.StatusNotFound, get("/readyz/unknown").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, func() int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/livez", nil))
+		return rr.Code
+	}())
+
+	// the reasons of the failures are withheld unless verbose
+	replicationErr = errors.New("3 changes behind")
+	rr = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "[+]shutdown ok\n[+]index ok\n[-]replication failed: reason withheld\nreadyz check failed\n", rr.Body.String())
+	rr = get("/readyz/replication?verbose")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "[-]replication failed: 3 changes behind\nreadyz check failed\n", rr.Body.String())
+	replicationErr = nil
+
+	close(shutdown)
+	rr = get("/readyz?verbose")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "[-]shutdown failed: server is shutting down\n")
+	assert.Equal(t, http.StatusOK, get("/livez").Code)
+}
+
+func TestIndexCheck(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	mockStore := &mocks.Store{}
+	mockStore.On("Check").Return(errors.New("title: t1 indexes 2 but not 1")).Once()
+	mockStore.On("Check").Return(nil)
+	check := IndexCheck(cache.NewNamespaces(mockStore), time.Hour, stop)
+
+	// the probes get the result of the last check, they do not check the index
+	assert.Eventually(t, func() bool {
+		return check(context.Background()) != nil && check(context.Background()).Error() == "namespace default: title: t1 indexes 2 but not 1"
+	}, time.Second, time.Millisecond)
+	for i := 0; i < 10; i++ {
+		assert.NotNil(t, check(context.Background()))
+	}
+	mockStore.AssertNumberOfCalls(t, "Check", 1)
+}
+
+func TestRunCheck_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	blocked := make(chan struct{})
+	defer close(blocked)
+	err := runCheck(ctx, func(context.Context) error {
+		<-blocked
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestAuditLog_Check(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path, 0, 0)
+	assert.Nil(t, err)
+	defer audit.Close()
+	assert.Nil(t, audit.Check(context.Background()))
+
+	assert.Nil(t, os.Remove(path))
+	assert.NotNil(t, audit.Check(context.Background()))
+	assert.Nil(t, ioutil.WriteFile(path, nil, 0600))
+	assert.EqualError(t, audit.Check(context.Background()), path+" was replaced since it was opened")
+}
@@ -3,11 +3,20 @@ package server
 import (
 	"application_metadata_api_server/cache"
 	"application_metadata_api_server/server/api"
+	v2 "application_metadata_api_server/server/api/v2"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/graphql-go/graphql"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"sync"
 )
 
 // HttpServer is the interface of API server
@@ -18,96 +27,685 @@ type HttpServer interface {
 	GetHandler(w http.ResponseWriter, req *http.Request)
 	// SearchHandler is the handler for search request, returns a list of matching App Ids
 	SearchHandler(w http.ResponseWriter, req *http.Request)
+	// ValidateHandler is the handler for validate request, runs the put validation without storing the App
+	ValidateHandler(w http.ResponseWriter, req *http.Request)
+	// SchemaHandler is the handler for schema request, returns the JSON Schema of App in the requested apiVersion
+	SchemaHandler(w http.ResponseWriter, req *http.Request)
+	// CreateHandler is the handler for POST /apps, returns 201 with the new App Id and its Location
+	CreateHandler(w http.ResponseWriter, req *http.Request)
+	// GetAppHandler is the handler for GET /apps/{id}, returns the App content
+	GetAppHandler(w http.ResponseWriter, req *http.Request)
+	// ReplaceHandler is the handler for PUT /apps/{id}, replaces the whole App
+	ReplaceHandler(w http.ResponseWriter, req *http.Request)
+	// PatchHandler is the handler for PATCH /apps/{id}, applies a JSON patch (application/json-patch+json)
+	// or a JSON merge patch (any other Content-Type) to the App
+	PatchHandler(w http.ResponseWriter, req *http.Request)
+	// DeleteHandler is the handler for DELETE /apps/{id}
+	DeleteHandler(w http.ResponseWriter, req *http.Request)
+	// ListHandler is the handler for GET /apps, returns the App Ids matching the query parameters
+	ListHandler(w http.ResponseWriter, req *http.Request)
+	// ImportHandler is the handler for POST /import, stores a multi-document yaml or ndjson stream of Apps
+	// and returns the result of each document
+	ImportHandler(w http.ResponseWriter, req *http.Request)
+	// ExportHandler is the handler for GET /export, streams every App as multi-document yaml or ndjson
+	ExportHandler(w http.ResponseWriter, req *http.Request)
+	// BatchHandler is the handler for POST /batch, applies several create, update and delete operations,
+	// all of them or none
+	BatchHandler(w http.ResponseWriter, req *http.Request)
+	// WatchHandler is the handler for GET /watch, streams the changes of the store as ndjson WatchEvent lines
+	WatchHandler(w http.ResponseWriter, req *http.Request)
+	// GraphQLHandler is the handler for /graphql, runs GraphQL queries and mutations on the Apps
+	GraphQLHandler(w http.ResponseWriter, req *http.Request)
+	// OpenAPIHandler is the handler for GET /openapi.json, returns the OpenAPI document of every endpoint
+	OpenAPIHandler(w http.ResponseWriter, req *http.Request)
+	// SwaggerUIHandler is the handler for GET /docs, serves a Swagger UI page browsing /openapi.json
+	SwaggerUIHandler(w http.ResponseWriter, req *http.Request)
+	// SwaggerUIAssetHandler is the handler for GET /docs/{asset}, serves the files of Swagger UI loaded by /docs
+	SwaggerUIAssetHandler(w http.ResponseWriter, req *http.Request)
+	// AuditHandler is the handler for GET /audit, returns the records of the audit log matching the query parameters
+	AuditHandler(w http.ResponseWriter, req *http.Request)
+	// Handler returns the router serving every endpoint of the API server
+	Handler() http.Handler
 }
 
 // httpServerImpl is an implementation of HttpServer
 type httpServerImpl struct {
-	store     cache.Store
-	validator Validator
+	// store is the store of the default namespace
+	store cache.Store
+	// namespaces is optional, when set the requests naming another namespace are served by its store
+	namespaces cache.Namespaces
+	validator  Validator
+	// policies is optional, when set it is evaluated on put after the validation
+	policies PolicyEngine
+	// authn is optional, when set the requests are authenticated and write requests must be
+	authn Authenticator
+	// authz is optional, when set it authorizes the writes of the principals and may filter their searches
+	authz Authorizer
+	// audit is optional, when set every write is recorded in it
+	audit *AuditLog
+	// limiter is optional, when set the clients over their limits are answered 429
+	limiter *RateLimiter
+	// maxBodySize is optional, when set the request bodies longer than it are answered 413
+	maxBodySize int64
+	// shutdown is optional, when it is closed the watch streams end
+	shutdown <-chan struct{}
+	// metrics is optional, when set the requests and the validation failures are counted
+	metrics *Metrics
+	// swaggerUIDir is optional, when set /docs serves the Swagger UI release it holds
+	swaggerUIDir string
+	// graphQL is the schema of GraphQLHandler, built on first use
+	graphQLOnce sync.Once
+	graphQL     graphql.Schema
+	graphQLErr  error
+	// openAPI is the document of OpenAPIHandler, built on first use
+	openAPIOnce sync.Once
+	openAPI     []byte
+	openAPIErr  error
 }
 
-func NewHttpServer() HttpServer {
+// serverOptions collects the settings applied by Option before the server is built
+type serverOptions struct {
+	validatorOpts []validatorOption
+	policies      PolicyEngine
+	store         cache.Store
+	namespaces    cache.Namespaces
+	authn         Authenticator
+	authz         Authorizer
+	audit         *AuditLog
+	limiter       *RateLimiter
+	maxBodySize   int64
+	shutdown      <-chan struct{}
+	metrics       *Metrics
+	swaggerUIDir  string
+}
+
+// Option configures the HttpServer returned by NewHttpServer
+type Option func(o *serverOptions)
+
+// WithSchemaValidation enforces the published JSON Schema on every put request
+func WithSchemaValidation() Option {
+	return func(o *serverOptions) {
+		o.validatorOpts = append(o.validatorOpts, withSchemaValidation())
+	}
+}
+
+// WithMaxDescriptionSize sets the description size in bytes above which validation reports a warning
+func WithMaxDescriptionSize(n int) Option {
+	return func(o *serverOptions) {
+		o.validatorOpts = append(o.validatorOpts, withMaxDescriptionBytes(n))
+	}
+}
+
+// WithPolicyEngine evaluates the organisation policies on every put request
+func WithPolicyEngine(engine PolicyEngine) Option {
+	return func(o *serverOptions) {
+		o.policies = engine
+	}
+}
+
+// WithStore serves the given store as the default namespace, so several servers share the same Apps.
+// By default each server has its own.
+func WithStore(store cache.Store) Option {
+	return func(o *serverOptions) {
+		o.store = store
+	}
+}
+
+// WithNamespaces serves the given namespaces, so several servers share the same Apps in every namespace.
+// Their default namespace replaces the store of WithStore.
+func WithNamespaces(namespaces cache.Namespaces) Option {
+	return func(o *serverOptions) {
+		o.namespaces = namespaces
+	}
+}
+
+// WithAuthenticator authenticates the http requests, and rejects the write requests without valid credentials.
+// The principal of a request is returned by PrincipalFromContext.
+func WithAuthenticator(authn Authenticator) Option {
+	return func(o *serverOptions) {
+		o.authn = authn
+	}
+}
+
+// WithAuthorizer restricts what the principals may do on which Apps, the writes it denies are answered 403.
+// Combine it with WithAuthenticator, anonymous requests are denied every write.
+func WithAuthorizer(authz Authorizer) Option {
+	return func(o *serverOptions) {
+		o.authz = authz
+	}
+}
+
+// WithAuditLog records every write on the Apps in the audit log, with its principal and the diff of the App,
+// and serves the records on GET /audit
+func WithAuditLog(audit *AuditLog) Option {
+	return func(o *serverOptions) {
+		o.audit = audit
+	}
+}
+
+// WithRateLimiter limits the http requests of each client, the requests over the limits are answered 429, and
+// the failed authentications of each address. Rate limit the grpc calls with GrpcRateLimitInterceptors, and pass
+// the limiter to GrpcAuthInterceptors.
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(o *serverOptions) {
+		o.limiter = limiter
+	}
+}
+
+// WithMaxBodySize answers 413 to the http requests with a body longer than n bytes, 0 does not limit the bodies
+func WithMaxBodySize(n int64) Option {
+	return func(o *serverOptions) {
+		o.maxBodySize = n
+	}
+}
+
+// WithSwaggerUIDir serves Swagger UI on /docs, from dir holding the swagger-ui.css and swagger-ui-bundle.js
+// files of a swagger-ui-dist release, e.g. installed by make swagger-ui
+func WithSwaggerUIDir(dir string) Option {
+	return func(o *serverOptions) {
+		o.swaggerUIDir = dir
+	}
+}
+
+// WithShutdown ends the watch streams when shutdown is closed, so that draining the servers on shutdown
+// does not wait for the watching clients to disconnect
+func WithShutdown(shutdown <-chan struct{}) Option {
+	return func(o *serverOptions) {
+		o.shutdown = shutdown
+	}
+}
+
+// WithMetrics counts the http requests and the validation failures in metrics, serve them with Metrics.Handler
+func WithMetrics(metrics *Metrics) Option {
+	return func(o *serverOptions) {
+		o.metrics = metrics
+	}
+}
+
+// newServerOptions applies opts over the defaults
+func newServerOptions(opts ...Option) *serverOptions {
+	o := &serverOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.namespaces != nil {
+		o.store = o.namespaces.Store(cache.DefaultNamespace)
+		return o
+	}
+	if o.store == nil {
+		o.store = cache.InitStore()
+	}
+	o.namespaces = cache.NewNamespaces(o.store)
+	return o
+}
+
+func NewHttpServer(opts ...Option) HttpServer {
+	o := newServerOptions(opts...)
 	return &httpServerImpl{
-		store:     cache.InitStore(),
-		validator: newAppValidator(),
+		store:        o.store,
+		namespaces:   o.namespaces,
+		validator:    o.metrics.validator(newAppValidator(o.validatorOpts...)),
+		policies:     o.policies,
+		authn:        o.authn,
+		authz:        o.authz,
+		audit:        o.audit,
+		limiter:      o.limiter,
+		maxBodySize:  o.maxBodySize,
+		shutdown:     o.shutdown,
+		metrics:      o.metrics,
+		swaggerUIDir: o.swaggerUIDir,
 	}
 }
 
 func (h *httpServerImpl) PutHandler(w http.ResponseWriter, req *http.Request) {
+	h.createApp(w, req, http.StatusOK)
+}
+
+func (h *httpServerImpl) CreateHandler(w http.ResponseWriter, req *http.Request) {
+	h.createApp(w, req, http.StatusCreated)
+}
+
+// createApp validates and stores a new App, and responds with its Id and the given status code.
+// A 201 response also has the Location of the App.
+func (h *httpServerImpl) createApp(w http.ResponseWriter, req *http.Request, status int) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		handleInternalError(w, err, "error reading request body")
+		handleReadBodyError(w, err)
 		return
 	}
-	app, err := h.validator.ValidatePut(body)
-	if err != nil {
-		log.Warnf("invalid input yaml: %+v", err)
-		handleValidationError(w, err)
+	if err := checkContentType(req, body, mediaTypeJSON, mediaTypeYAML); err != nil {
+		handleContentTypeError(w, err)
 		return
 	}
-	appId, err := h.store.Add(&app, body)
-	if err != nil {
-		handleInternalError(w, err, fmt.Sprintf("failed to put %+v", app))
+	if dryRun, _ := strconv.ParseBool(req.URL.Query().Get("dryRun")); dryRun {
+		h.writeValidationReport(w, req, body)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	resp := make(map[string]string)
-	resp["message"] = "App Created"
-	resp["id"] = string(appId)
-	jsonResp, err := json.Marshal(resp)
+	app, warnings, ok := h.admit(w, req, body)
+	if !ok {
+		return
+	}
+	if err := authorize(h.authz, req.Context(), VerbCreate, &app); err != nil {
+		handleForbiddenError(w, err)
+		return
+	}
+	store := h.createStoreOf(req.Context())
+	appId, err := h.audit.auditCreate(req.Context(), store, body, func() (api.Id, error) {
+		return store.Add(&app, body)
+	})
 	if err != nil {
-		log.Errorf("Error happened in JSON marshal error: %+v", err)
-		handleInternalError(w, err, "json marshal error")
+		handleInternalError(w, err, fmt.Sprintf("failed to put %+v", app))
 		return
 	}
-	w.Write(jsonResp)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", appLocation(req, appId))
+	}
+	writeAppResponse(w, req, status, "App Created", appId, warnings)
 	log.Infof("Successfully added app %s to the store", string(appId))
 }
 
+// admit runs the validation and the policies on a put document, on failure the error response is written
+// and false is returned
+func (h *httpServerImpl) admit(w http.ResponseWriter, req *http.Request, body []byte) (api.App, []Warning, bool) {
+	app, warnings, validationErr, violations := h.evaluate(req, body)
+	if validationErr != nil {
+		handleValidationError(w, validationErr)
+		return app, nil, false
+	}
+	if len(violations) > 0 {
+		handlePolicyViolationError(w, violations)
+		return app, nil, false
+	}
+	return app, warnings, true
+}
+
+// evaluate runs the validation and the policies on a put document, the policies only run on valid documents
+func (h *httpServerImpl) evaluate(req *http.Request, body []byte) (api.App, []Warning, ValidationError, []PolicyViolation) {
+	return evaluatePut(h.validator, h.policies, body, putOptionsFromRequest(req))
+}
+
+// evaluatePut is the put admission shared by the http and grpc servers
+func evaluatePut(validator Validator, policies PolicyEngine, body []byte, opts PutOptions) (api.App, []Warning, ValidationError, []PolicyViolation) {
+	app, warnings, validationErr := validator.ValidatePutWithOptions(body, opts)
+	if validationErr != nil {
+		log.Warnf("invalid input yaml: %+v", validationErr)
+		return app, nil, validationErr, nil
+	}
+	violations, policyWarnings := applyPolicies(policies, &app)
+	if len(violations) > 0 {
+		log.Warnf("policy violations: %+v", violations)
+		return app, nil, nil, violations
+	}
+	return app, append(warnings, policyWarnings...), nil, nil
+}
+
+// evaluateHub is evaluatePut for Apps received as typed objects (grpc, graphql) rather than documents.
+// The App is encoded as a v2 document, the apiVersion holding every field of the hub, which is returned to be stored.
+func evaluateHub(validator Validator, policies PolicyEngine, hub *api.App) (api.App, []byte, []Warning, ValidationError, []PolicyViolation) {
+	hub.Id = ""
+	versioned := &v2.App{}
+	if err := versioned.ConvertFrom(hub); err != nil {
+		return api.App{}, nil, nil, NewInvalidSpec(err), nil
+	}
+	doc, err := yaml.Marshal(versioned)
+	if err != nil {
+		return api.App{}, nil, nil, NewInvalidSpec(err), nil
+	}
+	app, warnings, validationErr, violations := evaluatePut(validator, policies, doc, PutOptions{Mode: DecodeStrict, APIVersion: v2.APIVersion})
+	return app, doc, warnings, validationErr, violations
+}
+
+// WriteResponse is the body of a successful write on an App
+type WriteResponse struct {
+	Id       api.Id    `json:"id"`
+	Message  string    `json:"message"`
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// writeAppResponse writes the response of a successful write on an App
+func writeAppResponse(w http.ResponseWriter, req *http.Request, status int, message string, appId api.Id, warnings []Warning) {
+	writeNegotiated(w, req, status, &WriteResponse{Id: appId, Message: message, Warnings: warnings})
+}
+
+// appLocation returns the url of an App, under the apiVersion prefix of the request if any
+func appLocation(req *http.Request, appId api.Id) string {
+	location := "/apps/" + url.PathEscape(string(appId))
+	if version := apiVersionFromRequest(req); version != "" {
+		location = "/" + version + location
+	}
+	return location
+}
+
+// GetHandler reads the App Id from the request body, or from the "id" query parameter when the body is empty
 func (h *httpServerImpl) GetHandler(w http.ResponseWriter, req *http.Request) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		handleInternalError(w, err, "error reading request body")
+		handleReadBodyError(w, err)
 		return
 	}
 	id := string(body)
-	rawApp, err := h.store.Get(api.Id(id))
+	if id == "" {
+		id = req.URL.Query().Get("id")
+	}
+	h.writeApp(w, req, api.Id(id))
+}
+
+func (h *httpServerImpl) GetAppHandler(w http.ResponseWriter, req *http.Request) {
+	h.writeApp(w, req, api.Id(pathParam(req, "id")))
+}
+
+// writeApp responds with the stored App, converted to the apiVersion of the request url if any
+func (h *httpServerImpl) writeApp(w http.ResponseWriter, req *http.Request, id api.Id) {
+	rawApp, err := h.storeOf(req.Context()).Get(id)
 	if err != nil {
 		handleNotFoundError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(rawApp)
+	if !readable(h.authz, req.Context(), id, rawApp) {
+		handleForbiddenError(w, fmt.Errorf("app %s may not be read", id))
+		return
+	}
+	if version := apiVersionFromRequest(req); version != "" {
+		rawApp, err = convertDocument(rawApp, version)
+		if err != nil {
+			handleInternalError(w, err, fmt.Sprintf("failed to convert app %s to %s", id, version))
+			return
+		}
+	}
+	writeDocument(w, req, rawApp)
+}
+
+func (h *httpServerImpl) ReplaceHandler(w http.ResponseWriter, req *http.Request) {
+	id := api.Id(pathParam(req, "id"))
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		handleReadBodyError(w, err)
+		return
+	}
+	if err := checkContentType(req, body, mediaTypeJSON, mediaTypeYAML); err != nil {
+		handleContentTypeError(w, err)
+		return
+	}
+	h.updateApp(w, req, id, body)
+}
+
+func (h *httpServerImpl) PatchHandler(w http.ResponseWriter, req *http.Request) {
+	id := api.Id(pathParam(req, "id"))
+	patch, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		handleReadBodyError(w, err)
+		return
+	}
+	if err := checkContentType(req, patch, mediaTypeMergePatch, mediaTypeJSONPatch, mediaTypeJSON, mediaTypeYAML); err != nil {
+		handleContentTypeError(w, err)
+		return
+	}
+	rawApp, err := h.storeOf(req.Context()).Get(id)
+	if err != nil {
+		handleNotFoundError(w, err)
+		return
+	}
+	applyPatch := applyMergePatch
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == mediaTypeJSONPatch {
+		applyPatch = applyJSONPatch
+	}
+	patched, err := applyPatch(rawApp, patch)
+	if err != nil {
+		log.Warnf("invalid patch: %+v", err)
+		handleValidationError(w, err)
+		return
+	}
+	h.updateApp(w, req, id, patched)
+}
+
+// updateApp validates and stores a new version of an existing App
+func (h *httpServerImpl) updateApp(w http.ResponseWriter, req *http.Request, id api.Id, body []byte) {
+	app, warnings, ok := h.admit(w, req, body)
+	if !ok {
+		return
+	}
+	store := h.storeOf(req.Context())
+	if err := authorizeUpdate(h.authz, store, req.Context(), id, &app); err != nil {
+		handleForbiddenError(w, err)
+		return
+	}
+	err := h.audit.auditWrite(req.Context(), store, VerbUpdate, id, body, func() error {
+		return store.Update(id, &app, body)
+	})
+	if err != nil {
+		if errors.Is(err, cache.ErrNotFound) {
+			handleNotFoundError(w, err)
+			return
+		}
+		handleInternalError(w, err, fmt.Sprintf("failed to update %+v", app))
+		return
+	}
+	writeAppResponse(w, req, http.StatusOK, "App Updated", id, warnings)
+	log.Infof("Successfully updated app %s in the store", string(id))
+}
+
+func (h *httpServerImpl) DeleteHandler(w http.ResponseWriter, req *http.Request) {
+	id := api.Id(pathParam(req, "id"))
+	store := h.storeOf(req.Context())
+	if err := authorizeStored(h.authz, store, req.Context(), VerbDelete, id); err != nil {
+		handleForbiddenError(w, err)
+		return
+	}
+	err := h.audit.auditWrite(req.Context(), store, VerbDelete, id, nil, func() error {
+		return store.Delete(id)
+	})
+	if err != nil {
+		if errors.Is(err, cache.ErrNotFound) {
+			handleNotFoundError(w, err)
+			return
+		}
+		handleInternalError(w, err, fmt.Sprintf("failed to delete %s", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Infof("Successfully deleted app %s from the store", string(id))
 }
 
 func (h *httpServerImpl) SearchHandler(w http.ResponseWriter, req *http.Request) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		handleInternalError(w, err, "error reading request body")
+		handleReadBodyError(w, err)
 		return
 	}
-	app, err := h.validator.ValidateSearch(body)
+	if err := checkContentType(req, body, mediaTypeJSON, mediaTypeYAML); err != nil {
+		handleContentTypeError(w, err)
+		return
+	}
+	h.search(w, req, body)
+}
+
+func (h *httpServerImpl) ListHandler(w http.ResponseWriter, req *http.Request) {
+	query, err := searchDocFromQuery(req.URL.Query())
 	if err != nil {
-		log.Warnf("invalid input yaml: %+v", err)
+		log.Warnf("invalid search query: %+v", err)
 		handleValidationError(w, err)
 		return
 	}
-	rs, err := h.store.SearchStruct(&app)
+	h.search(w, req, query)
+}
+
+// search responds with the Ids of the Apps matching a search document
+func (h *httpServerImpl) search(w http.ResponseWriter, req *http.Request, query []byte) {
+	app, validationErr := h.validator.ValidateSearch(query)
+	if validationErr != nil {
+		log.Warnf("invalid input yaml: %+v", validationErr)
+		handleValidationError(w, validationErr)
+		return
+	}
+	if NamespaceFromContext(req.Context()) == allNamespaces {
+		if err := authorizeAllNamespaces(h.authz, req.Context()); err != nil {
+			handleForbiddenError(w, err)
+			return
+		}
+	}
+	rs, err := h.searchStore(req.Context(), &app)
 	if err != nil {
-		handleInternalError(w, err, fmt.Sprintf("failed to put %+v", app))
+		handleInternalError(w, err, fmt.Sprintf("failed to search %+v", app))
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	resp := make(map[string][]api.Id)
-	resp["result_list"] = rs
-	jsonResp, err := json.Marshal(resp)
+	items := make([]interface{}, 0, len(rs))
+	for _, id := range rs {
+		items = append(items, id)
+	}
+	writeList(w, req, "result_list", items)
+	log.Infof("Found matched result %+v", rs)
+}
+
+// ValidationReport is the response of a validate request or a dry-run put request
+type ValidationReport struct {
+	Valid    bool      `json:"valid"`
+	Errors   []string  `json:"errors,omitempty"`
+	Warnings []Warning `json:"warnings"`
+	// Normalized is the App as it would be indexed, in yaml
+	Normalized string `json:"normalized,omitempty"`
+}
+
+func (h *httpServerImpl) ValidateHandler(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		handleReadBodyError(w, err)
+		return
+	}
+	if err := checkContentType(req, body, mediaTypeJSON, mediaTypeYAML); err != nil {
+		handleContentTypeError(w, err)
+		return
+	}
+	h.writeValidationReport(w, req, body)
+}
+
+// writeValidationReport runs the full put validation on body and writes a ValidationReport,
+// with 200 if the App would be accepted and 400 otherwise
+func (h *httpServerImpl) writeValidationReport(w http.ResponseWriter, req *http.Request, body []byte) {
+	report := &ValidationReport{Valid: true}
+	app, warnings, validationErr := h.validator.ValidatePutWithOptions(body, putOptionsFromRequest(req))
+	report.Warnings = append(report.Warnings, warnings...)
+	if validationErr != nil {
+		report.Valid = false
+		report.Errors = append(report.Errors, validationErr.Error())
+	} else {
+		violations, policyWarnings := applyPolicies(h.policies, &app)
+		for _, v := range violations {
+			report.Valid = false
+			report.Errors = append(report.Errors, fmt.Sprintf("policy %s: %s", v.Policy, v.Message))
+		}
+		report.Warnings = append(report.Warnings, policyWarnings...)
+		report.Warnings = append(report.Warnings, h.validator.Lint(&app)...)
+		normalized, err := yaml.Marshal(&app)
+		if err != nil {
+			handleInternalError(w, err, "yaml marshal error")
+			return
+		}
+		report.Normalized = string(normalized)
+	}
+	if report.Warnings == nil {
+		report.Warnings = []Warning{}
+	}
+	if report.Valid {
+		writeNegotiated(w, req, http.StatusOK, report)
+	} else {
+		writeNegotiated(w, req, http.StatusBadRequest, report)
+	}
+}
+
+func (h *httpServerImpl) SchemaHandler(w http.ResponseWriter, req *http.Request) {
+	version := apiVersionFromRequest(req)
+	if version == "" {
+		version = req.URL.Query().Get("apiVersion")
+	}
+	if version == "" {
+		version = defaultAPIVersion
+	}
+	schema, ok := schemas[version]
+	if !ok {
+		handleNotFoundError(w, fmt.Errorf("unsupported apiVersion %q", version))
+		return
+	}
+	jsonResp, err := json.MarshalIndent(schema, "", "  ")
 	if err != nil {
 		log.Errorf("Error happened in JSON marshal error: %+v", err)
 		handleInternalError(w, err, "json marshal error")
 		return
 	}
+	w.Header().Set("Content-Type", mediaTypeSchemaJSON)
+	w.WriteHeader(http.StatusOK)
 	w.Write(jsonResp)
-	log.Infof("Found matched result %+v", rs)
+}
+
+// putOptionsFromRequest returns the PutOptions of a request: the apiVersion of a versioned url,
+// and DecodeLenient when the request asks for it with ?strict=false, DecodeStrict otherwise
+func putOptionsFromRequest(req *http.Request) PutOptions {
+	opts := PutOptions{
+		Mode:       DecodeStrict,
+		APIVersion: apiVersionFromRequest(req),
+	}
+	if strict, err := strconv.ParseBool(req.URL.Query().Get("strict")); err == nil && !strict {
+		opts.Mode = DecodeLenient
+	}
+	return opts
+}
+
+// routes returns the route table of the API server, served by Handler and documented by OpenAPIHandler
+func (h *httpServerImpl) routes() []*route {
+	routes := make([]*route, 0)
+	add := func(method, pattern string, handler http.HandlerFunc) {
+		routes = append(routes, &route{method: method, pattern: pattern, handler: handler})
+	}
+	prefixes := []string{""}
+	for version := range scheme {
+		prefixes = append(prefixes, "/"+version)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		add(http.MethodPost, prefix+"/apps", h.CreateHandler)
+		add(http.MethodGet, prefix+"/apps", h.ListHandler)
+		add(http.MethodGet, prefix+"/apps/{id}", h.GetAppHandler)
+		add(http.MethodPut, prefix+"/apps/{id}", h.ReplaceHandler)
+		add(http.MethodPatch, prefix+"/apps/{id}", h.PatchHandler)
+		add(http.MethodDelete, prefix+"/apps/{id}", h.DeleteHandler)
+		add(http.MethodPost, prefix+"/validate", h.ValidateHandler)
+		add(http.MethodGet, prefix+"/schema", h.SchemaHandler)
+		add(http.MethodPost, prefix+"/import", h.ImportHandler)
+		add(http.MethodGet, prefix+"/export", h.ExportHandler)
+		add(http.MethodPost, prefix+"/batch", h.BatchHandler)
+		add(http.MethodGet, prefix+"/watch", h.WatchHandler)
+		// legacy endpoints, kept as aliases accepting any method
+		add(anyMethod, prefix+"/put", h.PutHandler)
+		add(anyMethod, prefix+"/get", h.GetHandler)
+	}
+	add(anyMethod, "/query", h.SearchHandler)
+	add(http.MethodGet, "/graphql", h.GraphQLHandler)
+	add(http.MethodPost, "/graphql", h.GraphQLHandler)
+	add(http.MethodGet, "/openapi.json", h.OpenAPIHandler)
+	add(http.MethodGet, "/docs", h.SwaggerUIHandler)
+	add(http.MethodGet, "/docs/{asset}", h.SwaggerUIAssetHandler)
+	add(http.MethodGet, "/audit", h.AuditHandler)
+	return routes
+}
+
+func (h *httpServerImpl) Handler() http.Handler {
+	r := newRouter()
+	for _, rt := range h.routes() {
+		r.handle(rt.method, rt.pattern, rt.handler)
+	}
+	handler := namespaced(r)
+	if h.maxBodySize > 0 {
+		handler = limitBody(h.maxBodySize, handler)
+	}
+	if h.limiter != nil {
+		// inside authenticate, to limit the principals rather than their addresses
+		handler = rateLimit(h.limiter, handler)
+	}
+	if h.authn != nil {
+		// the failed authentications are limited by address
+		handler = authenticate(h.authn, h.limiter, handler)
+	}
+	if h.metrics != nil {
+		handler = h.metrics.instrument(r, handler)
+	}
+	return handler
 }
@@ -4,6 +4,8 @@ import (
 	"application_metadata_api_server/cache/mocks"
 	"application_metadata_api_server/server/api"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -11,8 +13,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sigs.k8s.io/yaml"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHttpServerImpl_PutHandler(t *testing.T) {
@@ -139,3 +143,440 @@ func TestHttpServerImpl_SearchHandler(t *testing.T) {
 	}
 
 }
+
+func TestHttpServerImpl_SchemaHandler(t *testing.T) {
+	fakeServer := &httpServerImpl{
+		store:     &mocks.Store{},
+		validator: newAppValidator(),
+	}
+
+	req := httptest.NewRequest("GET", "/schema", nil)
+	w := httptest.NewRecorder()
+	fakeServer.SchemaHandler(w, req)
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/schema+json", resp.Header.Get("Content-Type"))
+	schema := &JSONSchema{}
+	assert.Nil(t, json.Unmarshal(body, schema))
+	assert.Equal(t, schemas[defaultAPIVersion].Required, schema.Required)
+}
+
+func TestHttpServerImpl_PutHandler_DecodeMode(t *testing.T) {
+	mockStore := &mocks.Store{}
+	fakeServer := &httpServerImpl{
+		store:     mockStore,
+		validator: newAppValidator(),
+	}
+	mockStore.On("Add", mock.Anything, mock.Anything).Return(api.Id("1"), nil)
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	data = append([]byte("licence: Apache-2.0\n"), data...)
+
+	req := httptest.NewRequest("POST", "/put", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	fakeServer.PutHandler(w, req)
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), `line 1, column 1: unknown field \"licence\"`)
+
+	req = httptest.NewRequest("POST", "/put?strict=false", bytes.NewReader(data))
+	w = httptest.NewRecorder()
+	fakeServer.PutHandler(w, req)
+	resp = w.Result()
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"warnings":[{"line":1,"column":1,"field":"licence","message":"unknown field \"licence\""}]`)
+}
+
+func TestHttpServerImpl_ValidateHandler(t *testing.T) {
+	mockStore := &mocks.Store{}
+	fakeServer := &httpServerImpl{
+		store:     mockStore,
+		validator: newAppValidator(),
+	}
+
+	testCases := []struct {
+		name                 string
+		filePath             string
+		url                  string
+		expectedResponseCode int
+		expectedValid        bool
+	}{
+		{
+			name:                 "expect 400 and errors on invalid input",
+			filePath:             "../testdata/invalid-payload1.yaml",
+			url:                  "/validate",
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			name:                 "expect 200 and normalized document on valid input",
+			filePath:             "../testdata/valid-payload1.yaml",
+			url:                  "/validate",
+			expectedResponseCode: http.StatusOK,
+			expectedValid:        true,
+		},
+		{
+			name:                 "expect dry-run put to validate without storing",
+			filePath:             "../testdata/valid-payload2.yaml",
+			url:                  "/put?dryRun=true",
+			expectedResponseCode: http.StatusOK,
+			expectedValid:        true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(test.filePath)
+			assert.Nil(t, err)
+			req := httptest.NewRequest("POST", test.url, bytes.NewReader(data))
+			w := httptest.NewRecorder()
+			if strings.HasPrefix(test.url, "/put") {
+				fakeServer.PutHandler(w, req)
+			} else {
+				fakeServer.ValidateHandler(w, req)
+			}
+
+			resp := w.Result()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, test.expectedResponseCode, resp.StatusCode)
+			report := &ValidationReport{}
+			assert.Nil(t, json.Unmarshal(body, report))
+			assert.Equal(t, test.expectedValid, report.Valid)
+			if test.expectedValid {
+				assert.Empty(t, report.Errors)
+				assert.Contains(t, report.Normalized, "title:")
+			} else {
+				assert.NotEmpty(t, report.Errors)
+			}
+			t.Log(string(body))
+		})
+	}
+	mockStore.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+}
+
+func TestHttpServerImpl_PutHandler_Policies(t *testing.T) {
+	mockStore := &mocks.Store{}
+	engine, err := LoadPolicyEngine("../testdata/policies.yaml")
+	assert.Nil(t, err)
+	fakeServer := &httpServerImpl{
+		store:     mockStore,
+		validator: newAppValidator(),
+		policies:  engine,
+	}
+	mockStore.On("Add", mock.Anything, mock.Anything).Return(api.Id("1"), nil)
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest("POST", "/put", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	fakeServer.PutHandler(w, req)
+	resp := w.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	data = bytes.Replace(data, []byte("license: Apache-2.0"), []byte("license: GPL-3.0"), 1)
+	req = httptest.NewRequest("POST", "/put", bytes.NewReader(data))
+	w = httptest.NewRecorder()
+	fakeServer.PutHandler(w, req)
+	resp = w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errResp := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(body, &errResp))
+	assert.Equal(t, policyViolationMsg, errResp[httpErrReasonKey])
+	assert.Len(t, errResp[httpErrPolicyKey], 1)
+	mockStore.AssertNumberOfCalls(t, "Add", 1)
+}
+
+func TestHttpServerImpl_GetHandler_Versioned(t *testing.T) {
+	mockStore := &mocks.Store{}
+	fakeServer := &httpServerImpl{
+		store:     mockStore,
+		validator: newAppValidator(),
+	}
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	mockStore.On("Get", api.Id("1")).Return(data, nil)
+
+	req := httptest.NewRequest("GET", "/v2/get", strings.NewReader("1"))
+	w := httptest.NewRecorder()
+	fakeServer.GetHandler(w, req)
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "apiVersion: v2")
+	assert.Contains(t, string(body), "releases:")
+}
+
+func TestHttpServerImpl_Handler(t *testing.T) {
+	handler := NewHttpServer().Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+
+	do := func(method, target string, body []byte) (*http.Response, string) {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp, string(respBody)
+	}
+
+	resp, body := do("POST", "/apps", data)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/apps/1", resp.Header.Get("Location"))
+	assert.JSONEq(t, `{"id":"1","message":"App Created"}`, body)
+
+	// v1 documents are not valid v2 documents
+	resp, _ = do("POST", "/v2/apps", data)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	dataV2, err := ioutil.ReadFile("../testdata/valid-payload3-v2.yaml")
+	assert.Nil(t, err)
+	resp, _ = do("POST", "/v2/apps", dataV2)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/v2/apps/2", resp.Header.Get("Location"))
+
+	resp, body = do("GET", "/apps/1", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, string(data), body)
+
+	resp, body = do("GET", "/apps?title=valid", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"result_list":["1","2"]}`, body)
+	resp, body = do("GET", "/apps?title=valid&label=k=xyz", nil)
+	assert.JSONEq(t, `{"result_list":["1"]}`, body)
+	resp, _ = do("GET", "/apps?licence=MIT", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = do("PUT", "/apps/2", bytes.Replace(data, []byte("Valid App 1"), []byte("Replaced App"), 1))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, body = do("GET", "/apps?title=replaced", nil)
+	assert.JSONEq(t, `{"result_list":["2"]}`, body)
+
+	resp, _ = do("PATCH", "/apps/1", []byte(`{"labels":{"team":"payments"}}`))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, body = do("GET", "/apps?labels.team=payments", nil)
+	assert.JSONEq(t, `{"result_list":["1"]}`, body)
+
+	resp, _ = do("PATCH", "/apps/1", []byte(`{"version":null}`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req := httptest.NewRequest("PATCH", "/apps/1", strings.NewReader(`[{"op":"test","path":"/labels/team","value":"payments"},{"op":"replace","path":"/labels/team","value":"billing"}]`))
+	req.Header.Set("Content-Type", mediaTypeJSONPatch)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	resp, body = do("GET", "/apps?labels.team=billing", nil)
+	assert.JSONEq(t, `{"result_list":["1"]}`, body)
+	resp, body = do("GET", "/apps?labels.team=payments", nil)
+	assert.JSONEq(t, `{"result_list":[]}`, body)
+
+	resp, _ = do("DELETE", "/apps/1", nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do("GET", "/apps/1", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = do("DELETE", "/apps/1", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = do("PUT", "/apps/1", data)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = do("POST", "/apps/2", data)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "DELETE, GET, PATCH, PUT", resp.Header.Get("Allow"))
+
+	// legacy endpoints
+	resp, body = do("POST", "/put", data)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"id":"3","message":"App Created"}`, body)
+	resp, body = do("GET", "/get?id=3", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, string(data), body)
+	resp, body = do("POST", "/query", []byte("title: valid"))
+	assert.JSONEq(t, `{"result_list":["3"]}`, body)
+}
+
+func TestHttpServerImpl_Handler_ContentNegotiation(t *testing.T) {
+	handler := NewHttpServer().Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	jsonData, err := yaml.YAMLToJSON(data)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest("POST", "/apps", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/yaml")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Result().StatusCode)
+	assert.Equal(t, "application/yaml", w.Result().Header.Get("Content-Type"))
+	assert.Equal(t, "id: \"1\"\nmessage: App Created\n", w.Body.String())
+
+	// the original json is returned as is
+	req = httptest.NewRequest("GET", "/apps/1", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "application/json", w.Result().Header.Get("Content-Type"))
+	assert.Equal(t, string(jsonData), w.Body.String())
+
+	req = httptest.NewRequest("GET", "/apps/1", nil)
+	req.Header.Set("Accept", "application/yaml")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "application/yaml", w.Result().Header.Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "title: Valid App 1\n")
+
+	req = httptest.NewRequest("POST", "/apps", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/xml")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Result().StatusCode)
+}
+
+func TestHttpServerImpl_ImportExport(t *testing.T) {
+	handler := NewHttpServer().Handler()
+	data1, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	data2, err := ioutil.ReadFile("../testdata/valid-payload2.yaml")
+	assert.Nil(t, err)
+	invalid, err := ioutil.ReadFile("../testdata/invalid-payload1.yaml")
+	assert.Nil(t, err)
+
+	do := func(method, target, contentType, accept string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	stream := bytes.Join([][]byte{data1, invalid, []byte("# only a comment\n"), data2}, []byte("\n---\n"))
+	w := do("POST", "/import", "application/yaml", "", stream)
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := ImportResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, 1, resp.Failed)
+	assert.Equal(t, 3, len(resp.Results))
+	assert.Equal(t, api.Id("1"), resp.Results[0].Id)
+	assert.Equal(t, api.Id(""), resp.Results[1].Id)
+	assert.NotEmpty(t, resp.Results[1].Error)
+	assert.Equal(t, api.Id("2"), resp.Results[2].Id)
+
+	w = do("GET", "/export", "", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Equal(t, 2, len(bytes.Split(w.Body.Bytes(), []byte("---\n"))))
+
+	// an ndjson export imports back as is
+	w = do("GET", "/export", "", "application/x-ndjson", nil)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	exported := w.Body.Bytes()
+	assert.Equal(t, 2, bytes.Count(exported, []byte("\n")))
+	w = do("POST", "/import", "application/x-ndjson", "", exported)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, api.Id("4"), resp.Results[1].Id)
+	w = do("GET", "/apps?title=valid", "", "", nil)
+	assert.JSONEq(t, `{"result_list":["1","2","3","4"]}`, w.Body.String())
+
+	w = do("GET", "/v2/export", "", "", nil)
+	assert.Contains(t, w.Body.String(), "apiVersion: v2")
+
+	w = do("POST", "/import", "application/xml", "", stream)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	w = do("POST", "/import", "application/x-ndjson", "", []byte("{\n"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Failed)
+	w = do("GET", "/export", "", "application/json", nil)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestHttpServerImpl_BatchHandler(t *testing.T) {
+	handler := NewHttpServer().Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	doc, err := yaml.YAMLToJSON(data)
+	assert.Nil(t, err)
+	invalid, err := ioutil.ReadFile("../testdata/invalid-payload1.yaml")
+	assert.Nil(t, err)
+	invalidDoc, err := yaml.YAMLToJSON(invalid)
+	assert.Nil(t, err)
+
+	do := func(body string) (*httptest.ResponseRecorder, BatchResponse) {
+		req := httptest.NewRequest("POST", "/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		resp := BatchResponse{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+	search := func(query string) string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/apps?"+query, nil))
+		return w.Body.String()
+	}
+
+	w, resp := do(fmt.Sprintf(`{"operations":[{"op":"create","document":%s},{"op":"create","document":%s}]}`, doc, doc))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, resp.Committed)
+	assert.Equal(t, api.Id("1"), resp.Results[0].Id)
+	assert.Equal(t, api.Id("2"), resp.Results[1].Id)
+
+	// one invalid document rejects the whole batch
+	w, resp = do(fmt.Sprintf(`{"operations":[{"op":"delete","id":"1"},{"op":"create","document":%s}]}`, invalidDoc))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.False(t, resp.Committed)
+	assert.Empty(t, resp.Results[0].Error)
+	assert.NotEmpty(t, resp.Results[1].Error)
+	assert.JSONEq(t, `{"result_list":["1","2"]}`, search("title=valid"))
+
+	// an unknown id rejects the whole batch
+	w, _ = do(`{"operations":[{"op":"delete","id":"1"},{"op":"delete","id":"9"}]}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"result_list":["1","2"]}`, search("title=valid"))
+
+	updated := strings.Replace(string(doc), "Valid App 1", "Updated App", 1)
+	w, resp = do(fmt.Sprintf(`{"operations":[{"op":"delete","id":"1"},{"op":"update","id":"2","document":%s},{"op":"create","document":%s}]}`, updated, doc))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, resp.Committed)
+	assert.Equal(t, api.Id("3"), resp.Results[2].Id)
+	assert.JSONEq(t, `{"result_list":["2"]}`, search("title=updated"))
+	assert.JSONEq(t, `{"result_list":["3"]}`, search("title=valid"))
+
+	w, _ = do(`{"operations":[{"op":"merge","id":"1"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = do(`{"operations":[]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = do(`{"ops":[{"op":"delete","id":"1"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHttpServerImpl_WatchHandler_Shutdown(t *testing.T) {
+	shutdown := make(chan struct{})
+	srv := httptest.NewServer(NewHttpServer(WithShutdown(shutdown)).Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/watch")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the stream ends with an ERROR event, so draining the server does not wait for the client
+	close(shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, srv.Config.Shutdown(ctx))
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"type": "ERROR", "error": "server is shutting down, watch again"}`, string(body))
+}
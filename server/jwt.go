@@ -0,0 +1,293 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is the clock skew tolerated on the exp and nbf claims
+const jwtLeeway = 30 * time.Second
+
+// JWTConfig configures the verification of bearer JWTs
+type JWTConfig struct {
+	// JWKSFile is the path of the JSON Web Key Set holding the public keys of the issuer
+	JWKSFile string `json:"jwksFile"`
+	// Issuer is the expected iss claim, any issuer is accepted when empty
+	Issuer string `json:"issuer,omitempty"`
+	// Audience must be in the aud claim, any audience is accepted when empty
+	Audience string `json:"audience,omitempty"`
+	// GroupsClaim is the claim holding the groups of the principal, "groups" by default
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+}
+
+// jwk is a JSON Web Key, RSA or EC
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// jwtAlgorithm is a supported signing algorithm, with the hash it signs
+type jwtAlgorithm struct {
+	hash crypto.Hash
+	// kty is the key type of the algorithm, RSA or EC
+	kty string
+}
+
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"RS256": {crypto.SHA256, "RSA"},
+	"RS384": {crypto.SHA384, "RSA"},
+	"RS512": {crypto.SHA512, "RSA"},
+	"ES256": {crypto.SHA256, "EC"},
+	"ES384": {crypto.SHA384, "EC"},
+	"ES512": {crypto.SHA512, "EC"},
+}
+
+var jwkCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// jwtVerifier verifies JWTs signed by the keys of a JWKS file
+type jwtVerifier struct {
+	config JWTConfig
+	// keys are the public keys by kid, tokens without kid are accepted when there is a single key
+	keys map[string]crypto.PublicKey
+	now  func() time.Time
+}
+
+func newJWTVerifier(config JWTConfig) (*jwtVerifier, error) {
+	if config.JWKSFile == "" {
+		return nil, fmt.Errorf("jwksFile is required")
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	data, err := ioutil.ReadFile(config.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks file %s: %w", config.JWKSFile, err)
+	}
+	v := &jwtVerifier{config: config, keys: make(map[string]crypto.PublicKey), now: time.Now}
+	for i, key := range set.Keys {
+		pub, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %w", i, err)
+		}
+		v.keys[key.Kid] = pub
+	}
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("no key in jwks file %s", config.JWKSFile)
+	}
+	return v, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid e: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid e")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := jwkCurves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported crv %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// verify checks the signature and the claims of a compact JWT, and returns its principal
+func (v *jwtVerifier) verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+	header := &jwtHeader{}
+	if err := decodeSegment(parts[0], header); err != nil {
+		return nil, fmt.Errorf("malformed jwt header: %w", err)
+	}
+	alg, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported jwt alg %q", header.Alg)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt signature: %w", err)
+	}
+	h := alg.hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(alg, key, h.Sum(nil), signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed jwt claims: %w", err)
+	}
+	return v.checkClaims(claims)
+}
+
+func (v *jwtVerifier) key(kid string) (crypto.PublicKey, error) {
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown jwt kid %q", kid)
+}
+
+func verifySignature(alg jwtAlgorithm, key crypto.PublicKey, digest, signature []byte) error {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg.kty != "RSA" {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(pub, alg.hash, digest, signature); err != nil {
+			return errors.New("invalid jwt signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if alg.kty != "EC" {
+			break
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid jwt signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid jwt signature")
+		}
+		return nil
+	}
+	return errors.New("jwt alg does not match the key type")
+}
+
+func (v *jwtVerifier) checkClaims(claims map[string]interface{}) (*Principal, error) {
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("jwt has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("jwt is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("jwt is not valid yet")
+	}
+	if v.config.Issuer != "" && claims["iss"] != v.config.Issuer {
+		return nil, fmt.Errorf("jwt issuer %v is not %s", claims["iss"], v.config.Issuer)
+	}
+	if v.config.Audience != "" && !containsString(stringList(claims["aud"]), v.config.Audience) {
+		return nil, fmt.Errorf("jwt audience is not %s", v.config.Audience)
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("jwt has no sub claim")
+	}
+	email, _ := claims["email"].(string)
+	return &Principal{Name: sub, Method: AuthMethodJWT, Email: email, Groups: stringList(claims[v.config.GroupsClaim])}, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// stringList returns a claim holding a string or a list of strings as a list
+func stringList(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		rs := make([]string, 0, len(c))
+		for _, item := range c {
+			if s, ok := item.(string); ok {
+				rs = append(rs, s)
+			}
+		}
+		return rs
+	}
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeJWKS writes the public keys by kid as a JWKS file, and returns its path
+func writeJWKS(t *testing.T, keys map[string]crypto.Signer) string {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for kid, key := range keys {
+		switch pub := key.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "RSA", Kid: kid,
+				N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "EC", Kid: kid, Crv: pub.Curve.Params().Name,
+				X: base64.RawURLEncoding.EncodeToString(pub.X.Bytes()),
+				Y: base64.RawURLEncoding.EncodeToString(pub.Y.Bytes())})
+		}
+	}
+	data, err := json.Marshal(set)
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(path, data, 0600))
+	return path
+}
+
+// signJWT returns a compact JWT of claims signed by key with alg
+func signJWT(t *testing.T, alg string, key crypto.Signer, kid string, claims map[string]interface{}) string {
+	header, err := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	assert.Nil(t, err)
+	payload, err := json.Marshal(claims)
+	assert.Nil(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	h := jwtAlgorithms[alg].hash.New()
+	h.Write([]byte(signed))
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, jwtAlgorithms[alg].hash, h.Sum(nil))
+		assert.Nil(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, h.Sum(nil))
+		assert.Nil(t, err)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	v, err := newJWTVerifier(JWTConfig{
+		JWKSFile: writeJWKS(t, map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey}),
+		Issuer:   "https://issuer.example.com",
+		Audience: "apps",
+	})
+	assert.Nil(t, err)
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		rs := map[string]interface{}{
+			"sub":    "alice",
+			"iss":    "https://issuer.example.com",
+			"aud":    []string{"other", "apps"},
+			"exp":    now.Add(time.Hour).Unix(),
+			"groups": []string{"writers"},
+			"email":  "alice@random.com",
+		}
+		for k, val := range overrides {
+			if val == nil {
+				delete(rs, k)
+				continue
+			}
+			rs[k] = val
+		}
+		return rs
+	}
+
+	p, err := v.verify(signJWT(t, "RS256", rsaKey, "rsa", claims(nil)))
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{Name: "alice", Method: AuthMethodJWT, Email: "alice@random.com", Groups: []string{"writers"}}, p)
+
+	p, err = v.verify(signJWT(t, "ES256", ecKey, "ec", claims(map[string]interface{}{"aud": "apps", "groups": "readers"})))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"readers"}, p.Groups)
+
+	for name, tc := range map[string]struct {
+		token string
+		err   string
+	}{
+		"expired":        {signJWT(t, "RS256", rsaKey, "rsa", claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})), "jwt is expired"},
+		"no exp":         {signJWT(t, "RS256", rsaKey, "rsa", claims(map[string]interface{}{"exp": nil})), "jwt has no exp claim"},
+		"not yet valid":  {signJWT(t, "RS256", rsaKey, "rsa", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), "jwt is not valid yet"},
+		"other issuer":   {signJWT(t, "RS256", rsaKey, "rsa", claims(map[string]interface{}{"iss": "https://evil.com"})), "jwt issuer https://evil.com is not https://issuer.example.com"},
+		"other audience": {signJWT(t, "RS256", rsaKey, "rsa", claims(map[string]interface{}{"aud": "others"})), "jwt audience is not apps"},
+		"no sub":         {signJWT(t, "RS256", rsaKey, "rsa", claims(map[string]interface{}{"sub": nil})), "jwt has no sub claim"},
+		"other key":      {signJWT(t, "RS256", otherKey, "rsa", claims(nil)), "invalid jwt signature"},
+		"unknown kid":    {signJWT(t, "RS256", rsaKey, "unknown", claims(nil)), `unknown jwt kid "unknown"`},
+		"alg mismatch":   {signJWT(t, "ES256", rsaKey, "rsa", claims(nil)), "jwt alg does not match the key type"},
+		"malformed":      {"abc.def", "malformed jwt"},
+	} {
+		_, err := v.verify(tc.token)
+		assert.EqualError(t, err, tc.err, name)
+	}
+
+	// alg none is never accepted
+	parts := strings.Split(signJWT(t, "RS256", rsaKey, "rsa", claims(nil)), ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa"}`))
+	_, err = v.verify(none + "." + parts[1] + ".")
+	assert.EqualError(t, err, `unsupported jwt alg "none"`)
+
+	_, err = newJWTVerifier(JWTConfig{})
+	assert.EqualError(t, err, "jwksFile is required")
+}
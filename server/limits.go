@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// requestTooLargeError is returned reading a request body longer than the max body size
+type requestTooLargeError struct {
+	limit int64
+}
+
+func (e *requestTooLargeError) Error() string {
+	return fmt.Sprintf("request body is larger than %d bytes", e.limit)
+}
+
+// limitedBody is a request body read through http.MaxBytesReader, failing with a requestTooLargeError past its limit
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && !errors.Is(err, io.EOF) && b.read >= b.limit {
+		return n, &requestTooLargeError{limit: b.limit}
+	}
+	return n, err
+}
+
+// limitBody answers 413 to the requests declaring a body longer than limit bytes,
+// and fails the reads of the bodies going past it, so no handler buffers more than limit bytes
+func limitBody(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ContentLength > limit {
+			handleRequestTooLargeError(w, &requestTooLargeError{limit: limit})
+			return
+		}
+		req.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, req.Body, limit), limit: limit}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// isRequestTooLarge tells if err comes from reading a request body longer than the max body size
+func isRequestTooLarge(err error) bool {
+	var tooLarge *requestTooLargeError
+	return errors.As(err, &tooLarge)
+}
+
+// handleReadBodyError answers a failed read of the request body, 413 when the body is too large
+func handleReadBodyError(w http.ResponseWriter, err error) {
+	if isRequestTooLarge(err) {
+		handleRequestTooLargeError(w, err)
+		return
+	}
+	handleInternalError(w, err, "error reading request body")
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// billionLaughs is a yaml document of a few hundred bytes expanding into 10^9 strings
+const billionLaughs = `a: &a ["lol","lol","lol","lol","lol","lol","lol","lol","lol"]
+b: &b [*a,*a,*a,*a,*a,*a,*a,*a,*a]
+c: &c [*b,*b,*b,*b,*b,*b,*b,*b,*b]
+d: &d [*c,*c,*c,*c,*c,*c,*c,*c,*c]
+e: &e [*d,*d,*d,*d,*d,*d,*d,*d,*d]
+f: &f [*e,*e,*e,*e,*e,*e,*e,*e,*e]
+g: &g [*f,*f,*f,*f,*f,*f,*f,*f,*f]
+h: &h [*g,*g,*g,*g,*g,*g,*g,*g,*g]
+title: &i [*h,*h,*h,*h,*h,*h,*h,*h,*h]
+`
+
+func nestedDocument(depth int) string {
+	return strings.Repeat("[", depth) + strings.Repeat("]", depth)
+}
+
+func TestParseDocument(t *testing.T) {
+	for _, tc := range []struct {
+		doc      string
+		expected string
+	}{
+		{"title: a\nlabels: {env: prod}", ""},
+		{"a: &a {b: c}\nd: [*a, *a]", ""},
+		{nestedDocument(maxDocumentDepth), ""},
+		{nestedDocument(maxDocumentDepth + 1), fmt.Sprintf("document is nested deeper than %d levels", maxDocumentDepth)},
+		{"a: &a " + nestedDocument(maxDocumentDepth/2) + "\nb: [[[[" + strings.Repeat("[", maxDocumentDepth/2) + "*a" + strings.Repeat("]", maxDocumentDepth/2) + "]]]]",
+			fmt.Sprintf("document is nested deeper than %d levels", maxDocumentDepth)},
+		{billionLaughs, fmt.Sprintf("document expands into more than %d nodes", maxDocumentNodes)},
+		{"[" + strings.Repeat("1,", maxDocumentNodes) + "1]", fmt.Sprintf("document expands into more than %d nodes", maxDocumentNodes)},
+	} {
+		_, err := parseDocument([]byte(tc.doc))
+		if tc.expected == "" {
+			assert.Nil(t, err)
+			continue
+		}
+		assert.EqualError(t, err, tc.expected)
+	}
+}
+
+func TestHttpServerImpl_Handler_MaxBodySize(t *testing.T) {
+	handler := NewHttpServer(WithMaxBodySize(1024)).Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	large := string(data) + "\n# " + strings.Repeat("x", 1024)
+	do := func(method, path, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/apps", "", strings.NewReader(string(data))).Code)
+	// declared by the Content-Length
+	rr := do(http.MethodPost, "/apps", "", strings.NewReader(large))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.JSONEq(t, `{"error_reason": "request entity too large", "error_message": "request body is larger than 1024 bytes"}`, rr.Body.String())
+	// or found reading the body of unknown length
+	for _, tc := range []struct {
+		method, path, contentType string
+	}{
+		{http.MethodPost, "/apps", ""},
+		{http.MethodPut, "/apps/1", ""},
+		{http.MethodPatch, "/apps/1", mediaTypeMergePatch},
+		{http.MethodPost, "/query", ""},
+		{http.MethodPost, "/validate", ""},
+		{http.MethodPost, "/batch", ""},
+		{http.MethodPost, "/import", mediaTypeYAML},
+		{http.MethodPost, "/import", mediaTypeNDJSON},
+		{http.MethodPost, "/graphql", mediaTypeJSON},
+	} {
+		rr := do(tc.method, tc.path, tc.contentType, io.MultiReader(strings.NewReader(large)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "%s %s: %s", tc.method, tc.path, rr.Body.String())
+	}
+}
+
+func TestHttpServerImpl_Handler_DocumentLimits(t *testing.T) {
+	handler := NewHttpServer().Handler()
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/apps", "", string(data)).Code)
+
+	for _, tc := range []struct {
+		method, path, contentType string
+	}{
+		{http.MethodPost, "/apps", ""},
+		{http.MethodPut, "/apps/1", ""},
+		{http.MethodPatch, "/apps/1", mediaTypeYAML},
+		{http.MethodPost, "/query", ""},
+		{http.MethodPost, "/validate", ""},
+		{http.MethodPost, "/batch", ""},
+		{http.MethodPost, "/import", mediaTypeYAML},
+	} {
+		for _, doc := range []string{billionLaughs, nestedDocument(maxDocumentDepth + 1)} {
+			rr := do(tc.method, tc.path, tc.contentType, doc)
+			assert.Contains(t, rr.Body.String(), "document ", "%s %s", tc.method, tc.path)
+			if tc.path == "/import" {
+				// the import reports the document as failed
+				assert.Equal(t, http.StatusOK, rr.Code)
+				continue
+			}
+			assert.Equal(t, http.StatusBadRequest, rr.Code, "%s %s: %s", tc.method, tc.path, rr.Body.String())
+		}
+	}
+}
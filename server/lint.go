@@ -0,0 +1,70 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxDescriptionBytes is the description size above which a warning is reported, unless
+// WithMaxDescriptionSize sets another one
+const DefaultMaxDescriptionBytes = 16 * 1024
+
+// knownLicenses is a list of common SPDX license identifiers, anything else is worth a second look
+var knownLicenses = map[string]bool{
+	"0bsd":              true,
+	"agpl-3.0":          true,
+	"agpl-3.0-only":     true,
+	"agpl-3.0-or-later": true,
+	"apache-2.0":        true,
+	"bsd-2-clause":      true,
+	"bsd-3-clause":      true,
+	"bsl-1.0":           true,
+	"cc0-1.0":           true,
+	"epl-2.0":           true,
+	"gpl-2.0":           true,
+	"gpl-2.0-only":      true,
+	"gpl-2.0-or-later":  true,
+	"gpl-3.0":           true,
+	"gpl-3.0-only":      true,
+	"gpl-3.0-or-later":  true,
+	"isc":               true,
+	"lgpl-2.1":          true,
+	"lgpl-2.1-only":     true,
+	"lgpl-3.0":          true,
+	"lgpl-3.0-only":     true,
+	"mit":               true,
+	"mpl-2.0":           true,
+	"unlicense":         true,
+	"proprietary":       true,
+}
+
+// withMaxDescriptionBytes sets the description size above which Lint reports a warning
+func withMaxDescriptionBytes(n int) validatorOption {
+	return func(v *appValidator) {
+		v.maxDescriptionBytes = n
+	}
+}
+
+func (v *appValidator) Lint(app *api.App) []Warning {
+	rs := make([]Warning, 0)
+	if len(strings.TrimSpace(app.Description)) == 0 {
+		rs = append(rs, Warning{
+			Field:   "description",
+			Message: "description is empty",
+		})
+	}
+	if n := len(app.Description); v.maxDescriptionBytes > 0 && n > v.maxDescriptionBytes {
+		rs = append(rs, Warning{
+			Field:   "description",
+			Message: fmt.Sprintf("description is %d bytes, larger than %d bytes", n, v.maxDescriptionBytes),
+		})
+	}
+	if app.License != "" && !knownLicenses[strings.ToLower(strings.TrimSpace(app.License))] {
+		rs = append(rs, Warning{
+			Field:   "license",
+			Message: fmt.Sprintf("license %q is not a known SPDX license identifier", app.License),
+		})
+	}
+	return rs
+}
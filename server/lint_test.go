@@ -0,0 +1,45 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestAppValidator_Lint(t *testing.T) {
+	validator := newAppValidator(withMaxDescriptionBytes(10))
+
+	testCases := []struct {
+		name           string
+		app            api.App
+		expectedFields []string
+	}{
+		{
+			name:           "known license and short description, no warning",
+			app:            api.App{License: "Apache-2.0", Description: "short"},
+			expectedFields: []string{},
+		},
+		{
+			name:           "missing description",
+			app:            api.App{License: "MIT", Description: "  "},
+			expectedFields: []string{"description"},
+		},
+		{
+			name:           "description too large and unknown license",
+			app:            api.App{License: "do what you want", Description: strings.Repeat("#", 11)},
+			expectedFields: []string{"description", "license"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			warnings := validator.Lint(&test.app)
+			fields := make([]string, 0)
+			for _, w := range warnings {
+				fields = append(fields, w.Field)
+			}
+			assert.Equal(t, test.expectedFields, fields)
+		})
+	}
+}
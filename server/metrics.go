@@ -0,0 +1,212 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "appserver"
+
+// ruleOther is the rule label of the validation failures of no known rule
+const ruleOther = "other"
+
+// Metrics are the Prometheus metrics of the servers: the http requests by route and status, the Apps, terms and
+// lock waits of the Stores of every namespace, and the validation failures by rule. They are served by Handler.
+type Metrics struct {
+	registry           *prometheus.Registry
+	requests           *prometheus.CounterVec
+	durations          *prometheus.HistogramVec
+	validationFailures *prometheus.CounterVec
+}
+
+// NewMetrics returns the metrics of servers serving namespaces, with the metrics of the go runtime and the process
+func NewMetrics(namespaces cache.Namespaces) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of http requests, by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of the http requests until their response is written, by method, route and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "code"}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "validation_failures_total",
+			Help:      "Number of Apps failing validation, by broken rule.",
+		}, []string{"rule"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.durations,
+		m.validationFailures,
+		newStoreCollector(namespaces),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument counts and times the requests served by next, labelled by the pattern of their route in r.
+// It must wrap the whole chain, so the requests rejected before routing are counted too.
+func (m *Metrics) instrument(r *router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		route := r.patternOf(req)
+		if route == "" {
+			// unknown paths would make a series each
+			route = "unmatched"
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		labels := []string{methodLabel(req.Method), route, strconv.Itoa(recorder.status)}
+		m.requests.WithLabelValues(labels...).Inc()
+		m.durations.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+// validator counts the validation failures of v, it returns v when m is nil
+func (m *Metrics) validator(v Validator) Validator {
+	if m == nil {
+		return v
+	}
+	return &instrumentedValidator{Validator: v, failures: m.validationFailures}
+}
+
+// methodLabel returns the method of a request, or "other" for the methods no route serves
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+// statusRecorder keeps the status code written to a ResponseWriter, and flushes it for the streaming handlers
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// instrumentedValidator counts the failures of a Validator by broken rule
+type instrumentedValidator struct {
+	Validator
+	failures *prometheus.CounterVec
+}
+
+func (v *instrumentedValidator) ValidatePut(req []byte) (api.App, ValidationError) {
+	app, _, err := v.ValidatePutWithOptions(req, PutOptions{Mode: DecodeStrict})
+	return app, err
+}
+
+func (v *instrumentedValidator) ValidatePutWithOptions(req []byte, opts PutOptions) (api.App, []Warning, ValidationError) {
+	app, warnings, err := v.Validator.ValidatePutWithOptions(req, opts)
+	if err != nil {
+		rule := validationRule(err)
+		if rule == "" {
+			rule = ruleOther
+		}
+		v.failures.WithLabelValues(rule).Inc()
+	}
+	return app, warnings, err
+}
+
+// storeCollector collects the Stats of the Store of every namespace on each scrape
+type storeCollector struct {
+	namespaces cache.Namespaces
+	apps       *prometheus.Desc
+	rawBytes   *prometheus.Desc
+	terms      *prometheus.Desc
+	lockWaits  *prometheus.Desc
+}
+
+func newStoreCollector(namespaces cache.Namespaces) *storeCollector {
+	return &storeCollector{
+		namespaces: namespaces,
+		apps: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "store", "apps"),
+			"Number of Apps in the store, by namespace.", []string{"namespace"}, nil),
+		rawBytes: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "store", "raw_bytes"),
+			"Size of the raw documents of the Apps in the store, by namespace.", []string{"namespace"}, nil),
+		terms: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "index", "terms"),
+			"Number of terms in the inverted index of a field path, by namespace and field path, labels.* for every label.", []string{"namespace", "field"}, nil),
+		lockWaits: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "store", "lock_wait_seconds"),
+			"Time waited to lock the store, by namespace and mode (read or write).", []string{"namespace", "mode"}, nil),
+	}
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.apps
+	ch <- c.rawBytes
+	ch <- c.terms
+	ch <- c.lockWaits
+}
+
+// labelsField is the field label of the terms of every label, the label keys are chosen by the clients
+const labelsField = "labels.*"
+
+// termsByField returns the terms of each field path, the paths under labels summed as labels.*, so the number of
+// series does not grow with the label keys
+func termsByField(terms map[string]int) map[string]int {
+	rs := make(map[string]int, len(terms))
+	for field, n := range terms {
+		if strings.HasPrefix(field, "labels.") {
+			field = labelsField
+		}
+		rs[field] += n
+	}
+	return rs
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, namespace := range c.namespaces.List() {
+		stats := c.namespaces.Store(namespace).Stats()
+		ch <- prometheus.MustNewConstMetric(c.apps, prometheus.GaugeValue, float64(stats.Apps), namespace)
+		ch <- prometheus.MustNewConstMetric(c.rawBytes, prometheus.GaugeValue, float64(stats.RawBytes), namespace)
+		for field, terms := range termsByField(stats.Terms) {
+			ch <- prometheus.MustNewConstMetric(c.terms, prometheus.GaugeValue, float64(terms), namespace, field)
+		}
+		for mode, waits := range map[string]cache.LockWaits{"read": stats.ReadLockWaits, "write": stats.WriteLockWaits} {
+			ch <- prometheus.MustNewConstSummary(c.lockWaits, uint64(waits.Count), waits.Total.Seconds(), nil, namespace, mode)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	namespaces := cache.InitNamespaces()
+	metrics := NewMetrics(namespaces)
+	handler := NewHttpServer(WithNamespaces(namespaces), WithMetrics(metrics)).Handler()
+	do := func(method, target, namespace, body string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(namespaceHeader, namespace)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/apps", "payments", string(data)))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/apps/1", "payments", ""))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/apps/2", "payments", ""))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/unknown/path", "", ""))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/apps", "", "title: t\nversion: 1.0.0"))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/apps", "", "title: t\nlicence: MIT"))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/apps", "", nestedDocument(maxDocumentDepth+1)))
+	// the reads of unknown namespaces add no series
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/apps/1", "billing", ""))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/apps?title=t", "billing", ""))
+
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	for _, line := range []string{
+		`appserver_http_requests_total{code="201",method="POST",route="/apps"} 1`,
+		`appserver_http_requests_total{code="200",method="GET",route="/apps/{id}"} 1`,
+		`appserver_http_requests_total{code="404",method="GET",route="/apps/{id}"} 2`,
+		`appserver_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		`appserver_http_requests_total{code="400",method="POST",route="/apps"} 3`,
+		`appserver_http_request_duration_seconds_count{code="201",method="POST",route="/apps"} 1`,
+		`appserver_validation_failures_total{rule="required"} 1`,
+		`appserver_validation_failures_total{rule="strict"} 1`,
+		`appserver_validation_failures_total{rule="document"} 1`,
+		`appserver_store_apps{namespace="payments"} 1`,
+		`appserver_store_apps{namespace="default"} 0`,
+		`appserver_store_raw_bytes{namespace="payments"} ` + strconv.Itoa(len(data)),
+		`appserver_index_terms{field="maintainers.email",namespace="payments"} 2`,
+		`appserver_index_terms{field="labels.*",namespace="payments"} 1`,
+		`appserver_store_lock_wait_seconds_count{mode="write",namespace="payments"} 1`,
+		`go_goroutines `,
+	} {
+		assert.Contains(t, body, line)
+	}
+	assert.NotContains(t, body, `field="labels.k"`)
+	assert.NotContains(t, body, `namespace="billing"`)
+}
+
+func TestTermsByField(t *testing.T) {
+	terms := termsByField(map[string]int{"title": 3, "labels.env": 2, "labels.team": 4, "labels": 0})
+	assert.Equal(t, map[string]int{"title": 3, "labels.*": 6, "labels": 0}, terms)
+}
+
+func TestStatusRecorder_Flush(t *testing.T) {
+	rr := httptest.NewRecorder()
+	var w http.ResponseWriter = &statusRecorder{ResponseWriter: rr}
+	// the watch streams need a Flusher
+	flusher, ok := w.(http.Flusher)
+	assert.True(t, ok)
+	w.Write([]byte("line\n"))
+	flusher.Flush()
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, http.StatusOK, w.(*statusRecorder).status)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// namespaceHeader is the request header (and grpc metadata key, lower case) naming the namespace of a request
+	namespaceHeader = "X-Namespace"
+	// allNamespaces is the namespace of the searches across every namespace
+	allNamespaces = "*"
+)
+
+// namespaceSyntax is the syntax of namespace names, a DNS label
+const namespaceSyntax = `[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?`
+
+var namespacePattern = regexp.MustCompile(`^` + namespaceSyntax + `$`)
+
+type namespaceKey struct{}
+
+// NamespaceFromContext returns the namespace of a request, cache.DefaultNamespace when it names none
+func NamespaceFromContext(ctx context.Context) string {
+	if namespace, ok := ctx.Value(namespaceKey{}).(string); ok {
+		return namespace
+	}
+	return cache.DefaultNamespace
+}
+
+func withNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// parseNamespace checks the namespace named by a request, the default namespace when empty.
+// Only searches may name every namespace.
+func parseNamespace(value string, search bool) (string, error) {
+	switch {
+	case value == "":
+		return cache.DefaultNamespace, nil
+	case value == allNamespaces && search:
+		return allNamespaces, nil
+	case value == allNamespaces:
+		return "", fmt.Errorf("namespace %s is only accepted by searches", allNamespaces)
+	case !namespacePattern.MatchString(value):
+		return "", fmt.Errorf("invalid namespace %q, expecting lower case alphanumeric characters or '-'", value)
+	}
+	return value, nil
+}
+
+// isSearchRequest tells if a request searches the Apps: GET /apps and the /query endpoints
+func isSearchRequest(method, path string) bool {
+	segments := splitPath(path)
+	switch segments[len(segments)-1] {
+	case "query":
+		return true
+	case "apps":
+		return method == http.MethodGet
+	}
+	return false
+}
+
+// namespaced puts the namespace of the X-Namespace header in the request context
+func namespaced(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		namespace, err := parseNamespace(req.Header.Get(namespaceHeader), isSearchRequest(req.Method, req.URL.Path))
+		if err != nil {
+			handleValidationError(w, err)
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(withNamespace(req.Context(), namespace)))
+	})
+}
+
+// lookupStore returns the Store of the namespace of ctx, false when the namespace does not exist
+func (h *httpServerImpl) lookupStore(ctx context.Context) (cache.Store, bool) {
+	namespace := NamespaceFromContext(ctx)
+	if namespace == cache.DefaultNamespace {
+		return h.store, true
+	}
+	return h.namespaces.Lookup(namespace)
+}
+
+// storeOf returns the Store of the namespace of ctx, an empty Store when the namespace does not exist.
+// Only createStoreOf creates the namespaces, the reads of an unknown namespace find nothing.
+func (h *httpServerImpl) storeOf(ctx context.Context) cache.Store {
+	if store, ok := h.lookupStore(ctx); ok {
+		return store
+	}
+	return cache.InitStore()
+}
+
+// createStoreOf returns the Store of the namespace of ctx, created on first use, for the authorized creates
+func (h *httpServerImpl) createStoreOf(ctx context.Context) cache.Store {
+	if namespace := NamespaceFromContext(ctx); namespace != cache.DefaultNamespace {
+		return h.namespaces.Store(namespace)
+	}
+	return h.store
+}
+
+// errNamespaceNotFound is the error of the requests on a namespace which does not exist
+func errNamespaceNotFound(namespace string) error {
+	return fmt.Errorf("namespace %s %w", namespace, cache.ErrNotFound)
+}
+
+// searchStore searches the Store of the namespace of ctx, or every namespace, for the Apps the principal may read
+func (h *httpServerImpl) searchStore(ctx context.Context, app *api.App) ([]api.Id, error) {
+	if NamespaceFromContext(ctx) == allNamespaces {
+		return searchAllNamespaces(h.authz, h.namespaces, ctx, app)
+	}
+	store := h.storeOf(ctx)
+	ids, err := store.SearchStruct(app)
+	if err != nil {
+		return nil, err
+	}
+	return filterReadable(h.authz, store, ctx, ids), nil
+}
+
+// searchAllNamespaces searches every namespace, and returns the Ids qualified by their namespace, e.g. "payments/1"
+func searchAllNamespaces(authz Authorizer, namespaces cache.Namespaces, ctx context.Context, app *api.App) ([]api.Id, error) {
+	rs := make([]api.Id, 0)
+	for _, namespace := range namespaces.List() {
+		store := namespaces.Store(namespace)
+		ids, err := store.SearchStruct(app)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range filterReadable(authz, store, withNamespace(ctx, namespace), ids) {
+			rs = append(rs, api.Id(namespace+"/"+string(id)))
+		}
+	}
+	return rs, nil
+}
+
+// namespaced returns ctx with the namespace of the x-namespace metadata, and the Store of that namespace,
+// an empty Store when the namespace does not exist
+func (g *grpcServerImpl) namespaced(ctx context.Context, search bool) (context.Context, cache.Store, error) {
+	value := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if namespaces := md.Get(strings.ToLower(namespaceHeader)); len(namespaces) > 0 {
+		value = namespaces[0]
+	}
+	namespace, err := parseNamespace(value, search)
+	if err != nil {
+		return ctx, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx = withNamespace(ctx, namespace)
+	if namespace == allNamespaces {
+		return ctx, nil, nil
+	}
+	if store, ok := g.namespaces.Lookup(namespace); ok {
+		return ctx, store, nil
+	}
+	return ctx, cache.InitStore(), nil
+}
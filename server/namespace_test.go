@@ -0,0 +1,209 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"application_metadata_api_server/server/pb"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseNamespace(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		search   bool
+		expected string
+		err      string
+	}{
+		{"", false, cache.DefaultNamespace, ""},
+		{"payments", false, "payments", ""},
+		{"team-42", false, "team-42", ""},
+		{"*", true, allNamespaces, ""},
+		{"*", false, "", "namespace * is only accepted by searches"},
+		{"Payments", false, "", `invalid namespace "Payments", expecting lower case alphanumeric characters or '-'`},
+		{"-payments", false, "", `invalid namespace "-payments", expecting lower case alphanumeric characters or '-'`},
+		{"a/b", true, "", `invalid namespace "a/b", expecting lower case alphanumeric characters or '-'`},
+	} {
+		namespace, err := parseNamespace(tc.value, tc.search)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.value)
+			continue
+		}
+		assert.Nil(t, err, tc.value)
+		assert.Equal(t, tc.expected, namespace, tc.value)
+	}
+}
+
+func TestHttpServerImpl_Handler_Namespaces(t *testing.T) {
+	handler := NewHttpServer().Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	do := func(method, path, namespace string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if namespace != "" {
+			req.Header.Set(namespaceHeader, namespace)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// each namespace has its own Ids
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/apps", "", string(data)).Code)
+	rr := do(http.MethodPost, "/apps", "payments", strings.Replace(string(data), "Valid App 1", "Payments App", 1))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"id":"1"`)
+	rr = do(http.MethodGet, "/apps/1", "payments", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Payments App")
+	rr = do(http.MethodGet, "/apps/1", cache.DefaultNamespace, "")
+	assert.Contains(t, rr.Body.String(), "Valid App 1")
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/apps/1", "billing", "").Code)
+
+	rr = do(http.MethodGet, "/apps?company=Random%20Inc.", "payments", "")
+	assert.JSONEq(t, `{"result_list": ["1"]}`, rr.Body.String())
+	rr = do(http.MethodPost, "/query", allNamespaces, "company: Random Inc.")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"result_list": ["default/1", "payments/1"]}`, rr.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/apps/1", allNamespaces, "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/apps/1", "Payments", "").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/apps/1", "payments", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/apps/1", "", "").Code)
+}
+
+func TestHttpServerImpl_Handler_UnknownNamespaces(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{{Name: "ci", SHA256: apiKeySHA256("ci")}}})
+	assert.Nil(t, err)
+	authz, err := NewAuthorizer(&RBACFile{Bindings: []RoleBinding{
+		{Name: "ci", Role: RoleEditor, Subjects: RoleSubjects{Names: []string{"ci"}}, Namespaces: []string{"payments", "team-a"}},
+	}})
+	assert.Nil(t, err)
+	namespaces := cache.InitNamespaces()
+	handler := NewHttpServer(WithNamespaces(namespaces), WithAuthenticator(authn), WithAuthorizer(authz)).Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+
+	// the reads, the denied and the failed writes do not create the namespaces
+	for _, tc := range []struct {
+		method, path, apiKey, namespace, body string
+		expected                              int
+	}{
+		{http.MethodGet, "/apps/1", "", "billing", "", http.StatusNotFound},
+		{http.MethodPost, "/v1/get", "", "billing", "1", http.StatusNotFound},
+		{http.MethodGet, "/apps?company=Random%20Inc.", "", "billing", "", http.StatusOK},
+		{http.MethodPost, "/query", "", "billing", "company: Random Inc.", http.StatusOK},
+		{http.MethodGet, "/export", "", "billing", "", http.StatusOK},
+		{http.MethodGet, "/watch", "", "billing", "", http.StatusNotFound},
+		{http.MethodPost, "/apps", "", "billing", string(data), http.StatusUnauthorized},
+		{http.MethodPost, "/apps", "ci", "billing", string(data), http.StatusForbidden},
+		{http.MethodPut, "/apps/1", "ci", "payments", string(data), http.StatusNotFound},
+		{http.MethodDelete, "/apps/1", "ci", "payments", "", http.StatusNotFound},
+		{http.MethodPost, "/batch", "ci", "payments", `{"operations": [{"op": "delete", "id": "1"}]}`, http.StatusNotFound},
+		{http.MethodPost, "/batch", "ci", "payments", `{"operations": [{"op": "create", "document": {}}, {"op": "update", "id": "1", "document": {}}]}`, http.StatusNotFound},
+		{http.MethodPost, "/batch", "ci", "payments", `{"operations": [{"op": "create", "document": "{}"}]}`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set(namespaceHeader, tc.namespace)
+		if tc.apiKey != "" {
+			req.Header.Set(apiKeyHeader, tc.apiKey)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, tc.expected, rr.Code, "%s %s %s %s: %s", tc.method, tc.path, tc.apiKey, tc.namespace, rr.Body.String())
+		assert.Equal(t, []string{cache.DefaultNamespace}, namespaces.List(), "%s %s %s", tc.method, tc.path, tc.namespace)
+	}
+
+	// the authorized creates do
+	req := httptest.NewRequest(http.MethodPost, "/apps", strings.NewReader(string(data)))
+	req.Header.Set(namespaceHeader, "payments")
+	req.Header.Set(apiKeyHeader, "ci")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, []string{cache.DefaultNamespace, "payments"}, namespaces.List())
+	doc, err := yaml.YAMLToJSON(data)
+	assert.Nil(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`{"operations": [{"op": "create", "document": `+string(doc)+`}]}`))
+	req.Header.Set(namespaceHeader, "team-a")
+	req.Header.Set(apiKeyHeader, "ci")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	store, ok := namespaces.Lookup("team-a")
+	assert.True(t, ok)
+	assert.Equal(t, []api.Id{"1"}, store.List())
+}
+
+func TestHttpServerImpl_Handler_AllNamespacesAuthorization(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{
+		{Name: "root", SHA256: apiKeySHA256("root"), Groups: []string{"platform"}},
+		{Name: "payments-admin", SHA256: apiKeySHA256("payments"), Groups: []string{"payments"}},
+	}})
+	assert.Nil(t, err)
+	authz, err := NewAuthorizer(&RBACFile{Bindings: []RoleBinding{
+		{Name: "platform", Role: RoleAdmin, Subjects: RoleSubjects{Groups: []string{"platform"}}},
+		{Name: "payments", Role: RoleAdmin, Subjects: RoleSubjects{Groups: []string{"payments"}}, Namespaces: []string{"payments"}},
+	}})
+	assert.Nil(t, err)
+	handler := NewHttpServer(WithAuthenticator(authn), WithAuthorizer(authz)).Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+
+	for _, tc := range []struct {
+		method, path, apiKey, namespace string
+		expected                        int
+	}{
+		// a namespace admin only administers its namespace
+		{http.MethodPost, "/apps", "payments", "payments", http.StatusCreated},
+		{http.MethodPost, "/apps", "payments", "", http.StatusForbidden},
+		{http.MethodGet, "/apps?company=Random%20Inc.", "payments", allNamespaces, http.StatusForbidden},
+		{http.MethodGet, "/apps?company=Random%20Inc.", "", allNamespaces, http.StatusForbidden},
+		{http.MethodGet, "/apps?company=Random%20Inc.", "root", allNamespaces, http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(string(data)))
+		req.Header.Set(namespaceHeader, tc.namespace)
+		if tc.apiKey != "" {
+			req.Header.Set(apiKeyHeader, tc.apiKey)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, tc.expected, rr.Code, "%s %s %s %s: %s", tc.method, tc.path, tc.apiKey, tc.namespace, rr.Body.String())
+	}
+}
+
+func TestGrpcServerImpl_Namespaces(t *testing.T) {
+	namespaces := cache.InitNamespaces()
+	client := newGrpcTestClient(t, WithNamespaces(namespaces))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	payments := metadata.AppendToOutgoingContext(ctx, "x-namespace", "payments")
+
+	resp, err := client.Put(payments, &pb.PutRequest{App: newTestProtoApp("grpc app")})
+	assert.Nil(t, err)
+	_, err = namespaces.Store("payments").Get("1")
+	assert.Nil(t, err)
+	_, err = client.Get(ctx, &pb.GetRequest{Id: resp.GetId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	// the reads of an unknown namespace do not create it
+	_, err = client.Get(metadata.AppendToOutgoingContext(ctx, "x-namespace", "billing"), &pb.GetRequest{Id: "1"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, []string{cache.DefaultNamespace, "payments"}, namespaces.List())
+
+	search, err := client.Search(metadata.AppendToOutgoingContext(ctx, "x-namespace", "*"), &pb.SearchRequest{Query: &pb.App{Title: "grpc app"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"payments/1"}, search.GetIds())
+	_, err = client.Delete(metadata.AppendToOutgoingContext(ctx, "x-namespace", "*"), &pb.DeleteRequest{Id: "1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
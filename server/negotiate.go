@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	mediaTypeJSON   = "application/json"
+	mediaTypeYAML   = "application/yaml"
+	mediaTypeNDJSON = "application/x-ndjson"
+)
+
+// mediaTypeAliases maps the other common names of the supported media types
+var mediaTypeAliases = map[string]string{
+	"application/x-yaml": mediaTypeYAML,
+	"text/yaml":          mediaTypeYAML,
+	"text/x-yaml":        mediaTypeYAML,
+	"application/jsonl":  mediaTypeNDJSON,
+}
+
+// sniffedContentTypes are request content types that do not tell the format, the body is parsed as yaml,
+// which json is a subset of. curl --data-binary sends application/x-www-form-urlencoded by default.
+var sniffedContentTypes = map[string]bool{
+	"":                                  true,
+	"text/plain":                        true,
+	"application/octet-stream":          true,
+	"application/x-www-form-urlencoded": true,
+}
+
+func normalizeMediaType(mediaType string) string {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if alias, ok := mediaTypeAliases[mediaType]; ok {
+		return alias
+	}
+	return mediaType
+}
+
+// checkContentType verifies the request body is in a supported format, and is valid json when declared so
+func checkContentType(req *http.Request, body []byte, accepted ...string) error {
+	contentType := req.Header.Get("Content-Type")
+	mediaType := ""
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("invalid Content-Type %q: %w", contentType, err)
+		}
+	}
+	mediaType = normalizeMediaType(mediaType)
+	if sniffedContentTypes[mediaType] {
+		return nil
+	}
+	for _, a := range accepted {
+		if mediaType != a {
+			continue
+		}
+		if strings.HasSuffix(mediaType, "json") && !json.Valid(body) {
+			return fmt.Errorf("request body is not valid json")
+		}
+		return nil
+	}
+	return &unsupportedMediaTypeError{mediaType: mediaType, accepted: accepted}
+}
+
+type unsupportedMediaTypeError struct {
+	mediaType string
+	accepted  []string
+}
+
+func (e *unsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("unsupported Content-Type %q, expecting one of %s", e.mediaType, strings.Join(e.accepted, ", "))
+}
+
+// handleContentTypeError writes a 415 for unsupported media types, and a 400 for malformed bodies
+func handleContentTypeError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*unsupportedMediaTypeError); ok {
+		handleUnsupportedMediaTypeError(w, err)
+		return
+	}
+	handleValidationError(w, err)
+}
+
+// negotiate returns the media type to respond with among offers, following the Accept header of the request.
+// Without an Accept header, or with */*, the first offer is returned. ok is false when no offer is acceptable.
+func negotiate(req *http.Request, offers ...string) (mediaType string, ok bool) {
+	accept := req.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	type acceptRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := make([]acceptRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mediaType: normalizeMediaType(mt), q: q})
+		}
+	}
+	// stable, so ranges of equal quality keep the client's order
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	for _, r := range ranges {
+		for _, offer := range offers {
+			if r.mediaType == offer || r.mediaType == "*/*" ||
+				(strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(r.mediaType, "*"))) {
+				return offer, true
+			}
+		}
+	}
+	return "", false
+}
+
+// writeNegotiated writes resp in json or yaml following the Accept header, json being the default
+func writeNegotiated(w http.ResponseWriter, req *http.Request, status int, resp interface{}) {
+	mediaType, ok := negotiate(req, mediaTypeJSON, mediaTypeYAML)
+	if !ok {
+		handleNotAcceptableError(w, fmt.Errorf("no acceptable media type in %q, expecting %s or %s", req.Header.Get("Accept"), mediaTypeJSON, mediaTypeYAML))
+		return
+	}
+	var data []byte
+	var err error
+	if mediaType == mediaTypeYAML {
+		data, err = yaml.Marshal(resp)
+	} else {
+		data, err = json.Marshal(resp)
+	}
+	if err != nil {
+		log.Errorf("Error happened in %s marshal error: %+v", mediaType, err)
+		handleInternalError(w, err, "marshal error")
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// writeDocument writes a stored yaml or json document following the Accept header. The raw bytes are written
+// unchanged when their format is accepted, which is the default.
+func writeDocument(w http.ResponseWriter, req *http.Request, raw []byte) {
+	rawType := mediaTypeYAML
+	otherType := mediaTypeJSON
+	if isJSON(raw) {
+		rawType, otherType = mediaTypeJSON, mediaTypeYAML
+	}
+	mediaType, ok := negotiate(req, rawType, otherType)
+	if !ok {
+		handleNotAcceptableError(w, fmt.Errorf("no acceptable media type in %q, expecting %s or %s", req.Header.Get("Accept"), mediaTypeJSON, mediaTypeYAML))
+		return
+	}
+	data := raw
+	if mediaType != rawType {
+		var err error
+		if mediaType == mediaTypeJSON {
+			data, err = yaml.YAMLToJSON(raw)
+		} else {
+			data, err = yaml.JSONToYAML(raw)
+		}
+		if err != nil {
+			handleInternalError(w, err, fmt.Sprintf("failed to convert document to %s", mediaType))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// writeList writes a list under key, or as one json object per line when application/x-ndjson is accepted
+func writeList(w http.ResponseWriter, req *http.Request, key string, items []interface{}) {
+	mediaType, ok := negotiate(req, mediaTypeJSON, mediaTypeYAML, mediaTypeNDJSON)
+	if !ok {
+		handleNotAcceptableError(w, fmt.Errorf("no acceptable media type in %q, expecting %s, %s or %s", req.Header.Get("Accept"), mediaTypeJSON, mediaTypeYAML, mediaTypeNDJSON))
+		return
+	}
+	if mediaType != mediaTypeNDJSON {
+		writeNegotiated(w, req, http.StatusOK, map[string]interface{}{key: items})
+		return
+	}
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	for _, item := range items {
+		if err := encoder.Encode(item); err != nil {
+			handleInternalError(w, err, "json marshal error")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", mediaTypeNDJSON)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
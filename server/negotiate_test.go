@@ -0,0 +1,124 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	testCases := []struct {
+		name              string
+		accept            string
+		offers            []string
+		expectedMediaType string
+		expectedOk        bool
+	}{
+		{name: "no accept header picks the first offer", offers: []string{mediaTypeYAML, mediaTypeJSON}, expectedMediaType: mediaTypeYAML, expectedOk: true},
+		{name: "wildcard picks the first offer", accept: "*/*", offers: []string{mediaTypeJSON, mediaTypeYAML}, expectedMediaType: mediaTypeJSON, expectedOk: true},
+		{name: "exact match", accept: "application/yaml", offers: []string{mediaTypeJSON, mediaTypeYAML}, expectedMediaType: mediaTypeYAML, expectedOk: true},
+		{name: "alias", accept: "text/yaml", offers: []string{mediaTypeJSON, mediaTypeYAML}, expectedMediaType: mediaTypeYAML, expectedOk: true},
+		{name: "quality", accept: "application/json;q=0.5, application/yaml", offers: []string{mediaTypeJSON, mediaTypeYAML}, expectedMediaType: mediaTypeYAML, expectedOk: true},
+		{name: "subtype wildcard", accept: "text/html, application/*;q=0.1", offers: []string{mediaTypeJSON}, expectedMediaType: mediaTypeJSON, expectedOk: true},
+		{name: "nothing acceptable", accept: "text/html, application/json;q=0", offers: []string{mediaTypeJSON, mediaTypeYAML}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/apps", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			mediaType, ok := negotiate(req, test.offers...)
+			assert.Equal(t, test.expectedOk, ok)
+			assert.Equal(t, test.expectedMediaType, mediaType)
+		})
+	}
+}
+
+func TestCheckContentType(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		contentType          string
+		body                 string
+		expectedResponseCode int
+	}{
+		{name: "curl default is sniffed", contentType: "application/x-www-form-urlencoded", body: "title: t", expectedResponseCode: http.StatusOK},
+		{name: "yaml", contentType: "application/x-yaml", body: "title: t", expectedResponseCode: http.StatusOK},
+		{name: "json", contentType: "application/json; charset=utf-8", body: `{"title":"t"}`, expectedResponseCode: http.StatusOK},
+		{name: "yaml declared as json", contentType: "application/json", body: "title: t", expectedResponseCode: http.StatusBadRequest},
+		{name: "unsupported", contentType: "application/xml", body: "<title/>", expectedResponseCode: http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/apps", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			w := httptest.NewRecorder()
+			if err := checkContentType(req, []byte(test.body), mediaTypeJSON, mediaTypeYAML); err != nil {
+				handleContentTypeError(w, err)
+			}
+			assert.Equal(t, test.expectedResponseCode, w.Result().StatusCode)
+		})
+	}
+}
+
+func TestWriteDocument(t *testing.T) {
+	yamlDoc := []byte("title: t\n")
+	jsonDoc := []byte(`{"title":"t"}`)
+
+	testCases := []struct {
+		name                string
+		raw                 []byte
+		accept              string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{name: "raw yaml by default", raw: yamlDoc, expectedContentType: mediaTypeYAML, expectedBody: string(yamlDoc)},
+		{name: "raw json by default", raw: jsonDoc, expectedContentType: mediaTypeJSON, expectedBody: string(jsonDoc)},
+		{name: "yaml to json", raw: yamlDoc, accept: mediaTypeJSON, expectedContentType: mediaTypeJSON, expectedBody: string(jsonDoc)},
+		{name: "json to yaml", raw: jsonDoc, accept: mediaTypeYAML, expectedContentType: mediaTypeYAML, expectedBody: string(yamlDoc)},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/apps/1", nil)
+			req.Header.Set("Accept", test.accept)
+			w := httptest.NewRecorder()
+			writeDocument(w, req, test.raw)
+
+			resp := w.Result()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, test.expectedContentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, test.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestWriteList(t *testing.T) {
+	items := []interface{}{"1", "2"}
+
+	req := httptest.NewRequest("GET", "/apps", nil)
+	w := httptest.NewRecorder()
+	writeList(w, req, "result_list", items)
+	assert.Equal(t, mediaTypeJSON, w.Result().Header.Get("Content-Type"))
+	assert.Equal(t, `{"result_list":["1","2"]}`, w.Body.String())
+
+	req.Header.Set("Accept", mediaTypeYAML)
+	w = httptest.NewRecorder()
+	writeList(w, req, "result_list", items)
+	assert.Equal(t, "result_list:\n- \"1\"\n- \"2\"\n", w.Body.String())
+
+	req.Header.Set("Accept", mediaTypeNDJSON)
+	w = httptest.NewRecorder()
+	writeList(w, req, "result_list", items)
+	assert.Equal(t, mediaTypeNDJSON, w.Result().Header.Get("Content-Type"))
+	assert.Equal(t, "\"1\"\n\"2\"\n", w.Body.String())
+
+	req.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	writeList(w, req, "result_list", items)
+	assert.Equal(t, http.StatusNotAcceptable, w.Result().StatusCode)
+}
@@ -0,0 +1,667 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	// openAPIVersion is the OpenAPI version of the document, 3.1 uses JSON Schema for its schemas
+	openAPIVersion = "3.1.0"
+	// apiDocVersion is the version of the API described by the document
+	apiDocVersion = "1.0.0"
+
+	mediaTypeSchemaJSON = "application/schema+json"
+	mediaTypeText       = "text/plain"
+	mediaTypeHTML       = "text/html"
+	mediaTypeCSS        = "text/css"
+	mediaTypeJavaScript = "text/javascript"
+
+	componentsPrefix = "#/components/schemas/"
+)
+
+// openAPIDoc is the subset of an OpenAPI 3.1 document needed to describe the API server
+type openAPIDoc struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       openAPIInfo         `json:"info"`
+	Paths      map[string]pathItem `json:"paths"`
+	Components openAPIComponents   `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*JSONSchema     `json:"schemas"`
+	SecuritySchemes map[string]*securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// securitySchemes are the credentials accepted by an Authenticator
+var securitySchemes = map[string]*securityScheme{
+	"apiKey":    {Type: "apiKey", In: "header", Name: apiKeyHeader},
+	"bearer":    {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+	"mutualTLS": {Type: "mutualTLS", Description: "a client certificate verified by the TLS client CA"},
+}
+
+// writeSecurity is the security of write operations, any one of the schemes
+var writeSecurity = []map[string][]string{{"apiKey": {}}, {"bearer": {}}, {"mutualTLS": {}}}
+
+// storelessPaths are the paths not reading or writing the Apps, they have no namespace
+var storelessPaths = map[string]bool{"/validate": true, "/schema": true, "/openapi.json": true, "/docs": true, "/docs/{asset}": true, "/audit": true}
+
+// pathItem maps the lower case http methods of a path to their operation
+type pathItem map[string]*operation
+
+type operation struct {
+	OperationID string `json:"operationId"`
+	Summary     string `json:"summary"`
+	Deprecated  bool   `json:"deprecated,omitempty"`
+	// Security is set on write operations, which need credentials when the server has an Authenticator
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []*parameter          `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	// Responses maps the status codes to their response
+	Responses map[string]*response `json:"responses"`
+}
+
+type parameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *JSONSchema `json:"schema"`
+}
+
+type requestBody struct {
+	Description string                      `json:"description,omitempty"`
+	Required    bool                        `json:"required,omitempty"`
+	Content     map[string]*mediaTypeObject `json:"content"`
+}
+
+type response struct {
+	Description string                      `json:"description"`
+	Headers     map[string]*header          `json:"headers,omitempty"`
+	Content     map[string]*mediaTypeObject `json:"content,omitempty"`
+}
+
+type header struct {
+	Description string      `json:"description,omitempty"`
+	Schema      *JSONSchema `json:"schema"`
+}
+
+// mediaTypeObject is the schema of a body in a media type. For application/x-ndjson it is the schema of each line.
+type mediaTypeObject struct {
+	Schema *JSONSchema `json:"schema"`
+}
+
+// searchResponse is the body of a search response
+type searchResponse struct {
+	ResultList []api.Id `json:"result_list"`
+}
+
+// auditResponse is the body of an audit query response
+type auditResponse struct {
+	Records []AuditRecord `json:"records"`
+}
+
+// componentTypes are the Go types of the request and response bodies, documented as components
+var componentTypes = map[string]reflect.Type{
+	"Error":            reflect.TypeOf(ErrorResponse{}),
+	"WriteResponse":    reflect.TypeOf(WriteResponse{}),
+	"SearchResponse":   reflect.TypeOf(searchResponse{}),
+	"ValidationReport": reflect.TypeOf(ValidationReport{}),
+	"ImportResponse":   reflect.TypeOf(ImportResponse{}),
+	"BatchRequest":     reflect.TypeOf(BatchRequest{}),
+	"BatchResponse":    reflect.TypeOf(BatchResponse{}),
+	"WatchEvent":       reflect.TypeOf(WatchEvent{}),
+	"JSONPatch":        reflect.TypeOf([]jsonPatchOp{}),
+	"GraphQLRequest":   reflect.TypeOf(graphQLRequest{}),
+	"GraphQLResponse":  reflect.TypeOf(graphql.Result{}),
+	"AuditRecord":      reflect.TypeOf(AuditRecord{}),
+	"AuditResponse":    reflect.TypeOf(auditResponse{}),
+}
+
+// errorDescriptions are the descriptions of the error responses, their body is an Error
+var errorDescriptions = map[int]string{
+	http.StatusBadRequest:            invalidInputMsg,
+	http.StatusUnauthorized:          unauthorizedMsg,
+	http.StatusForbidden:             forbiddenMsg,
+	http.StatusNotFound:              notFoundMsg,
+	http.StatusMethodNotAllowed:      methodNotAllowedMsg,
+	http.StatusNotAcceptable:         notAcceptableMsg,
+	http.StatusRequestEntityTooLarge: requestTooLargeMsg,
+	http.StatusUnsupportedMediaType:  unsupportedMediaMsg,
+	http.StatusTooManyRequests:       tooManyRequestsMsg,
+	http.StatusInternalServerError:   internalServerErrorMsg,
+}
+
+// newOpenAPI documents every route with its entry in operations, and fails when a route is not documented
+// or an operation is not served
+func newOpenAPI(routes []*route) (*openAPIDoc, error) {
+	doc := &openAPIDoc{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:   "Application metadata API server",
+			Version: apiDocVersion,
+			Description: "Stores App metadata documents in yaml or json and searches them. " +
+				"Paths under an apiVersion prefix such as /v2 take and return Apps in that apiVersion.",
+		},
+		Paths:      make(map[string]pathItem),
+		Components: openAPIComponents{Schemas: componentSchemas(), SecuritySchemes: securitySchemes},
+	}
+	documented := make(map[string]bool)
+	for key := range operations(ref("App")) {
+		documented[key] = false
+	}
+	for _, rt := range routes {
+		version, path := splitVersionPrefix(rt.pattern)
+		app, suffix := ref("App"), ""
+		if version != "" {
+			app, suffix = ref("App."+version), "_"+version
+		}
+		key := operationKey(rt.method, path)
+		op, ok := operations(app)[key]
+		if !ok {
+			return nil, fmt.Errorf("route %s is not documented", operationKey(rt.method, rt.pattern))
+		}
+		documented[key] = true
+		op.OperationID += suffix
+		if !storelessPaths[path] {
+			op.Parameters = append(op.Parameters, namespaceParameter(isSearchRequest(strings.ToUpper(operationMethod(rt.method)), rt.pattern)))
+		}
+		if method := strings.ToUpper(operationMethod(rt.method)); mayWrite(method, rt.pattern) {
+			op.Security = writeSecurity
+			if !isWriteRequest(method, rt.pattern) {
+				// only the GraphQL mutations need credentials, the empty requirement makes them optional
+				op.Security = append(append([]map[string][]string{}, writeSecurity...), map[string][]string{})
+			}
+			op.Responses[fmt.Sprint(http.StatusUnauthorized)] = &response{
+				Description: unauthorizedMsg,
+				Content:     content(ref("Error"), mediaTypeJSON),
+			}
+		}
+		// the bodies are limited when the server has a max body size
+		if op.RequestBody != nil {
+			op.Responses[fmt.Sprint(http.StatusRequestEntityTooLarge)] = &response{
+				Description: requestTooLargeMsg,
+				Content:     content(ref("Error"), mediaTypeJSON),
+			}
+		}
+		// every route is rate limited when the server has a RateLimiter
+		op.Responses[fmt.Sprint(http.StatusTooManyRequests)] = &response{
+			Description: tooManyRequestsMsg,
+			Headers:     map[string]*header{"Retry-After": {Description: "seconds to wait before retrying", Schema: &JSONSchema{Type: "integer"}}},
+			Content:     content(ref("Error"), mediaTypeJSON),
+		}
+		if doc.Paths[rt.pattern] == nil {
+			doc.Paths[rt.pattern] = make(pathItem)
+		}
+		doc.Paths[rt.pattern][operationMethod(rt.method)] = op
+	}
+	for key, ok := range documented {
+		if !ok {
+			return nil, fmt.Errorf("operation %s is documented but not served", key)
+		}
+	}
+	return doc, nil
+}
+
+// componentSchemas returns the schema of every App apiVersion and of componentTypes
+func componentSchemas() map[string]*JSONSchema {
+	rs := make(map[string]*JSONSchema)
+	app := &JSONSchema{Description: "an App in any apiVersion, its apiVersion field selects the schema"}
+	versions := make([]string, 0, len(schemas))
+	for version := range schemas {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		s := *schemas[version]
+		s.Schema = ""
+		rs["App."+version] = &s
+		app.AnyOf = append(app.AnyOf, ref("App."+version))
+	}
+	rs["App"] = app
+	for name, t := range componentTypes {
+		rs[name] = schemaForType(t, true)
+	}
+	return rs
+}
+
+// operations documents the routes, by operationKey of their path without apiVersion prefix.
+// app is the App schema of the prefix.
+func operations(app *JSONSchema) map[string]*operation {
+	idParam := &parameter{Name: "id", In: "path", Required: true, Schema: &JSONSchema{Type: "string"}}
+	strictParam := &parameter{Name: "strict", In: "query", Schema: &JSONSchema{Type: "boolean"},
+		Description: "false reports unknown and duplicate keys as warnings instead of rejecting the App"}
+	documents := content(app, mediaTypeJSON, mediaTypeYAML)
+	write := withErrors(map[int]*response{
+		http.StatusOK: {Description: "the App was stored", Content: negotiated("WriteResponse")},
+	}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError)
+	search := withErrors(map[int]*response{
+		http.StatusOK: {Description: "the Ids of the matching Apps", Content: lists("SearchResponse", &JSONSchema{Type: "string"})},
+	}, http.StatusBadRequest, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError)
+	get := withErrors(map[int]*response{
+		http.StatusOK: {Description: "the App", Content: documents},
+	}, http.StatusNotFound, http.StatusNotAcceptable, http.StatusInternalServerError)
+
+	return map[string]*operation{
+		operationKey(http.MethodPost, "/apps"): {
+			OperationID: "createApp",
+			Summary:     "Validate and store a new App, its Id is assigned by the server",
+			Parameters: []*parameter{strictParam, {Name: "dryRun", In: "query", Schema: &JSONSchema{Type: "boolean"},
+				Description: "true only validates the App, and returns a ValidationReport"}},
+			RequestBody: &requestBody{Required: true, Content: documents},
+			Responses: withErrors(map[int]*response{
+				http.StatusCreated: {
+					Description: "the App was stored",
+					Headers:     map[string]*header{"Location": {Description: "url of the App", Schema: &JSONSchema{Type: "string"}}},
+					Content:     negotiated("WriteResponse"),
+				},
+				http.StatusOK: {Description: "the dry run report of a valid App", Content: negotiated("ValidationReport")},
+				http.StatusBadRequest: {
+					Description: "the App is invalid or violates a policy, the dry run report of an invalid App",
+					Content:     content(&JSONSchema{AnyOf: []*JSONSchema{ref("Error"), ref("ValidationReport")}}, mediaTypeJSON, mediaTypeYAML),
+				},
+			}, http.StatusForbidden, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodGet, "/apps"): {
+			OperationID: "listApps",
+			Summary:     "Search the Apps having every field of the query parameters, every App without parameter",
+			Parameters:  searchParameters(reflect.TypeOf(api.App{}), ""),
+			Responses:   search,
+		},
+		operationKey(http.MethodGet, "/apps/{id}"): {
+			OperationID: "getApp",
+			Summary:     "Get an App, as stored or converted to the apiVersion of the prefix",
+			Parameters:  []*parameter{idParam},
+			Responses:   get,
+		},
+		operationKey(http.MethodPut, "/apps/{id}"): {
+			OperationID: "replaceApp",
+			Summary:     "Validate and replace an App",
+			Parameters:  []*parameter{idParam, strictParam},
+			RequestBody: &requestBody{Required: true, Content: documents},
+			Responses:   write,
+		},
+		operationKey(http.MethodPatch, "/apps/{id}"): {
+			OperationID: "patchApp",
+			Summary:     "Apply a JSON patch, or a JSON merge patch for any other Content-Type, and validate the patched App",
+			Parameters:  []*parameter{idParam, strictParam},
+			RequestBody: &requestBody{Required: true, Content: map[string]*mediaTypeObject{
+				mediaTypeJSONPatch:  {Schema: ref("JSONPatch")},
+				mediaTypeMergePatch: {Schema: &JSONSchema{Type: "object"}},
+				mediaTypeJSON:       {Schema: &JSONSchema{Type: "object"}},
+				mediaTypeYAML:       {Schema: &JSONSchema{Type: "object"}},
+			}},
+			Responses: write,
+		},
+		operationKey(http.MethodDelete, "/apps/{id}"): {
+			OperationID: "deleteApp",
+			Summary:     "Delete an App",
+			Parameters:  []*parameter{idParam},
+			Responses: withErrors(map[int]*response{
+				http.StatusNoContent: {Description: "the App was deleted"},
+			}, http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodPost, "/validate"): {
+			OperationID: "validateApp",
+			Summary:     "Run the validation and the policies of a put request without storing the App",
+			Parameters:  []*parameter{strictParam},
+			RequestBody: &requestBody{Required: true, Content: documents},
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the App is valid", Content: negotiated("ValidationReport")},
+				http.StatusBadRequest: {
+					Description: "the App is invalid, or the request body is not valid json",
+					Content:     content(&JSONSchema{AnyOf: []*JSONSchema{ref("ValidationReport"), ref("Error")}}, mediaTypeJSON, mediaTypeYAML),
+				},
+			}, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodGet, "/schema"): {
+			OperationID: "getSchema",
+			Summary:     "Get the JSON Schema of App in the apiVersion of the prefix",
+			Parameters: []*parameter{{Name: "apiVersion", In: "query", Schema: &JSONSchema{Type: "string"},
+				Description: "apiVersion of the schema on unprefixed paths, " + defaultAPIVersion + " by default"}},
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the JSON Schema", Content: content(&JSONSchema{Type: "object"}, mediaTypeSchemaJSON)},
+			}, http.StatusNotFound, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodPost, "/import"): {
+			OperationID: "importApps",
+			Summary:     "Store every valid App of a multi-document yaml or ndjson stream, and report each document",
+			Parameters:  []*parameter{strictParam},
+			RequestBody: &requestBody{Required: true, Content: content(app, mediaTypeYAML, mediaTypeNDJSON)},
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the result of every document", Content: negotiated("ImportResponse")},
+			}, http.StatusBadRequest, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodGet, "/export"): {
+			OperationID: "exportApps",
+			Summary:     "Stream every App, as multi-document yaml or ndjson",
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "every App", Content: content(app, mediaTypeYAML, mediaTypeNDJSON)},
+			}, http.StatusNotAcceptable),
+		},
+		operationKey(http.MethodPost, "/batch"): {
+			OperationID: "batchApps",
+			Summary:     "Apply create, update and delete operations, all of them or none",
+			Parameters:  []*parameter{strictParam},
+			RequestBody: &requestBody{Required: true, Content: content(ref("BatchRequest"), mediaTypeJSON, mediaTypeYAML)},
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the operations were applied", Content: negotiated("BatchResponse")},
+				http.StatusBadRequest: {
+					Description: "an operation is invalid, nothing was applied, or the request is invalid",
+					Content:     content(&JSONSchema{AnyOf: []*JSONSchema{ref("BatchResponse"), ref("Error")}}, mediaTypeJSON, mediaTypeYAML),
+				},
+			}, http.StatusNotFound, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodGet, "/watch"): {
+			OperationID: "watchApps",
+			Summary:     "Stream the changes of the Apps from the moment of the request",
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "a change per line", Content: content(ref("WatchEvent"), mediaTypeNDJSON)},
+			}, http.StatusNotAcceptable, http.StatusInternalServerError),
+		},
+		operationKey(anyMethod, "/put"): {
+			OperationID: "put",
+			Summary:     "Legacy alias of POST /apps responding 200, accepting any method",
+			Deprecated:  true,
+			Parameters:  []*parameter{strictParam},
+			RequestBody: &requestBody{Required: true, Content: documents},
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the App was stored", Content: negotiated("WriteResponse")},
+			}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError),
+		},
+		operationKey(anyMethod, "/get"): {
+			OperationID: "get",
+			Summary:     "Legacy get of the App whose Id is the request body, or the id parameter, accepting any method",
+			Deprecated:  true,
+			Parameters:  []*parameter{{Name: "id", In: "query", Schema: &JSONSchema{Type: "string"}}},
+			RequestBody: &requestBody{Content: content(&JSONSchema{Type: "string"}, mediaTypeText)},
+			Responses:   get,
+		},
+		operationKey(anyMethod, "/query"): {
+			OperationID: "query",
+			Summary:     "Search the Apps having every field of a partial App document, accepting any method",
+			RequestBody: &requestBody{Required: true, Content: content(&JSONSchema{Type: "object"}, mediaTypeJSON, mediaTypeYAML)},
+			Responses:   search,
+		},
+		operationKey(http.MethodGet, "/graphql"): {
+			OperationID: "graphqlQuery",
+			Summary:     "Run a GraphQL query, mutations are only accepted on POST",
+			Parameters: []*parameter{
+				{Name: "query", In: "query", Required: true, Schema: &JSONSchema{Type: "string"}},
+				{Name: "operationName", In: "query", Schema: &JSONSchema{Type: "string"}},
+				{Name: "variables", In: "query", Schema: &JSONSchema{Type: "string"}, Description: "json object of the variables"},
+			},
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the result, with the GraphQL errors", Content: negotiated("GraphQLResponse")},
+			}, http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotAcceptable, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodPost, "/graphql"): {
+			OperationID: "graphql",
+			Summary:     "Run a GraphQL query or mutation",
+			RequestBody: &requestBody{Required: true, Content: content(ref("GraphQLRequest"), mediaTypeJSON)},
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the result, with the GraphQL errors", Content: negotiated("GraphQLResponse")},
+			}, http.StatusBadRequest, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodGet, "/openapi.json"): {
+			OperationID: "getOpenAPI",
+			Summary:     "Get this OpenAPI document",
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the OpenAPI document", Content: content(&JSONSchema{Type: "object"}, mediaTypeJSON)},
+			}, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodGet, "/audit"): {
+			OperationID: "queryAudit",
+			Summary:     "Get the most recent records of the audit log matching the parameters, oldest first",
+			Parameters: []*parameter{
+				{Name: "principal", In: "query", Schema: &JSONSchema{Type: "string"}, Description: "name of the principal, - for anonymous writes"},
+				{Name: "action", In: "query", Schema: &JSONSchema{Type: "string", Pattern: fmt.Sprintf("^(%s|%s|%s)$", VerbCreate, VerbUpdate, VerbDelete)}},
+				{Name: "namespace", In: "query", Schema: &JSONSchema{Type: "string"}},
+				{Name: "id", In: "query", Schema: &JSONSchema{Type: "string"}},
+				{Name: "since", In: "query", Schema: &JSONSchema{Type: "string", Format: "date-time"}},
+				{Name: "until", In: "query", Schema: &JSONSchema{Type: "string", Format: "date-time"}, Description: "excluded"},
+				{Name: "limit", In: "query", Schema: &JSONSchema{Type: "integer"}, Description: fmt.Sprintf("%d by default, at most %d", defaultAuditLimit, maxAuditLimit)},
+			},
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the records", Content: lists("AuditResponse", ref("AuditRecord"))},
+			}, http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusNotAcceptable, http.StatusInternalServerError),
+		},
+		operationKey(http.MethodGet, "/docs"): {
+			OperationID: "getDocs",
+			Summary:     "Browse this OpenAPI document with Swagger UI, when the server has a Swagger UI directory",
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the Swagger UI page", Content: content(&JSONSchema{Type: "string"}, mediaTypeHTML)},
+			}, http.StatusNotFound),
+		},
+		operationKey(http.MethodGet, "/docs/{asset}"): {
+			OperationID: "getDocsAsset",
+			Summary:     "Get a file of the Swagger UI release of the server",
+			Parameters: []*parameter{{Name: "asset", In: "path", Required: true,
+				Schema: &JSONSchema{Type: "string"}, Description: "swagger-ui.css or swagger-ui-bundle.js"}},
+			Responses: withErrors(map[int]*response{
+				http.StatusOK: {Description: "the file", Content: content(&JSONSchema{Type: "string"}, mediaTypeCSS, mediaTypeJavaScript)},
+			}, http.StatusNotFound, http.StatusInternalServerError),
+		},
+	}
+}
+
+// namespaceParameter is the X-Namespace header, searches may name every namespace
+func namespaceParameter(search bool) *parameter {
+	param := &parameter{Name: namespaceHeader, In: "header", Schema: &JSONSchema{Type: "string", Pattern: namespacePattern.String()},
+		Description: "namespace of the Apps, " + cache.DefaultNamespace + " when not set"}
+	if search {
+		param.Schema.Pattern = `^(\*|` + namespaceSyntax + `)$`
+		param.Description += ", " + allNamespaces + " searches every namespace and qualifies the Ids by their namespace"
+	}
+	return param
+}
+
+// searchParameters returns the query parameters of a search, the json paths of the searchable fields of t
+// as accepted by searchDocFromQuery
+func searchParameters(t reflect.Type, prefix string) []*parameter {
+	params := make([]*parameter, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := getJSONName(field)
+		if name == "" {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.String:
+			params = append(params, &parameter{Name: prefix + name, In: "query", Schema: &JSONSchema{Type: "string"}})
+		case reflect.Map:
+			if prefix == "" && name == "labels" {
+				params = append(params, &parameter{Name: labelParam, In: "query", Description: "a label as key=value, may be repeated",
+					Schema: &JSONSchema{Type: "array", Items: &JSONSchema{Type: "string"}}})
+			}
+		case reflect.Struct:
+			params = append(params, searchParameters(field.Type, prefix+name+".")...)
+		case reflect.Slice:
+			if field.Type.Elem().Kind() != reflect.Struct {
+				continue
+			}
+			// every value of a list field must match an item
+			for _, p := range searchParameters(field.Type.Elem(), prefix+name+".") {
+				if p.Schema.Type == "string" {
+					p.Schema = &JSONSchema{Type: "array", Items: p.Schema}
+				}
+				params = append(params, p)
+			}
+		}
+	}
+	return params
+}
+
+func ref(name string) *JSONSchema {
+	return &JSONSchema{Ref: componentsPrefix + name}
+}
+
+// content returns the same schema in every media type
+func content(schema *JSONSchema, mediaTypes ...string) map[string]*mediaTypeObject {
+	rs := make(map[string]*mediaTypeObject)
+	for _, mediaType := range mediaTypes {
+		rs[mediaType] = &mediaTypeObject{Schema: schema}
+	}
+	return rs
+}
+
+// negotiated is the content written by writeNegotiated
+func negotiated(component string) map[string]*mediaTypeObject {
+	return content(ref(component), mediaTypeJSON, mediaTypeYAML)
+}
+
+// lists is the content written by writeList, ndjson has a line per item
+func lists(component string, item *JSONSchema) map[string]*mediaTypeObject {
+	rs := negotiated(component)
+	rs[mediaTypeNDJSON] = &mediaTypeObject{Schema: item}
+	return rs
+}
+
+// withErrors adds the Error responses of codes to responses, and keys them by status code
+func withErrors(responses map[int]*response, codes ...int) map[string]*response {
+	for _, code := range codes {
+		if _, ok := responses[code]; !ok {
+			responses[code] = &response{Description: errorDescriptions[code], Content: content(ref("Error"), mediaTypeJSON)}
+		}
+	}
+	rs := make(map[string]*response)
+	for code, resp := range responses {
+		rs[fmt.Sprint(code)] = resp
+	}
+	return rs
+}
+
+// operationKey identifies a route by its method and path pattern, * standing for anyMethod
+func operationKey(method, pattern string) string {
+	if method == anyMethod {
+		method = "*"
+	}
+	return method + " " + pattern
+}
+
+// operationMethod is the method an operation is documented with, the routes of anyMethod being documented as post
+func operationMethod(method string) string {
+	if method == anyMethod {
+		return "post"
+	}
+	return strings.ToLower(method)
+}
+
+// splitVersionPrefix splits a path pattern such as /v2/apps into its apiVersion and the rest of the path
+func splitVersionPrefix(pattern string) (string, string) {
+	segments := splitPath(pattern)
+	if _, ok := scheme[segments[0]]; ok && len(segments) > 1 {
+		return segments[0], "/" + strings.Join(segments[1:], "/")
+	}
+	return "", pattern
+}
+
+func (h *httpServerImpl) OpenAPIHandler(w http.ResponseWriter, req *http.Request) {
+	h.openAPIOnce.Do(func() {
+		doc, err := newOpenAPI(h.routes())
+		if err != nil {
+			h.openAPIErr = err
+			return
+		}
+		h.openAPI, h.openAPIErr = json.MarshalIndent(doc, "", "  ")
+	})
+	if h.openAPIErr != nil {
+		handleInternalError(w, h.openAPIErr, "failed to generate the openapi document")
+		return
+	}
+	w.Header().Set("Content-Type", mediaTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	w.Write(h.openAPI)
+}
+
+// swaggerUIAssets are the files of a swagger-ui-dist release served under /docs/, by media type
+var swaggerUIAssets = map[string]string{
+	"swagger-ui.css":       mediaTypeCSS,
+	"swagger-ui-bundle.js": mediaTypeJavaScript,
+}
+
+// swaggerUIPage loads Swagger UI from the server, which serves the release installed in its Swagger UI directory,
+// so the page runs no code fetched from a third party
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Application metadata API server</title>
+  <link rel="stylesheet" href="/docs/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="/docs/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`
+
+// errSwaggerUINotInstalled answers /docs when the server has no Swagger UI directory
+var errSwaggerUINotInstalled = errors.New("swagger ui is not installed, see -swagger-ui-dir")
+
+func (h *httpServerImpl) SwaggerUIHandler(w http.ResponseWriter, req *http.Request) {
+	if h.swaggerUIDir == "" {
+		handleNotFoundError(w, errSwaggerUINotInstalled)
+		return
+	}
+	w.Header().Set("Content-Type", mediaTypeHTML+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(swaggerUIPage))
+}
+
+func (h *httpServerImpl) SwaggerUIAssetHandler(w http.ResponseWriter, req *http.Request) {
+	if h.swaggerUIDir == "" {
+		handleNotFoundError(w, errSwaggerUINotInstalled)
+		return
+	}
+	name := pathParam(req, "asset")
+	mediaType, ok := swaggerUIAssets[name]
+	if !ok {
+		handleNotFoundError(w, fmt.Errorf("no swagger ui asset %q", name))
+		return
+	}
+	f, err := os.Open(filepath.Join(h.swaggerUIDir, name))
+	if err != nil {
+		handleInternalError(w, err, "failed to open swagger ui asset "+name)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		handleInternalError(w, err, "failed to open swagger ui asset "+name)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	http.ServeContent(w, req, name, info.ModTime(), f)
+}
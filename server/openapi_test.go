@@ -0,0 +1,350 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+func TestNewOpenAPI(t *testing.T) {
+	h := NewHttpServer().(*httpServerImpl)
+	doc, err := newOpenAPI(h.routes())
+	assert.Nil(t, err)
+	for _, rt := range h.routes() {
+		assert.NotNil(t, doc.Paths[rt.pattern][operationMethod(rt.method)], rt.pattern)
+	}
+	assert.Equal(t, "createApp_v2", doc.Paths["/v2/apps"]["post"].OperationID)
+	assert.Equal(t, componentsPrefix+"App.v2", doc.Paths["/v2/apps"]["post"].RequestBody.Content[mediaTypeJSON].Schema.Ref)
+	assert.True(t, doc.Paths["/put"]["post"].Deprecated)
+
+	// every reference resolves
+	data, err := json.Marshal(doc)
+	assert.Nil(t, err)
+	for _, part := range strings.Split(string(data), `"$ref":"`+componentsPrefix)[1:] {
+		name := part[:strings.Index(part, `"`)]
+		assert.Contains(t, doc.Components.Schemas, name)
+	}
+
+	_, err = newOpenAPI(append(h.routes(), &route{method: http.MethodGet, pattern: "/v2/undocumented"}))
+	assert.EqualError(t, err, "route GET /v2/undocumented is not documented")
+
+	routes := make([]*route, 0)
+	for _, rt := range h.routes() {
+		if !strings.HasSuffix(rt.pattern, "/watch") {
+			routes = append(routes, rt)
+		}
+	}
+	_, err = newOpenAPI(routes)
+	assert.EqualError(t, err, "operation GET /watch is documented but not served")
+}
+
+// swaggerUIDir returns a directory with the files of a fake Swagger UI release
+func swaggerUIDir(t *testing.T) string {
+	dir := t.TempDir()
+	for name := range swaggerUIAssets {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("/* "+name+" */"), 0644))
+	}
+	return dir
+}
+
+func TestHttpServerImpl_OpenAPIHandler(t *testing.T) {
+	handler := NewHttpServer(WithSwaggerUIDir(swaggerUIDir(t))).Handler()
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, mediaTypeJSON, rr.Header().Get("Content-Type"))
+	doc := &openAPIDoc{}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), doc))
+	assert.Equal(t, openAPIVersion, doc.OpenAPI)
+	assert.Contains(t, doc.Paths, "/v1/apps/{id}")
+	assert.Equal(t, "object", doc.Components.Schemas["App.v1"].Type)
+
+	req = httptest.NewRequest(http.MethodGet, "/docs", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `url: "/openapi.json"`)
+	assert.NotContains(t, rr.Body.String(), "https://")
+
+	req = httptest.NewRequest(http.MethodGet, "/docs/swagger-ui-bundle.js", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/javascript; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "/* swagger-ui-bundle.js */", rr.Body.String())
+	for _, path := range []string{"/docs/index.html", "/docs/..%2Fopenapi.go"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code, path)
+	}
+
+	// without a Swagger UI directory
+	handler = NewHttpServer().Handler()
+	for _, path := range []string{"/docs", "/docs/swagger-ui.css"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code, path)
+	}
+}
+
+// contract sends requests to the handler and checks every response against the OpenAPI document
+type contract struct {
+	t         *testing.T
+	handler   http.Handler
+	doc       *openAPIDoc
+	exercised map[string]bool
+}
+
+// do sends a request, checks the status, content and headers of the response are documented
+// by the operation of the request, and returns the response body
+func (c *contract) do(method, target, contentType, accept string, body string, status int) []byte {
+	return c.doContext(context.Background(), method, target, contentType, accept, body, status)
+}
+
+func (c *contract) doContext(ctx context.Context, method, target, contentType, accept string, body string, status int) []byte {
+	t := c.t
+	req := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	c.handler.ServeHTTP(rr, req)
+	name := fmt.Sprintf("%s %s", method, target)
+	assert.Equal(t, status, rr.Code, "%s: %s", name, rr.Body.String())
+
+	pattern, op := c.operation(req)
+	if !assert.NotNil(t, op, "%s is not documented", name) {
+		return nil
+	}
+	c.exercised[operationKey(method, pattern)] = true
+	resp, ok := op.Responses[strconv.Itoa(rr.Code)]
+	if !assert.True(t, ok, "%s: status %d is not documented", name, rr.Code) {
+		return nil
+	}
+	for key := range resp.Headers {
+		assert.NotEmpty(t, rr.Header().Get(key), "%s: header %s is missing", name, key)
+	}
+	if len(resp.Content) == 0 {
+		assert.Empty(t, rr.Body.String(), "%s: undocumented body", name)
+		return rr.Body.Bytes()
+	}
+	mediaType, _, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	assert.Nil(t, err, name)
+	content, ok := resp.Content[mediaType]
+	if !assert.True(t, ok, "%s: %s of status %d is not documented", name, mediaType, rr.Code) {
+		return nil
+	}
+	assert.Nil(t, validateBody(c.resolve(content.Schema), mediaType, rr.Body.Bytes()), "%s: %s", name, rr.Body.String())
+	return rr.Body.Bytes()
+}
+
+// operation returns the documented path and operation of a request
+func (c *contract) operation(req *http.Request) (string, *operation) {
+	for pattern, item := range c.doc.Paths {
+		rt := &route{segments: splitPath(pattern)}
+		if _, ok := rt.match(splitPath(req.URL.Path)); ok {
+			return pattern, item[strings.ToLower(req.Method)]
+		}
+	}
+	return "", nil
+}
+
+// resolve returns a copy of a schema with its references replaced by the components
+func (c *contract) resolve(s *JSONSchema) *JSONSchema {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		return c.resolve(c.doc.Components.Schemas[strings.TrimPrefix(s.Ref, componentsPrefix)])
+	}
+	rs := *s
+	if s.Properties != nil {
+		rs.Properties = make(map[string]*JSONSchema)
+		for name, p := range s.Properties {
+			rs.Properties[name] = c.resolve(p)
+		}
+	}
+	rs.Items = c.resolve(s.Items)
+	if additional, ok := s.AdditionalProperties.(*JSONSchema); ok {
+		rs.AdditionalProperties = c.resolve(additional)
+	}
+	rs.AnyOf = nil
+	for _, alt := range s.AnyOf {
+		rs.AnyOf = append(rs.AnyOf, c.resolve(alt))
+	}
+	return &rs
+}
+
+// validateBody validates a body against its schema, every line of ndjson and every document of yaml
+func validateBody(schema *JSONSchema, mediaType string, body []byte) error {
+	docs := make([][]byte, 0)
+	switch mediaType {
+	case mediaTypeNDJSON:
+		for _, line := range bytes.Split(body, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				docs = append(docs, line)
+			}
+		}
+	case mediaTypeYAML:
+		reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(body)))
+		for {
+			doc, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+		}
+	case mediaTypeHTML, mediaTypeText, mediaTypeCSS, mediaTypeJavaScript:
+		return nil
+	default:
+		docs = append(docs, body)
+	}
+	for _, doc := range docs {
+		if err := schema.ValidateDocument(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestOpenAPI_Contract exercises every documented operation, and fails when a response is not documented
+// or an operation is not exercised
+func TestOpenAPI_Contract(t *testing.T) {
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+	assert.Nil(t, err)
+	defer audit.Close()
+	h := NewHttpServer(WithAuditLog(audit), WithSwaggerUIDir(swaggerUIDir(t))).(*httpServerImpl)
+	doc, err := newOpenAPI(h.routes())
+	assert.Nil(t, err)
+	c := &contract{t: t, handler: h.Handler(), doc: doc, exercised: make(map[string]bool)}
+
+	v1Doc, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	v2Doc, err := ioutil.ReadFile("../testdata/valid-payload3-v2.yaml")
+	assert.Nil(t, err)
+	invalidDoc, err := ioutil.ReadFile("../testdata/invalid-payload1.yaml")
+	assert.Nil(t, err)
+
+	for _, prefix := range []string{"", "/v1", "/v2"} {
+		payload := string(v1Doc)
+		if prefix == "/v2" {
+			payload = string(v2Doc)
+		}
+		jsonPayload, err := yaml.YAMLToJSON([]byte(payload))
+		assert.Nil(t, err)
+
+		body := c.do(http.MethodPost, prefix+"/apps", mediaTypeYAML, "", payload, http.StatusCreated)
+		created := &WriteResponse{}
+		assert.Nil(t, json.Unmarshal(body, created))
+		id := string(created.Id)
+		c.do(http.MethodPost, prefix+"/apps?dryRun=true", mediaTypeYAML, mediaTypeYAML, payload, http.StatusOK)
+		c.do(http.MethodPost, prefix+"/apps?dryRun=true", mediaTypeYAML, "", string(invalidDoc), http.StatusBadRequest)
+		c.do(http.MethodPost, prefix+"/apps", mediaTypeYAML, "", string(invalidDoc), http.StatusBadRequest)
+		c.do(http.MethodPost, prefix+"/apps", "application/xml", "", payload, http.StatusUnsupportedMediaType)
+		c.do(http.MethodPost, prefix+"/apps", mediaTypeYAML, "text/csv", payload, http.StatusNotAcceptable)
+
+		c.do(http.MethodGet, prefix+"/apps?title=Valid&label=env=prod", "", "", "", http.StatusOK)
+		c.do(http.MethodGet, prefix+"/apps", "", mediaTypeNDJSON, "", http.StatusOK)
+		c.do(http.MethodGet, prefix+"/apps?unknown=x", "", "", "", http.StatusBadRequest)
+
+		c.do(http.MethodGet, prefix+"/apps/"+id, "", mediaTypeJSON, "", http.StatusOK)
+		c.do(http.MethodGet, prefix+"/apps/"+id, "", mediaTypeYAML, "", http.StatusOK)
+		c.do(http.MethodGet, prefix+"/apps/999", "", "", "", http.StatusNotFound)
+		c.do(http.MethodGet, prefix+"/apps/"+id, "", "text/csv", "", http.StatusNotAcceptable)
+
+		c.do(http.MethodPut, prefix+"/apps/"+id, mediaTypeJSON, "", string(jsonPayload), http.StatusOK)
+		c.do(http.MethodPut, prefix+"/apps/999", mediaTypeJSON, "", string(jsonPayload), http.StatusNotFound)
+		c.do(http.MethodPut, prefix+"/apps/"+id, mediaTypeJSON, "", "{", http.StatusBadRequest)
+
+		c.do(http.MethodPatch, prefix+"/apps/"+id, mediaTypeMergePatch, "", `{"description":"patched"}`, http.StatusOK)
+		c.do(http.MethodPatch, prefix+"/apps/"+id, mediaTypeJSONPatch, "",
+			`[{"op":"replace","path":"/description","value":"patched again"}]`, http.StatusOK)
+		c.do(http.MethodPatch, prefix+"/apps/"+id, mediaTypeJSONPatch, "",
+			`[{"op":"test","path":"/description","value":"other"}]`, http.StatusBadRequest)
+
+		c.do(http.MethodPost, prefix+"/validate", mediaTypeYAML, "", payload, http.StatusOK)
+		c.do(http.MethodPost, prefix+"/validate", mediaTypeYAML, "", string(invalidDoc), http.StatusBadRequest)
+		c.do(http.MethodPost, prefix+"/validate", mediaTypeJSON, "", "{", http.StatusBadRequest)
+
+		c.do(http.MethodGet, prefix+"/schema", "", "", "", http.StatusOK)
+
+		c.do(http.MethodPost, prefix+"/import", mediaTypeYAML, "", payload+"\n---\n"+string(invalidDoc), http.StatusOK)
+		c.do(http.MethodPost, prefix+"/import", mediaTypeNDJSON, mediaTypeYAML, string(jsonPayload)+"\n", http.StatusOK)
+		c.do(http.MethodPost, prefix+"/import", "application/xml", "", payload, http.StatusUnsupportedMediaType)
+
+		c.do(http.MethodGet, prefix+"/export", "", "", "", http.StatusOK)
+		c.do(http.MethodGet, prefix+"/export", "", mediaTypeNDJSON, "", http.StatusOK)
+		c.do(http.MethodGet, prefix+"/export", "", mediaTypeJSON, "", http.StatusNotAcceptable)
+
+		c.do(http.MethodPost, prefix+"/batch", mediaTypeJSON, "",
+			fmt.Sprintf(`{"operations":[{"op":"create","document":%s},{"op":"update","id":"%s","document":%s}]}`, jsonPayload, id, jsonPayload),
+			http.StatusOK)
+		c.do(http.MethodPost, prefix+"/batch", mediaTypeJSON, "", `{"operations":[{"op":"create"}]}`, http.StatusBadRequest)
+		c.do(http.MethodPost, prefix+"/batch", mediaTypeJSON, "", `{"operations":[{"op":"delete","id":"999"}]}`, http.StatusNotFound)
+		c.do(http.MethodPost, prefix+"/batch", mediaTypeJSON, "", "{", http.StatusBadRequest)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		c.doContext(ctx, http.MethodGet, prefix+"/watch", "", "", "", http.StatusOK)
+		cancel()
+		c.do(http.MethodGet, prefix+"/watch", "", mediaTypeJSON, "", http.StatusNotAcceptable)
+
+		c.do(http.MethodPost, prefix+"/put", "", "", payload, http.StatusOK)
+		c.do(http.MethodPost, prefix+"/get", "", "", id, http.StatusOK)
+		c.do(http.MethodPost, prefix+"/get?id="+id, "", mediaTypeJSON, "", http.StatusOK)
+		c.do(http.MethodPost, prefix+"/get", "", "", "999", http.StatusNotFound)
+
+		c.do(http.MethodDelete, prefix+"/apps/"+id, "", "", "", http.StatusNoContent)
+		c.do(http.MethodDelete, prefix+"/apps/"+id, "", "", "", http.StatusNotFound)
+	}
+
+	c.do(http.MethodGet, "/schema?apiVersion=v9", "", "", "", http.StatusNotFound)
+	c.do(http.MethodPost, "/query", "", "", "title: Valid", http.StatusOK)
+	c.do(http.MethodPost, "/query", "", "", "title: [", http.StatusBadRequest)
+	c.do(http.MethodPost, "/graphql", mediaTypeJSON, "", `{"query":"{ apps { title maintainers { email } } }"}`, http.StatusOK)
+	c.do(http.MethodPost, "/graphql", mediaTypeJSON, "", `{"query":"{ app(id: \"999\") { title } }"}`, http.StatusOK)
+	c.do(http.MethodPost, "/graphql", mediaTypeYAML, "", `query: "{ apps { title } }"`, http.StatusUnsupportedMediaType)
+	c.do(http.MethodGet, "/graphql?query="+"%7B%20apps%20%7B%20title%20%7D%20%7D", "", "", "", http.StatusOK)
+	c.do(http.MethodGet, "/graphql?query="+"mutation%20%7B%20deleteApp(id%3A%20%221%22)%20%7D", "", "", "", http.StatusMethodNotAllowed)
+	c.do(http.MethodGet, "/graphql", "", "", "", http.StatusBadRequest)
+	c.do(http.MethodGet, "/openapi.json", "", "", "", http.StatusOK)
+	c.do(http.MethodGet, "/docs", "", "", "", http.StatusOK)
+	c.do(http.MethodGet, "/docs/swagger-ui.css", "", "", "", http.StatusOK)
+	c.do(http.MethodGet, "/docs/swagger-ui.js", "", "", "", http.StatusNotFound)
+	c.do(http.MethodGet, "/audit?action=update&limit=5", "", "", "", http.StatusOK)
+	c.do(http.MethodGet, "/audit", "", mediaTypeNDJSON, "", http.StatusOK)
+	c.do(http.MethodGet, "/audit?since=yesterday", "", "", "", http.StatusBadRequest)
+
+	for pattern, item := range doc.Paths {
+		for method := range item {
+			key := operationKey(strings.ToUpper(method), pattern)
+			assert.True(t, c.exercised[key], "%s is not exercised by the contract test", key)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// mediaTypeMergePatch is the Content-Type of RFC 7386 JSON merge patches
+const mediaTypeMergePatch = "application/merge-patch+json"
+
+// applyMergePatch applies an RFC 7386 JSON merge patch (in json or yaml) to a stored yaml or json document.
+// The patched document keeps the format of the stored one.
+func applyMergePatch(raw []byte, patch []byte) ([]byte, error) {
+	target, err := decodeGeneric(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored document: %w", err)
+	}
+	if err := checkDocument(patch); err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	patchDoc, err := decodeGeneric(patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	return encodeLike(raw, mergePatch(target, patchDoc))
+}
+
+// mergePatch merges patch into target following RFC 7386: objects are merged recursively,
+// null removes a member and any other value replaces the target
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergePatch(targetObj[k], v)
+	}
+	return targetObj
+}
+
+// decodeGeneric decodes a yaml or json document into json compatible maps and slices
+func decodeGeneric(doc []byte) (interface{}, error) {
+	jsonData, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	var rs interface{}
+	if err := json.Unmarshal(jsonData, &rs); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// encodeLike encodes a generic document in json if like is a json document, in yaml otherwise
+func encodeLike(like []byte, doc interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if isJSON(like) {
+		return jsonData, nil
+	}
+	return yaml.JSONToYAML(jsonData)
+}
+
+// isJSON tells if a document is a json object rather than yaml
+func isJSON(doc []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(doc), []byte("{"))
+}
+
+// mediaTypeJSONPatch is the Content-Type of RFC 6902 JSON patches
+const mediaTypeJSONPatch = "application/json-patch+json"
+
+// jsonPatchOp is a single operation of an RFC 6902 JSON patch
+type jsonPatchOp struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from,omitempty"`
+	// Value is kept raw to tell a missing value from null
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyJSONPatch applies an RFC 6902 JSON patch (in json or yaml) to a stored yaml or json document.
+// Operations are applied in order and the whole patch fails if any operation fails.
+// The patched document keeps the format of the stored one.
+func applyJSONPatch(raw []byte, patch []byte) ([]byte, error) {
+	doc, err := decodeGeneric(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored document: %w", err)
+	}
+	if err := checkDocument(patch); err != nil {
+		return nil, fmt.Errorf("invalid json patch: %w", err)
+	}
+	patchJSON, err := yaml.YAMLToJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json patch: %w", err)
+	}
+	ops := make([]jsonPatchOp, 0)
+	if err := json.Unmarshal(patchJSON, &ops); err != nil {
+		return nil, fmt.Errorf("invalid json patch: %w", err)
+	}
+	for i, op := range ops {
+		if doc, err = op.apply(doc); err != nil {
+			return nil, fmt.Errorf("json patch operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return encodeLike(raw, doc)
+}
+
+func (op *jsonPatchOp) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("value is required")
+		}
+		var value interface{}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return patchAdd(doc, path, value)
+		case "replace":
+			return patchReplace(doc, path, value)
+		}
+		current, err := patchGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("test failed, value is %v", current)
+		}
+		return doc, nil
+	case "remove":
+		doc, _, err = patchRemove(doc, path)
+		return doc, err
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("cannot move %s into its own child", op.From)
+			}
+			var value interface{}
+			if doc, value, err = patchRemove(doc, from); err != nil {
+				return nil, err
+			}
+			return patchAdd(doc, path, value)
+		}
+		value, err := patchGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		// copy the value so later operations do not change both locations
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		var copied interface{}
+		if err := json.Unmarshal(data, &copied); err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, path, copied)
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("json pointer %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, tokens []string) bool {
+	if len(prefix) > len(tokens) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != tokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func patchGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		var err error
+		if doc, err = getChild(doc, token); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func patchAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[key] = value
+			return p, nil
+		case []interface{}:
+			i := len(p)
+			if key != "-" {
+				var err error
+				if i, err = arrayIndex(key, len(p)+1); err != nil {
+					return nil, err
+				}
+			}
+			rs := make([]interface{}, 0, len(p)+1)
+			rs = append(rs, p[:i]...)
+			rs = append(rs, value)
+			return append(rs, p[i:]...), nil
+		}
+		return nil, fmt.Errorf("cannot add %q to a scalar", key)
+	})
+}
+
+func patchReplace(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		if _, err := getChild(parent, key); err != nil {
+			return nil, err
+		}
+		return setChild(parent, key, value)
+	})
+}
+
+// patchRemove removes the value at path, and returns the new document and the removed value
+func patchRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	var removed interface{}
+	doc, err := updateParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		value, err := getChild(parent, key)
+		if err != nil {
+			return nil, err
+		}
+		removed = value
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			delete(p, key)
+			return p, nil
+		case []interface{}:
+			i, _ := arrayIndex(key, len(p))
+			rs := make([]interface{}, 0, len(p)-1)
+			rs = append(rs, p[:i]...)
+			return append(rs, p[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove %q from a scalar", key)
+	})
+	return doc, removed, err
+}
+
+// updateParent calls fn on the container of the last token of path, and returns the document with the
+// container fn returned, as slices may be reallocated
+func updateParent(doc interface{}, path []string, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	child, err := getChild(doc, path[0])
+	if err != nil {
+		return nil, err
+	}
+	newChild, err := updateParent(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	return setChild(doc, path[0], newChild)
+}
+
+func getChild(doc interface{}, key string) (interface{}, error) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		value, ok := d[key]
+		if !ok {
+			return nil, fmt.Errorf("member %q not found", key)
+		}
+		return value, nil
+	case []interface{}:
+		i, err := arrayIndex(key, len(d))
+		if err != nil {
+			return nil, err
+		}
+		return d[i], nil
+	}
+	return nil, fmt.Errorf("cannot get %q from a scalar", key)
+}
+
+func setChild(doc interface{}, key string, value interface{}) (interface{}, error) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		d[key] = value
+		return d, nil
+	case []interface{}:
+		i, err := arrayIndex(key, len(d))
+		if err != nil {
+			return nil, err
+		}
+		d[i] = value
+		return d, nil
+	}
+	return nil, fmt.Errorf("cannot set %q on a scalar", key)
+}
+
+// arrayIndex parses an array index token, which must be lower than size
+func arrayIndex(key string, size int) (int, error) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || (len(key) > 1 && key[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", key)
+	}
+	if i >= size {
+		return 0, fmt.Errorf("array index %d out of bounds", i)
+	}
+	return i, nil
+}
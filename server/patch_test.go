@@ -0,0 +1,137 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		patch         string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "replace and add members of a yaml document",
+			raw:      "title: t1\nlabels:\n  env: dev\n",
+			patch:    `{"title":"t2","labels":{"team":"payments"}}`,
+			expected: "labels:\n  env: dev\n  team: payments\ntitle: t2\n",
+		},
+		{
+			name:     "null removes a member",
+			raw:      "title: t1\nlabels:\n  env: dev\n",
+			patch:    "labels:\n  env: null\n",
+			expected: "labels: {}\ntitle: t1\n",
+		},
+		{
+			name:     "arrays are replaced, json stays json",
+			raw:      `{"maintainers":[{"name":"a"},{"name":"b"}]}`,
+			patch:    `{"maintainers":[{"name":"c"}]}`,
+			expected: `{"maintainers":[{"name":"c"}]}`,
+		},
+		{
+			name:          "invalid patch",
+			raw:           "title: t1\n",
+			patch:         "{",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rs, err := applyMergePatch([]byte(test.raw), []byte(test.patch))
+			if test.expectedError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, test.expected, string(rs))
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	testCases := []struct {
+		name          string
+		raw           string
+		patch         string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "add, replace and remove members of a yaml document",
+			raw:      "title: t1\nversion: v1\nlabels:\n  env: dev\n",
+			patch:    `[{"op":"add","path":"/labels/team","value":"payments"},{"op":"replace","path":"/title","value":"t2"},{"op":"remove","path":"/version"}]`,
+			expected: "labels:\n  env: dev\n  team: payments\ntitle: t2\n",
+		},
+		{
+			name:     "yaml patch, array insert and append",
+			raw:      `{"maintainers":[{"name":"a"}]}`,
+			patch:    "- op: add\n  path: /maintainers/0\n  value: {name: b}\n- op: add\n  path: /maintainers/-\n  value: {name: c}\n",
+			expected: `{"maintainers":[{"name":"b"},{"name":"a"},{"name":"c"}]}`,
+		},
+		{
+			name:     "move, copy and escaped pointers",
+			raw:      `{"labels":{"a/b":"x","c~d":"y"},"title":"t"}`,
+			patch:    `[{"op":"move","from":"/labels/a~1b","path":"/labels/e"},{"op":"copy","from":"/labels/c~0d","path":"/company"}]`,
+			expected: `{"company":"y","labels":{"c~d":"y","e":"x"},"title":"t"}`,
+		},
+		{
+			name:     "remove an array item",
+			raw:      `{"maintainers":[{"name":"a"},{"name":"b"}]}`,
+			patch:    `[{"op":"remove","path":"/maintainers/0"}]`,
+			expected: `{"maintainers":[{"name":"b"}]}`,
+		},
+		{
+			name:          "failed test fails the whole patch",
+			raw:           "title: t1\n",
+			patch:         `[{"op":"replace","path":"/title","value":"t2"},{"op":"test","path":"/title","value":"t1"}]`,
+			expectedError: true,
+		},
+		{
+			name:          "replace a missing member",
+			raw:           "title: t1\n",
+			patch:         `[{"op":"replace","path":"/version","value":"v1"}]`,
+			expectedError: true,
+		},
+		{
+			name:          "array index out of bounds",
+			raw:           `{"maintainers":[{"name":"a"}]}`,
+			patch:         `[{"op":"add","path":"/maintainers/2","value":{"name":"b"}}]`,
+			expectedError: true,
+		},
+		{
+			name:          "move into its own child",
+			raw:           `{"labels":{"a":"x"}}`,
+			patch:         `[{"op":"move","from":"/labels","path":"/labels/b"}]`,
+			expectedError: true,
+		},
+		{
+			name:          "unknown op",
+			raw:           "title: t1\n",
+			patch:         `[{"op":"merge","path":"/title","value":"t2"}]`,
+			expectedError: true,
+		},
+		{
+			name:          "not an array of operations",
+			raw:           "title: t1\n",
+			patch:         `{"title":"t2"}`,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rs, err := applyJSONPatch([]byte(test.raw), []byte(test.patch))
+			if test.expectedError {
+				assert.NotNil(t, err)
+				t.Log(err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, test.expected, string(rs))
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// PolicyMode is what happens to an App that violates a policy
+type PolicyMode string
+
+const (
+	// PolicyEnforce rejects the App
+	PolicyEnforce PolicyMode = "enforce"
+	// PolicyWarn accepts the App and returns the violation as a warning
+	PolicyWarn PolicyMode = "warn"
+	// PolicyAudit accepts the App and only logs the violation
+	PolicyAudit PolicyMode = "audit"
+)
+
+// PolicyFile is the declarative rules file loaded by the PolicyEngine, e.g.
+//
+//	policies:
+//	  - name: approved-licenses
+//	    mode: enforce
+//	    rule:
+//	      field: license
+//	      allowedValues: [Apache-2.0, MIT]
+//	  - name: prod-labels
+//	    mode: warn
+//	    match:
+//	      labels:
+//	        env: prod
+//	    rule:
+//	      requiredLabels: [team, tier]
+type PolicyFile struct {
+	Policies []Policy `json:"policies"`
+}
+
+// Policy is a single admission rule, applied to the Apps selected by Match
+type Policy struct {
+	Name  string      `json:"name"`
+	Mode  PolicyMode  `json:"mode"`
+	Match PolicyMatch `json:"match,omitempty"`
+	Rule  PolicyRule  `json:"rule"`
+}
+
+// PolicyMatch selects the Apps a policy applies to, an empty match selects every App
+type PolicyMatch struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// PolicyRule is the condition an App must satisfy, exactly one kind of check has to be set
+type PolicyRule struct {
+	// Field is the dot separated json path checked by AllowedValues and AllowedDomains, e.g. "release.author.email"
+	Field string `json:"field,omitempty"`
+	// AllowedValues lists the accepted values of Field, compared case-insensitively
+	AllowedValues []string `json:"allowedValues,omitempty"`
+	// AllowedDomains lists the accepted domains (and their subdomains) of the url or email in Field
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+	// RequiredLabels lists the label keys an App must have
+	RequiredLabels []string `json:"requiredLabels,omitempty"`
+}
+
+// PolicyViolation describes an App not satisfying a policy
+type PolicyViolation struct {
+	Policy  string     `json:"policy"`
+	Mode    PolicyMode `json:"mode"`
+	Field   string     `json:"field,omitempty"`
+	Message string     `json:"message"`
+}
+
+// PolicyEngine evaluates organisation-wide policies on Apps that already passed validation
+type PolicyEngine interface {
+	// Evaluate returns the violations of every policy matching the App
+	Evaluate(app *api.App) []PolicyViolation
+}
+
+// FilePolicyEngine is a PolicyEngine backed by a rules file, reloaded when the file changes
+type FilePolicyEngine struct {
+	lock     sync.RWMutex
+	path     string
+	modTime  time.Time
+	policies []Policy
+}
+
+// LoadPolicyEngine reads the rules file at path
+func LoadPolicyEngine(path string) (*FilePolicyEngine, error) {
+	e := &FilePolicyEngine{path: path}
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Watch polls the rules file every interval and reloads it when it is modified, until stop is closed.
+// A file that fails to load is logged and the previous policies are kept.
+func (e *FilePolicyEngine) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(e.path)
+			if err != nil {
+				log.Errorf("failed to stat policy file %s: %+v", e.path, err)
+				continue
+			}
+			e.lock.RLock()
+			modified := !info.ModTime().Equal(e.modTime)
+			e.lock.RUnlock()
+			if !modified {
+				continue
+			}
+			if err := e.reload(); err != nil {
+				log.Errorf("failed to reload policy file %s, keeping previous policies: %+v", e.path, err)
+				continue
+			}
+			log.Infof("Reloaded policy file %s", e.path)
+		}
+	}
+}
+
+func (e *FilePolicyEngine) reload() error {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+	file := &PolicyFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return err
+	}
+	for i, p := range file.Policies {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("policies[%d]: %w", i, err)
+		}
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.policies = file.Policies
+	e.modTime = info.ModTime()
+	return nil
+}
+
+func (p *Policy) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch p.Mode {
+	case PolicyEnforce, PolicyWarn, PolicyAudit:
+	default:
+		return fmt.Errorf("%s: mode must be one of %s, %s, %s", p.Name, PolicyEnforce, PolicyWarn, PolicyAudit)
+	}
+	checks := 0
+	for _, set := range []bool{len(p.Rule.AllowedValues) > 0, len(p.Rule.AllowedDomains) > 0, len(p.Rule.RequiredLabels) > 0} {
+		if set {
+			checks++
+		}
+	}
+	if checks != 1 {
+		return fmt.Errorf("%s: rule must have exactly one of allowedValues, allowedDomains, requiredLabels", p.Name)
+	}
+	if len(p.Rule.RequiredLabels) == 0 && p.Rule.Field == "" {
+		return fmt.Errorf("%s: rule field is required", p.Name)
+	}
+	return nil
+}
+
+func (e *FilePolicyEngine) Evaluate(app *api.App) []PolicyViolation {
+	e.lock.RLock()
+	policies := e.policies
+	e.lock.RUnlock()
+
+	rs := make([]PolicyViolation, 0)
+	unstructured, err := runtime.DefaultUnstructuredConverter.ToUnstructured(app)
+	if err != nil {
+		log.Errorf("failed to convert app for policy evaluation: %+v", err)
+		return rs
+	}
+	for _, p := range policies {
+		if !p.Match.matches(app) {
+			continue
+		}
+		for _, msg := range p.Rule.check(app, unstructured) {
+			rs = append(rs, PolicyViolation{
+				Policy:  p.Name,
+				Mode:    p.Mode,
+				Field:   p.Rule.Field,
+				Message: msg,
+			})
+		}
+	}
+	return rs
+}
+
+func (m *PolicyMatch) matches(app *api.App) bool {
+	for k, v := range m.Labels {
+		if app.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// check returns a message for every value of the App breaking the rule
+func (r *PolicyRule) check(app *api.App, unstructured map[string]interface{}) []string {
+	rs := make([]string, 0)
+	if len(r.RequiredLabels) > 0 {
+		for _, label := range r.RequiredLabels {
+			if _, ok := app.Labels[label]; !ok {
+				rs = append(rs, fmt.Sprintf("label %q is required", label))
+			}
+		}
+		return rs
+	}
+	for _, value := range lookupValues(unstructured, strings.Split(r.Field, ".")) {
+		switch {
+		case len(r.AllowedValues) > 0 && !containsFold(r.AllowedValues, value):
+			rs = append(rs, fmt.Sprintf("%s %q is not one of %v", r.Field, value, r.AllowedValues))
+		case len(r.AllowedDomains) > 0 && !inDomains(value, r.AllowedDomains):
+			rs = append(rs, fmt.Sprintf("%s %q is not on domain %v", r.Field, value, r.AllowedDomains))
+		}
+	}
+	return rs
+}
+
+// lookupValues returns all string values at a json path, descending into every element of slices
+func lookupValues(obj interface{}, fields []string) []string {
+	if obj == nil {
+		return nil
+	}
+	value := reflect.ValueOf(obj)
+	switch value.Kind() {
+	case reflect.String:
+		if len(fields) == 0 {
+			return []string{value.String()}
+		}
+	case reflect.Slice:
+		rs := make([]string, 0)
+		for i := 0; i < value.Len(); i++ {
+			rs = append(rs, lookupValues(value.Index(i).Interface(), fields)...)
+		}
+		return rs
+	case reflect.Map:
+		if len(fields) > 0 {
+			m, ok := obj.(map[string]interface{})
+			if ok {
+				return lookupValues(m[fields[0]], fields[1:])
+			}
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// inDomains checks the host of a url, or the domain of an email, is one of domains or their subdomain
+func inDomains(value string, domains []string) bool {
+	host := value
+	if i := strings.LastIndex(value, "@"); i >= 0 && !strings.Contains(value, "://") {
+		host = value[i+1:]
+	} else if u, err := url.Parse(value); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	host = strings.ToLower(host)
+	for _, d := range domains {
+		d = strings.ToLower(d)
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPolicies evaluates the policies on an App, logs the audit violations, and splits the rest into
+// the enforced violations rejecting the App and the warnings to return to the client
+func applyPolicies(engine PolicyEngine, app *api.App) ([]PolicyViolation, []Warning) {
+	if engine == nil {
+		return nil, nil
+	}
+	var enforced []PolicyViolation
+	var warnings []Warning
+	for _, v := range engine.Evaluate(app) {
+		switch v.Mode {
+		case PolicyEnforce:
+			enforced = append(enforced, v)
+		case PolicyWarn:
+			warnings = append(warnings, Warning{
+				Field:   v.Field,
+				Message: fmt.Sprintf("policy %s: %s", v.Policy, v.Message),
+			})
+		case PolicyAudit:
+			log.Infof("policy %s audit violation on app %q: %s", v.Policy, app.Title, v.Message)
+		}
+	}
+	return enforced, warnings
+}
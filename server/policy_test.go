@@ -0,0 +1,123 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilePolicyEngine_Evaluate(t *testing.T) {
+	engine, err := LoadPolicyEngine("../testdata/policies.yaml")
+	assert.Nil(t, err)
+
+	testCases := []struct {
+		name             string
+		app              api.App
+		expectedPolicies []string
+	}{
+		{
+			name: "compliant app, no violation",
+			app: api.App{
+				License: "apache-2.0",
+				Website: "https://docs.website.com/app",
+			},
+			expectedPolicies: []string{},
+		},
+		{
+			name: "unapproved license and foreign website",
+			app: api.App{
+				License: "GPL-3.0",
+				Website: "https://example.com",
+			},
+			expectedPolicies: []string{"approved-licenses", "company-website"},
+		},
+		{
+			name: "prod app missing labels",
+			app: api.App{
+				License: "MIT",
+				Website: "https://website.com",
+				Labels:  map[string]string{"env": "prod", "team": "payments"},
+			},
+			expectedPolicies: []string{"prod-labels"},
+		},
+		{
+			name: "non prod app does not need labels",
+			app: api.App{
+				License: "MIT",
+				Website: "https://website.com",
+				Labels:  map[string]string{"env": "dev"},
+			},
+			expectedPolicies: []string{},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			policies := make([]string, 0)
+			for _, v := range engine.Evaluate(&test.app) {
+				policies = append(policies, v.Policy)
+			}
+			assert.Equal(t, test.expectedPolicies, policies)
+		})
+	}
+}
+
+func TestLoadPolicyEngine_Invalid(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "unknown mode",
+			content: "policies:\n  - name: p\n    mode: block\n    rule:\n      requiredLabels: [team]\n",
+		},
+		{
+			name:    "no check in rule",
+			content: "policies:\n  - name: p\n    mode: warn\n    rule:\n      field: license\n",
+		},
+		{
+			name:    "unknown key",
+			content: "policies:\n  - name: p\n    mode: warn\n    rule:\n      requiredLabel: [team]\n",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policies.yaml")
+			assert.Nil(t, ioutil.WriteFile(path, []byte(test.content), 0600))
+			_, err := LoadPolicyEngine(path)
+			assert.NotNil(t, err)
+			t.Log(err)
+		})
+	}
+}
+
+func TestFilePolicyEngine_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("policies: []\n"), 0600))
+	engine, err := LoadPolicyEngine(path)
+	assert.Nil(t, err)
+	app := &api.App{License: "MIT"}
+	assert.Empty(t, engine.Evaluate(app))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go engine.Watch(10*time.Millisecond, stop)
+
+	// an invalid file keeps the previous policies
+	assert.Nil(t, ioutil.WriteFile(path, []byte("policies: [\n"), 0600))
+	assert.Nil(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, engine.Evaluate(app))
+
+	content := "policies:\n  - name: no-mit\n    mode: enforce\n    rule:\n      field: license\n      allowedValues: [Apache-2.0]\n"
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	assert.Nil(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	assert.Eventually(t, func() bool {
+		return len(engine.Evaluate(app)) == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// labelParam is the query parameter matching labels as key=value, e.g. ?label=env=prod
+const labelParam = "label"
+
+// searchDocFromQuery turns search query parameters into a search document of the hub App.
+// Parameters are json paths such as title, maintainers.email or labels.env; parameters on
+// list fields may be repeated.
+func searchDocFromQuery(values url.Values) ([]byte, error) {
+	doc := make(map[string]interface{})
+	for key, vals := range values {
+		if key == labelParam {
+			for _, v := range vals {
+				kv := strings.SplitN(v, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("label %q must be key=value", v)
+				}
+				if err := setSearchField(doc, reflect.TypeOf(api.App{}), []string{"labels", kv[0]}, kv[1:]); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err := setSearchField(doc, reflect.TypeOf(api.App{}), strings.Split(key, "."), vals); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(doc)
+}
+
+func setSearchField(doc map[string]interface{}, t reflect.Type, fields []string, vals []string) error {
+	field, ok := lookupJSONField(t, fields[0])
+	if !ok {
+		return fmt.Errorf("unknown search parameter %q", fields[0])
+	}
+	name := getJSONName(field)
+	switch field.Type.Kind() {
+	case reflect.String:
+		if len(fields) != 1 {
+			return fmt.Errorf("%s has no field %q", name, strings.Join(fields[1:], "."))
+		}
+		if len(vals) != 1 {
+			return fmt.Errorf("%s can only be searched with one value", name)
+		}
+		doc[name] = vals[0]
+	case reflect.Map:
+		if len(fields) != 2 || len(vals) != 1 {
+			return fmt.Errorf("%s must be searched with one value per key, e.g. %s.key=value", name, name)
+		}
+		m, _ := doc[name].(map[string]interface{})
+		if m == nil {
+			m = make(map[string]interface{})
+		}
+		m[fields[1]] = vals[0]
+		doc[name] = m
+	case reflect.Struct:
+		if len(fields) < 2 {
+			return fmt.Errorf("%s must be searched on a nested field, e.g. %s.name", name, name)
+		}
+		m, _ := doc[name].(map[string]interface{})
+		if m == nil {
+			m = make(map[string]interface{})
+		}
+		if err := setSearchField(m, field.Type, fields[1:], vals); err != nil {
+			return err
+		}
+		doc[name] = m
+	case reflect.Slice:
+		if len(fields) < 2 || field.Type.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("%s must be searched on a nested field, e.g. %s.name", name, name)
+		}
+		list, _ := doc[name].([]interface{})
+		for _, v := range vals {
+			m := make(map[string]interface{})
+			if err := setSearchField(m, field.Type.Elem(), fields[1:], []string{v}); err != nil {
+				return err
+			}
+			list = append(list, m)
+		}
+		doc[name] = list
+	default:
+		return fmt.Errorf("%s cannot be searched", name)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func TestSearchDocFromQuery(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         string
+		expectedDoc   string
+		expectedError bool
+	}{
+		{
+			name:        "simple field",
+			query:       "title=Valid+App",
+			expectedDoc: `{"title":"Valid App"}`,
+		},
+		{
+			name:        "nested and list fields",
+			query:       "release.author.email=a@b.com&maintainers.name=bob&maintainers.name=mary",
+			expectedDoc: `{"maintainers":[{"name":"bob"},{"name":"mary"}],"release":{"author":{"email":"a@b.com"}}}`,
+		},
+		{
+			name:        "labels",
+			query:       "label=env=prod&labels.team=payments",
+			expectedDoc: `{"labels":{"env":"prod","team":"payments"}}`,
+		},
+		{
+			name:          "unknown parameter",
+			query:         "licence=MIT",
+			expectedError: true,
+		},
+		{
+			name:          "repeated simple field",
+			query:         "title=a&title=b",
+			expectedError: true,
+		},
+		{
+			name:          "malformed label",
+			query:         "label=env",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := url.ParseQuery(test.query)
+			assert.Nil(t, err)
+			doc, err := searchDocFromQuery(values)
+			if test.expectedError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.JSONEq(t, test.expectedDoc, string(doc))
+			}
+		})
+	}
+}
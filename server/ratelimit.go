@@ -0,0 +1,419 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/yaml"
+)
+
+// maxIdleBuckets is the number of buckets above which the full buckets, of clients idle long enough, are dropped
+const maxIdleBuckets = 10000
+
+// RateLimitFile is the rate limit file loaded by LoadRateLimiter, e.g.
+//
+//	reads:
+//	  rate: 20            # requests per second
+//	  burst: 40
+//	writes:
+//	  rate: 2
+//	  burst: 10
+//	  dailyQuota: 1000    # requests per UTC day
+//	clients:
+//	  - client: ci
+//	    reads:
+//	      rate: 100
+//	      burst: 200
+type RateLimitFile struct {
+	Reads  RateLimit `json:"reads,omitempty"`
+	Writes RateLimit `json:"writes,omitempty"`
+	// Clients replace the limits of some clients, by principal name or ip address
+	Clients []ClientRateLimits `json:"clients,omitempty"`
+	// ForwardedFor keys the anonymous requests by the first address of their X-Forwarded-For header rather than
+	// their remote address, only set it behind a proxy setting the header
+	ForwardedFor bool `json:"forwardedFor,omitempty"`
+}
+
+// RateLimit is a token bucket, and a daily quota, of the requests of a client
+type RateLimit struct {
+	// Rate is the number of requests per second refilling the bucket, 0 is unlimited
+	Rate float64 `json:"rate,omitempty"`
+	// Burst is the size of the bucket, the number of requests accepted at once, the rate rounded up by default
+	Burst int `json:"burst,omitempty"`
+	// DailyQuota is the number of requests per UTC day, 0 is unlimited
+	DailyQuota int `json:"dailyQuota,omitempty"`
+}
+
+// ClientRateLimits are the limits of a client, the reads or writes not set have the default limits
+type ClientRateLimits struct {
+	Client string     `json:"client"`
+	Reads  *RateLimit `json:"reads,omitempty"`
+	Writes *RateLimit `json:"writes,omitempty"`
+}
+
+// RateLimiter limits the requests of each client, by principal name for authenticated requests and by ip address
+// otherwise. Its file is reloaded when it changes, the clients keep their buckets.
+type RateLimiter struct {
+	lock    sync.Mutex
+	path    string
+	modTime time.Time
+	file    *RateLimitFile
+	// buckets are the buckets of the clients, by client and read or write
+	buckets map[bucketKey]*tokenBucket
+	now     func() time.Time
+}
+
+type bucketKey struct {
+	client string
+	write  bool
+}
+
+// tokenBucket holds the tokens left to a client at last, and the requests of the day
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	day    string
+	count  int
+}
+
+// errRateLimited is the error of the requests denied by a RateLimiter, RetryAfter is when to try again
+type errRateLimited struct {
+	message    string
+	RetryAfter time.Duration
+}
+
+func (e *errRateLimited) Error() string {
+	return e.message
+}
+
+// LoadRateLimiter reads the rate limit file at path
+func LoadRateLimiter(path string) (*RateLimiter, error) {
+	l := &RateLimiter{path: path, buckets: make(map[bucketKey]*tokenBucket), now: time.Now}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewRateLimiter returns the RateLimiter of a RateLimitFile
+func NewRateLimiter(file *RateLimitFile) (*RateLimiter, error) {
+	if err := file.validate(); err != nil {
+		return nil, err
+	}
+	return &RateLimiter{file: file, buckets: make(map[bucketKey]*tokenBucket), now: time.Now}, nil
+}
+
+// Watch polls the rate limit file every interval and reloads it when it is modified, until stop is closed.
+// A file that fails to load is logged and the previous limits are kept.
+func (l *RateLimiter) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(l.path)
+			if err != nil {
+				log.Errorf("failed to stat rate limit file %s: %+v", l.path, err)
+				continue
+			}
+			l.lock.Lock()
+			modified := !info.ModTime().Equal(l.modTime)
+			l.lock.Unlock()
+			if !modified {
+				continue
+			}
+			if err := l.reload(); err != nil {
+				log.Errorf("failed to reload rate limit file %s, keeping previous limits: %+v", l.path, err)
+				continue
+			}
+			log.Infof("Reloaded rate limit file %s", l.path)
+		}
+	}
+}
+
+func (l *RateLimiter) reload() error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+	file := &RateLimitFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return err
+	}
+	if err := file.validate(); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.file = file
+	l.modTime = info.ModTime()
+	return nil
+}
+
+func (f *RateLimitFile) validate() error {
+	if err := f.Reads.validate("reads"); err != nil {
+		return err
+	}
+	if err := f.Writes.validate("writes"); err != nil {
+		return err
+	}
+	clients := make(map[string]bool)
+	for i, c := range f.Clients {
+		if c.Client == "" || clients[c.Client] {
+			return fmt.Errorf("clients[%d]: client must be set and unique", i)
+		}
+		clients[c.Client] = true
+		if c.Reads != nil {
+			if err := c.Reads.validate(c.Client + ": reads"); err != nil {
+				return err
+			}
+		}
+		if c.Writes != nil {
+			if err := c.Writes.validate(c.Client + ": writes"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *RateLimit) validate(name string) error {
+	if r.Rate < 0 || r.Burst < 0 || r.DailyQuota < 0 {
+		return fmt.Errorf("%s: rate, burst and dailyQuota must not be negative", name)
+	}
+	if r.Burst > 0 && r.Rate == 0 {
+		return fmt.Errorf("%s: burst needs a rate", name)
+	}
+	return nil
+}
+
+// limit returns the limit of the reads or writes of client
+func (f *RateLimitFile) limit(client string, write bool) RateLimit {
+	for _, c := range f.Clients {
+		if c.Client != client {
+			continue
+		}
+		if write && c.Writes != nil {
+			return *c.Writes
+		}
+		if !write && c.Reads != nil {
+			return *c.Reads
+		}
+	}
+	if write {
+		return f.Writes
+	}
+	return f.Reads
+}
+
+func (r *RateLimit) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return math.Max(1, math.Ceil(r.Rate))
+}
+
+// Allow takes a token of the bucket of the reads or writes of client, and counts the request in its daily quota.
+// A denied request gets an error telling when to try again, and is not counted.
+func (l *RateLimiter) Allow(client string, write bool) error {
+	return l.allow(client, write, true)
+}
+
+// Check returns the error Allow would, without taking a token nor counting a request
+func (l *RateLimiter) Check(client string, write bool) error {
+	return l.allow(client, write, false)
+}
+
+func (l *RateLimiter) allow(client string, write, take bool) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	limit := l.file.limit(client, write)
+	if limit.Rate == 0 && limit.DailyQuota == 0 {
+		return nil
+	}
+	now := l.now()
+	if len(l.buckets) > maxIdleBuckets {
+		l.prune(now)
+	}
+	key := bucketKey{client: client, write: write}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: limit.burst(), last: now}
+		l.buckets[key] = bucket
+	}
+	kind := "reads"
+	if write {
+		kind = "writes"
+	}
+
+	day := now.UTC().Format("2006-01-02")
+	if bucket.day != day {
+		bucket.day, bucket.count = day, 0
+	}
+	if limit.DailyQuota > 0 && bucket.count >= limit.DailyQuota {
+		midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return &errRateLimited{message: fmt.Sprintf("daily quota of %d %s exceeded", limit.DailyQuota, kind), RetryAfter: midnight.Sub(now)}
+	}
+	if limit.Rate > 0 {
+		bucket.tokens = math.Min(limit.burst(), bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate)
+		bucket.last = now
+		if bucket.tokens < 1 {
+			wait := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+			return &errRateLimited{message: fmt.Sprintf("rate limit of %g %s per second exceeded", limit.Rate, kind), RetryAfter: wait}
+		}
+		if take {
+			bucket.tokens--
+		}
+	}
+	if take {
+		bucket.count++
+	}
+	return nil
+}
+
+// checkAuthentication returns an error when address is over the limits of its writes, for the credentials of a
+// client failing to authenticate not to be checked any more. Nothing is limited without RateLimiter.
+func (l *RateLimiter) checkAuthentication(address string) error {
+	if l == nil {
+		return nil
+	}
+	return l.Check(address, true)
+}
+
+// failedAuthentication charges a failed authentication to the writes of address
+func (l *RateLimiter) failedAuthentication(address string) {
+	if l == nil {
+		return
+	}
+	l.Allow(address, true)
+}
+
+// prune drops the buckets refilled since, their clients are back to the initial state.
+// The buckets counting a daily quota are kept until the next day.
+func (l *RateLimiter) prune(now time.Time) {
+	day := now.UTC().Format("2006-01-02")
+	for key, bucket := range l.buckets {
+		limit := l.file.limit(key.client, key.write)
+		refilled := limit.Rate == 0 || bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate >= limit.burst()
+		if refilled && (limit.DailyQuota == 0 || bucket.day != day) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *RateLimiter) forwardedFor() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.file.ForwardedFor
+}
+
+// rateLimit answers 429 to the requests of the clients over their limits
+func rateLimit(limiter *RateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client := requestClient(req, limiter.forwardedFor())
+		if err := limiter.Allow(client, mayWrite(req.Method, req.URL.Path)); err != nil {
+			log.Warnf("rate limited %s %s of %s: %+v", req.Method, req.URL.Path, client, err)
+			handleTooManyRequestsError(w, err)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// requestClient returns the principal name of a request, or its ip address when anonymous
+func requestClient(req *http.Request, forwardedFor bool) string {
+	if principal := PrincipalFromContext(req.Context()); principal != nil {
+		return principal.Name
+	}
+	if forwardedFor {
+		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	return remoteIP(req.RemoteAddr)
+}
+
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// retryAfterSeconds is the Retry-After of a rate limited request, in whole seconds rounded up
+func retryAfterSeconds(err error) string {
+	var limited *errRateLimited
+	if !errors.As(err, &limited) {
+		return "1"
+	}
+	return strconv.Itoa(int(math.Max(1, math.Ceil(limited.RetryAfter.Seconds()))))
+}
+
+// GrpcRateLimitInterceptors return the interceptors limiting gRPC calls like the http requests, Put, Update and
+// Delete being the writes. Chain them after the auth interceptors, for the calls to be limited by principal.
+func GrpcRateLimitInterceptors(limiter *RateLimiter) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := rateLimitGrpc(ctx, limiter, info.FullMethod, grpc.SetHeader); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		setHeader := func(_ context.Context, md metadata.MD) error {
+			return ss.SetHeader(md)
+		}
+		if err := rateLimitGrpc(ss.Context(), limiter, info.FullMethod, setHeader); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+	return unary, stream
+}
+
+// peerAddress returns the ip address of the client of a grpc call
+func peerAddress(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return remoteIP(p.Addr.String())
+	}
+	return ""
+}
+
+// rateLimitGrpc returns a ResourceExhausted error for the calls over their limits, with the retry-after header
+func rateLimitGrpc(ctx context.Context, limiter *RateLimiter, method string, setHeader func(context.Context, metadata.MD) error) error {
+	client := ""
+	if principal := PrincipalFromContext(ctx); principal != nil {
+		client = principal.Name
+	} else {
+		client = peerAddress(ctx)
+	}
+	if err := limiter.Allow(client, grpcWriteMethods[method]); err != nil {
+		log.Warnf("rate limited %s of %s: %+v", method, client, err)
+		setHeader(ctx, metadata.Pairs("retry-after", retryAfterSeconds(err)))
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return nil
+}
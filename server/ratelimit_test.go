@@ -0,0 +1,196 @@
+package server
+
+import (
+	"application_metadata_api_server/server/pb"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoadRateLimiter(t *testing.T) {
+	l, err := LoadRateLimiter("../testdata/ratelimit.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimit{Rate: 100, Burst: 200}, l.file.limit("ci", false))
+	assert.Equal(t, RateLimit{Rate: 2, Burst: 10, DailyQuota: 1000}, l.file.limit("other", true))
+
+	path := filepath.Join(t.TempDir(), "ratelimit.yaml")
+	for content, expected := range map[string]string{
+		"reads: {rate: -1}":                           "reads: rate, burst and dailyQuota must not be negative",
+		"writes: {burst: 10}":                         "writes: burst needs a rate",
+		"clients: [{reads: {rate: 1}}]":               "clients[0]: client must be set and unique",
+		"clients: [{client: ci, writes: {burst: 1}}]": "ci: writes: burst needs a rate",
+		"reads: {rate: 1, period: 1m}":                `error unmarshaling JSON: while decoding JSON: json: unknown field "period"`,
+	} {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+		_, err := LoadRateLimiter(path)
+		assert.EqualError(t, err, expected, content)
+	}
+
+	// the limits are replaced on reload, the buckets are kept
+	assert.Nil(t, ioutil.WriteFile(path, []byte("writes: {rate: 1}"), 0600))
+	l, err = LoadRateLimiter(path)
+	assert.Nil(t, err)
+	assert.Nil(t, l.Allow("ci", true))
+	assert.NotNil(t, l.Allow("ci", true))
+	assert.Nil(t, ioutil.WriteFile(path, []byte("writes: {rate: 1, burst: 3}"), 0600))
+	assert.Nil(t, l.reload())
+	assert.NotNil(t, l.Allow("ci", true))
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	l, err := NewRateLimiter(&RateLimitFile{
+		Reads:   RateLimit{Rate: 2, Burst: 4},
+		Writes:  RateLimit{Rate: 0.5, DailyQuota: 3},
+		Clients: []ClientRateLimits{{Client: "ci", Reads: &RateLimit{}}},
+	})
+	assert.Nil(t, err)
+	now := time.Date(2026, 10, 19, 23, 59, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	// Check takes no token
+	for i := 0; i < 8; i++ {
+		assert.Nil(t, l.Check("10.0.0.1", false))
+	}
+	// a burst of reads, then one every half second
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, l.Allow("10.0.0.1", false))
+	}
+	assert.EqualError(t, l.Check("10.0.0.1", false), "rate limit of 2 reads per second exceeded")
+	err = l.Allow("10.0.0.1", false)
+	assert.EqualError(t, err, "rate limit of 2 reads per second exceeded")
+	assert.Equal(t, 500*time.Millisecond, err.(*errRateLimited).RetryAfter)
+	assert.Equal(t, "1", retryAfterSeconds(err))
+	now = now.Add(500 * time.Millisecond)
+	assert.Nil(t, l.Allow("10.0.0.1", false))
+	// each client has its buckets, ci has no read limit
+	assert.Nil(t, l.Allow("10.0.0.2", false))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, l.Allow("ci", false))
+	}
+
+	// writes are limited apart from reads, the daily quota resets at midnight UTC
+	assert.Nil(t, l.Allow("10.0.0.1", true))
+	err = l.Allow("10.0.0.1", true)
+	assert.EqualError(t, err, "rate limit of 0.5 writes per second exceeded")
+	assert.Equal(t, "2", retryAfterSeconds(err))
+	now = now.Add(2 * time.Second)
+	assert.Nil(t, l.Allow("10.0.0.1", true))
+	now = now.Add(2 * time.Second)
+	assert.Nil(t, l.Allow("10.0.0.1", true))
+	now = now.Add(2 * time.Second)
+	err = l.Allow("10.0.0.1", true)
+	assert.EqualError(t, err, "daily quota of 3 writes exceeded")
+	assert.Equal(t, "54", retryAfterSeconds(err))
+	now = now.Add(time.Minute)
+	assert.Nil(t, l.Allow("10.0.0.1", true))
+}
+
+func TestRateLimiter_Prune(t *testing.T) {
+	l, err := NewRateLimiter(&RateLimitFile{Reads: RateLimit{Rate: 1}, Writes: RateLimit{DailyQuota: 1}})
+	assert.Nil(t, err)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	assert.Nil(t, l.Allow("a", false))
+	assert.Nil(t, l.Allow("b", true))
+	now = now.Add(time.Second)
+	l.prune(now)
+	// the refilled bucket is dropped, the quota of the day is kept
+	assert.Len(t, l.buckets, 1)
+	assert.NotNil(t, l.Allow("b", true))
+}
+
+func TestHttpServerImpl_Handler_RateLimit(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{{Name: "ci", SHA256: apiKeySHA256("ci")}}})
+	assert.Nil(t, err)
+	limiter, err := NewRateLimiter(&RateLimitFile{Reads: RateLimit{Rate: 1, Burst: 2}, Writes: RateLimit{Rate: 0.1}, ForwardedFor: true})
+	assert.Nil(t, err)
+	handler := NewHttpServer(WithAuthenticator(authn), WithRateLimiter(limiter)).Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	do := func(method, path, apiKey, forwardedFor, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(apiKeyHeader, apiKey)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// searches are reads, even posted
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/query", "ci", "", "title: a").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/apps?title=a", "ci", "", "").Code)
+	rr := do(http.MethodGet, "/apps?title=a", "ci", "", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), `"error_reason":"too many requests"`)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/put", "ci", "", string(data)).Code)
+	rr = do(http.MethodDelete, "/apps/1", "ci", "", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "10", rr.Header().Get("Retry-After"))
+
+	// anonymous clients are limited by address
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/apps/1", "", "10.0.0.1, 10.0.0.2", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/apps/1", "", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/apps/1", "", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/apps/1", "", "10.0.0.3", "").Code)
+
+	// failed authentications are charged to the writes of the address, its credentials are then not checked
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/apps/1", "guess", "10.0.0.4", "").Code)
+	rr = do(http.MethodGet, "/apps/1", "ci", "10.0.0.4", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "10", rr.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/apps/1", "", "10.0.0.4", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/apps/1", "guess", "10.0.0.5", "").Code)
+}
+
+func TestGrpcServerImpl_RateLimit(t *testing.T) {
+	limiter, err := NewRateLimiter(&RateLimitFile{Writes: RateLimit{Rate: 0.5}})
+	assert.Nil(t, err)
+	unary, stream := GrpcRateLimitInterceptors(limiter)
+	client := newGrpcTestClientWithServerOptions(t, []grpc.ServerOption{grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream)})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := client.Put(ctx, &pb.PutRequest{App: newTestProtoApp("grpc app")})
+	assert.Nil(t, err)
+	header := metadata.MD{}
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Id: resp.GetId()}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"2"}, header.Get("retry-after"))
+	// reads are not limited
+	_, err = client.Get(ctx, &pb.GetRequest{Id: resp.GetId()})
+	assert.Nil(t, err)
+}
+
+func TestGrpcAuthInterceptors_FailedAuthentications(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{{Name: "ci", SHA256: apiKeySHA256("secret")}}})
+	assert.Nil(t, err)
+	limiter, err := NewRateLimiter(&RateLimitFile{Writes: RateLimit{Rate: 0.5}})
+	assert.Nil(t, err)
+	unary, stream := GrpcAuthInterceptors(authn, limiter)
+	client := newGrpcTestClientWithServerOptions(t, []grpc.ServerOption{grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream)})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = client.Get(metadata.AppendToOutgoingContext(ctx, "x-api-key", "guess"), &pb.GetRequest{Id: "1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	header := metadata.MD{}
+	_, err = client.Put(metadata.AppendToOutgoingContext(ctx, "x-api-key", "secret"), &pb.PutRequest{App: newTestProtoApp("grpc app")}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"2"}, header.Get("retry-after"))
+	// the anonymous reads are not charged
+	_, err = client.Get(ctx, &pb.GetRequest{Id: "1"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,419 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// Role is the set of verbs a binding grants on the Apps of its scope
+type Role string
+
+const (
+	// RoleViewer may read the Apps of its scope
+	RoleViewer Role = "viewer"
+	// RoleEditor may read, create, update and delete the Apps of its scope
+	RoleEditor Role = "editor"
+	// RoleAdmin may do everything on every App, it has no scope
+	RoleAdmin Role = "admin"
+)
+
+// Verb is what a request does to an App
+type Verb string
+
+const (
+	VerbRead   Verb = "read"
+	VerbCreate Verb = "create"
+	VerbUpdate Verb = "update"
+	VerbDelete Verb = "delete"
+)
+
+// roleVerbs are the verbs granted by each role
+var roleVerbs = map[Role][]Verb{
+	RoleViewer: {VerbRead},
+	RoleEditor: {VerbRead, VerbCreate, VerbUpdate, VerbDelete},
+	RoleAdmin:  {VerbRead, VerbCreate, VerbUpdate, VerbDelete},
+}
+
+// RBACFile is the role-based access control file loaded by LoadAuthorizer, e.g.
+//
+//	filterSearch: true
+//	bindings:
+//	  - name: payments-team
+//	    role: editor
+//	    subjects:
+//	      groups: [payments]
+//	    scope:
+//	      company: Random Inc.
+//	      labels:
+//	        team: payments
+//	  - name: maintainers
+//	    role: editor
+//	    subjects:
+//	      names: ["*"]
+//	    scope:
+//	      maintainer: true
+//	  - name: platform
+//	    role: admin
+//	    subjects:
+//	      groups: [platform]
+type RBACFile struct {
+	// FilterSearch removes the Apps a principal may not read from its search results
+	FilterSearch bool          `json:"filterSearch,omitempty"`
+	Bindings     []RoleBinding `json:"bindings"`
+}
+
+// RoleBinding grants a role on the Apps of a scope to subjects
+type RoleBinding struct {
+	Name     string       `json:"name"`
+	Role     Role         `json:"role"`
+	Subjects RoleSubjects `json:"subjects"`
+	// Namespaces are the namespaces of the binding, it applies to every namespace when empty
+	Namespaces []string  `json:"namespaces,omitempty"`
+	Scope      RoleScope `json:"scope,omitempty"`
+}
+
+// RoleSubjects are the principals of a binding, by name or by group. The name "*" is every authenticated principal.
+type RoleSubjects struct {
+	Names  []string `json:"names,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// RoleScope selects the Apps of a binding, an App must match every condition set, an empty scope selects every App
+type RoleScope struct {
+	// Company is the company of the Apps, compared case-insensitively
+	Company string `json:"company,omitempty"`
+	// Labels are the labels the Apps must have
+	Labels map[string]string `json:"labels,omitempty"`
+	// Maintainer selects the Apps listing the email of the principal in their maintainers
+	Maintainer bool `json:"maintainer,omitempty"`
+}
+
+// Authorizer decides which principals may do what on which Apps, once they are authenticated
+type Authorizer interface {
+	// Authorize returns an error when principal, nil for anonymous requests, may not apply verb on app of namespace.
+	// An update is authorized on both the stored App and its new version.
+	Authorize(principal *Principal, namespace string, verb Verb, app *api.App) error
+	// AuthorizeAllNamespaces returns an error when principal may not search every namespace at once
+	AuthorizeAllNamespaces(principal *Principal) error
+	// FilterSearch tells if the search results are restricted to the Apps the principal may read
+	FilterSearch() bool
+}
+
+// FileAuthorizer is an Authorizer backed by an RBAC file, reloaded when the file changes
+type FileAuthorizer struct {
+	lock    sync.RWMutex
+	path    string
+	modTime time.Time
+	file    *RBACFile
+}
+
+// LoadAuthorizer reads the RBAC file at path
+func LoadAuthorizer(path string) (*FileAuthorizer, error) {
+	a := &FileAuthorizer{path: path}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// NewAuthorizer returns the Authorizer of an RBACFile
+func NewAuthorizer(file *RBACFile) (*FileAuthorizer, error) {
+	if err := file.validate(); err != nil {
+		return nil, err
+	}
+	return &FileAuthorizer{file: file}, nil
+}
+
+// Watch polls the RBAC file every interval and reloads it when it is modified, until stop is closed.
+// A file that fails to load is logged and the previous bindings are kept.
+func (a *FileAuthorizer) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(a.path)
+			if err != nil {
+				log.Errorf("failed to stat rbac file %s: %+v", a.path, err)
+				continue
+			}
+			a.lock.RLock()
+			modified := !info.ModTime().Equal(a.modTime)
+			a.lock.RUnlock()
+			if !modified {
+				continue
+			}
+			if err := a.reload(); err != nil {
+				log.Errorf("failed to reload rbac file %s, keeping previous bindings: %+v", a.path, err)
+				continue
+			}
+			log.Infof("Reloaded rbac file %s", a.path)
+		}
+	}
+}
+
+func (a *FileAuthorizer) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	file := &RBACFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return err
+	}
+	if err := file.validate(); err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.file = file
+	a.modTime = info.ModTime()
+	return nil
+}
+
+func (f *RBACFile) validate() error {
+	names := make(map[string]bool)
+	for i, b := range f.Bindings {
+		if b.Name == "" || names[b.Name] {
+			return fmt.Errorf("bindings[%d]: name must be set and unique", i)
+		}
+		names[b.Name] = true
+		if _, ok := roleVerbs[b.Role]; !ok {
+			return fmt.Errorf("%s: role must be one of %s, %s, %s", b.Name, RoleViewer, RoleEditor, RoleAdmin)
+		}
+		if len(b.Subjects.Names) == 0 && len(b.Subjects.Groups) == 0 {
+			return fmt.Errorf("%s: subjects must have names or groups", b.Name)
+		}
+		for _, namespace := range b.Namespaces {
+			if !namespacePattern.MatchString(namespace) {
+				return fmt.Errorf("%s: invalid namespace %q", b.Name, namespace)
+			}
+		}
+		if b.Role == RoleAdmin && !b.Scope.empty() {
+			return fmt.Errorf("%s: the admin role has no scope", b.Name)
+		}
+	}
+	return nil
+}
+
+func (a *FileAuthorizer) rbac() *RBACFile {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.file
+}
+
+func (a *FileAuthorizer) FilterSearch() bool {
+	return a.rbac().FilterSearch
+}
+
+// Authorize grants the request when a binding of the principal in namespace has a role with verb
+// and a scope matching app
+func (a *FileAuthorizer) Authorize(principal *Principal, namespace string, verb Verb, app *api.App) error {
+	if principal == nil {
+		return fmt.Errorf("anonymous requests may not %s apps", verb)
+	}
+	for _, b := range a.rbac().Bindings {
+		if b.Subjects.matches(principal) && b.inNamespace(namespace) && containsVerb(roleVerbs[b.Role], verb) &&
+			b.Scope.matches(principal, app) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s may not %s app %q in namespace %s", principal.Name, verb, app.Title, namespace)
+}
+
+// AuthorizeAllNamespaces grants the principals with an admin binding on every namespace
+func (a *FileAuthorizer) AuthorizeAllNamespaces(principal *Principal) error {
+	if principal == nil {
+		return errors.New("anonymous requests may not search every namespace")
+	}
+	for _, b := range a.rbac().Bindings {
+		if b.Role == RoleAdmin && len(b.Namespaces) == 0 && b.Subjects.matches(principal) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s may not search every namespace", principal.Name)
+}
+
+func (b *RoleBinding) inNamespace(namespace string) bool {
+	return len(b.Namespaces) == 0 || containsString(b.Namespaces, namespace)
+}
+
+func (s *RoleSubjects) matches(principal *Principal) bool {
+	for _, name := range s.Names {
+		if name == "*" || name == principal.Name {
+			return true
+		}
+	}
+	for _, group := range s.Groups {
+		if containsString(principal.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RoleScope) empty() bool {
+	return s.Company == "" && len(s.Labels) == 0 && !s.Maintainer
+}
+
+func (s *RoleScope) matches(principal *Principal, app *api.App) bool {
+	if s.Company != "" && !strings.EqualFold(s.Company, app.Company) {
+		return false
+	}
+	for k, v := range s.Labels {
+		if value, ok := app.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	if s.Maintainer {
+		email := principal.Email
+		if email == "" {
+			email = principal.Name
+		}
+		for _, m := range app.Maintainers {
+			if strings.EqualFold(m.Email, email) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func containsVerb(verbs []Verb, verb Verb) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks the principal of ctx may apply verb on app, everything is authorized without Authorizer
+func authorize(authz Authorizer, ctx context.Context, verb Verb, app *api.App) error {
+	if authz == nil {
+		return nil
+	}
+	err := authz.Authorize(PrincipalFromContext(ctx), NamespaceFromContext(ctx), verb, app)
+	if err != nil {
+		log.Warnf("%s: %+v", forbiddenMsg, err)
+	}
+	return err
+}
+
+// authorizeStored checks the principal of ctx may apply verb on the stored App id.
+// An App that does not exist is authorized, for the store to report it is not found.
+func authorizeStored(authz Authorizer, store cache.Store, ctx context.Context, verb Verb, id api.Id) error {
+	if authz == nil {
+		return nil
+	}
+	rawApp, err := store.Get(id)
+	if err != nil {
+		return nil
+	}
+	app, err := decodeToHub(rawApp)
+	if err != nil {
+		log.Errorf("failed to decode app %s for authorization: %+v", id, err)
+		return fmt.Errorf("app %s cannot be authorized", id)
+	}
+	return authorize(authz, ctx, verb, app)
+}
+
+// authorizeAllNamespaces checks the principal of ctx may search every namespace at once,
+// everything is authorized without Authorizer
+func authorizeAllNamespaces(authz Authorizer, ctx context.Context) error {
+	if authz == nil {
+		return nil
+	}
+	err := authz.AuthorizeAllNamespaces(PrincipalFromContext(ctx))
+	if err != nil {
+		log.Warnf("%s: %+v", forbiddenMsg, err)
+	}
+	return err
+}
+
+// authorizeUpdate checks the principal of ctx may update the stored App id, and may update it to app
+func authorizeUpdate(authz Authorizer, store cache.Store, ctx context.Context, id api.Id, app *api.App) error {
+	if err := authorizeStored(authz, store, ctx, VerbUpdate, id); err != nil {
+		return err
+	}
+	return authorize(authz, ctx, VerbUpdate, app)
+}
+
+// authorizeRead checks the principal of ctx may read app, when the Authorizer filters searches.
+// Reads are not authorized otherwise.
+func authorizeRead(authz Authorizer, ctx context.Context, app *api.App) error {
+	if authz == nil || !authz.FilterSearch() {
+		return nil
+	}
+	return authorize(authz, ctx, VerbRead, app)
+}
+
+// readable tells if the principal of ctx may read the raw App id, every App is readable
+// when the Authorizer does not filter searches
+func readable(authz Authorizer, ctx context.Context, id api.Id, rawApp []byte) bool {
+	if authz == nil || !authz.FilterSearch() {
+		return true
+	}
+	app, err := decodeToHub(rawApp)
+	if err != nil {
+		log.Errorf("failed to decode app %s for authorization: %+v", id, err)
+		return false
+	}
+	return authz.Authorize(PrincipalFromContext(ctx), NamespaceFromContext(ctx), VerbRead, app) == nil
+}
+
+// filterReadable returns the ids of the Apps the principal of ctx may read, when the Authorizer filters searches
+func filterReadable(authz Authorizer, store cache.Store, ctx context.Context, ids []api.Id) []api.Id {
+	if authz == nil || !authz.FilterSearch() {
+		return ids
+	}
+	rs := make([]api.Id, 0, len(ids))
+	for _, id := range ids {
+		rawApp, err := store.Get(id)
+		if err != nil {
+			// deleted meanwhile
+			continue
+		}
+		if readable(authz, ctx, id, rawApp) {
+			rs = append(rs, id)
+		}
+	}
+	return rs
+}
+
+// filterEvent returns the change of the store as seen by the principal of ctx, when the Authorizer filters searches.
+// An App becoming readable is ADDED, an App no longer readable is DELETED, the changes of unreadable Apps are skipped.
+func filterEvent(authz Authorizer, ctx context.Context, event cache.Event) (cache.Event, bool) {
+	if authz == nil || !authz.FilterSearch() {
+		return event, true
+	}
+	after := event.Raw != nil && readable(authz, ctx, event.Id, event.Raw)
+	before := event.Previous != nil && readable(authz, ctx, event.Id, event.Previous)
+	switch {
+	case after && !before:
+		event.Type = cache.EventAdded
+	case !after && before:
+		event.Type, event.Raw = cache.EventDeleted, nil
+	case !after && !before:
+		return event, false
+	}
+	return event, true
+}
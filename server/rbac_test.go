@@ -0,0 +1,230 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"application_metadata_api_server/server/pb"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoadAuthorizer(t *testing.T) {
+	a, err := LoadAuthorizer("../testdata/rbac.yaml")
+	assert.Nil(t, err)
+	assert.False(t, a.FilterSearch())
+
+	path := filepath.Join(t.TempDir(), "rbac.yaml")
+	for content, expected := range map[string]string{
+		"bindings: [{role: viewer, subjects: {names: [a]}}]":                                        "bindings[0]: name must be set and unique",
+		"bindings: [{name: a, role: owner, subjects: {names: [a]}}]":                                "a: role must be one of viewer, editor, admin",
+		"bindings: [{name: a, role: viewer}]":                                                       "a: subjects must have names or groups",
+		"bindings: [{name: a, role: admin, subjects: {names: [a]}, scope: {company: Random Inc.}}]": "a: the admin role has no scope",
+		"bindings: [{name: a, role: viewer, subjects: {names: [a]}, namespaces: [Payments]}]":       `a: invalid namespace "Payments"`,
+		"bindings: [{name: a, role: viewer, subjects: {names: [a]}, scope: {team: payments}}]":      `error unmarshaling JSON: while decoding JSON: json: unknown field "team"`,
+	} {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+		_, err := LoadAuthorizer(path)
+		assert.EqualError(t, err, expected, content)
+	}
+}
+
+func TestFileAuthorizer_Authorize(t *testing.T) {
+	a, err := LoadAuthorizer("../testdata/rbac.yaml")
+	assert.Nil(t, err)
+	random := &api.App{Title: "random", Company: "random inc.", Maintainers: []api.Maintainer{{Name: "bob", Email: "Bob@random.com"}}}
+	payments := &api.App{Title: "payments", Company: "Other", Labels: map[string]string{"team": "payments"}}
+
+	for _, tc := range []struct {
+		principal *Principal
+		verb      Verb
+		app       *api.App
+		allowed   bool
+	}{
+		{nil, VerbRead, random, false},
+		{&Principal{Name: "ci", Groups: []string{"random-inc"}}, VerbUpdate, random, true},
+		{&Principal{Name: "ci", Groups: []string{"random-inc"}}, VerbUpdate, payments, false},
+		{&Principal{Name: "ci", Groups: []string{"payments"}}, VerbDelete, payments, true},
+		{&Principal{Name: "bob", Email: "bob@random.com"}, VerbCreate, random, true},
+		{&Principal{Name: "bob@random.com"}, VerbUpdate, random, true},
+		{&Principal{Name: "bob", Email: "bob@random.com"}, VerbUpdate, payments, false},
+		{&Principal{Name: "eve", Groups: []string{"auditors"}}, VerbRead, payments, true},
+		{&Principal{Name: "eve", Groups: []string{"auditors"}}, VerbDelete, payments, false},
+		{&Principal{Name: "root", Groups: []string{"platform"}}, VerbDelete, payments, true},
+	} {
+		err := a.Authorize(tc.principal, cache.DefaultNamespace, tc.verb, tc.app)
+		assert.Equal(t, tc.allowed, err == nil, "%+v %s %s: %v", tc.principal, tc.verb, tc.app.Title, err)
+	}
+	err = a.Authorize(&Principal{Name: "eve"}, cache.DefaultNamespace, VerbCreate, payments)
+	assert.EqualError(t, err, `eve may not create app "payments" in namespace default`)
+}
+
+func TestHttpServerImpl_Handler_Authorization(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{
+		{Name: "random", SHA256: apiKeySHA256("random"), Groups: []string{"random-inc"}},
+		{Name: "other", SHA256: apiKeySHA256("other"), Groups: []string{"other-inc"}},
+	}})
+	assert.Nil(t, err)
+	authz, err := NewAuthorizer(&RBACFile{FilterSearch: true, Bindings: []RoleBinding{
+		{Name: "random", Role: RoleEditor, Subjects: RoleSubjects{Groups: []string{"random-inc"}}, Scope: RoleScope{Company: "Random Inc."}},
+		{Name: "other", Role: RoleEditor, Subjects: RoleSubjects{Groups: []string{"other-inc"}}, Scope: RoleScope{Company: "Other Inc."}},
+	}})
+	assert.Nil(t, err)
+	handler := NewHttpServer(WithAuthenticator(authn), WithAuthorizer(authz)).Handler()
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	app := string(data)
+	moved := strings.Replace(app, "company: Random Inc.", "company: Other Inc.", 1)
+
+	do := func(method, path, apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	for _, tc := range []struct {
+		method, path, apiKey, body string
+		expected                   int
+	}{
+		{http.MethodPost, "/apps", "other", app, http.StatusForbidden},
+		{http.MethodPost, "/apps", "random", app, http.StatusCreated},
+		{http.MethodPut, "/apps/1", "other", app, http.StatusForbidden},
+		// the stored App is in scope, its new version is not
+		{http.MethodPut, "/apps/1", "random", moved, http.StatusForbidden},
+		{http.MethodPatch, "/apps/1", "random", `{"company": "Other Inc."}`, http.StatusForbidden},
+		{http.MethodPut, "/apps/1", "random", app, http.StatusOK},
+		{http.MethodDelete, "/apps/1", "other", "", http.StatusForbidden},
+		{http.MethodPost, "/v1/put", "other", moved, http.StatusOK},
+	} {
+		rr := do(tc.method, tc.path, tc.apiKey, tc.body)
+		assert.Equal(t, tc.expected, rr.Code, "%s %s %s: %s", tc.method, tc.path, tc.apiKey, rr.Body.String())
+		if rr.Code == http.StatusForbidden {
+			assert.Contains(t, rr.Body.String(), `"error_reason":"forbidden"`)
+		}
+	}
+
+	// the search results are the Apps of the scope
+	search := func(apiKey string) []string {
+		rr := do(http.MethodGet, "/apps?version=1.0.1", apiKey, "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		resp := struct {
+			Ids []string `json:"result_list"`
+		}{}
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp.Ids
+	}
+	assert.Equal(t, []string{"1"}, search("random"))
+	assert.Equal(t, []string{"2"}, search("other"))
+	assert.Empty(t, search(""))
+
+	// so are the reads of a single App, the exports and the GraphQL app query
+	for _, tc := range []struct {
+		path, apiKey string
+		expected     int
+	}{
+		{"/apps/1", "random", http.StatusOK},
+		{"/apps/1", "other", http.StatusForbidden},
+		{"/v1/get?id=2", "random", http.StatusForbidden},
+		{"/v1/get?id=2", "other", http.StatusOK},
+		{"/apps/2", "", http.StatusForbidden},
+	} {
+		rr := do(http.MethodGet, tc.path, tc.apiKey, "")
+		assert.Equal(t, tc.expected, rr.Code, "%s %s: %s", tc.path, tc.apiKey, rr.Body.String())
+	}
+	rr := do(http.MethodGet, "/export", "random", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "company: Random Inc.")
+	assert.NotContains(t, rr.Body.String(), "company: Other Inc.")
+	rr = do(http.MethodGet, "/graphql?query="+url.QueryEscape(`{ app(id: "2") { title } }`), "random", "")
+	assert.Contains(t, rr.Body.String(), `random may not read app \"Valid App 1\" in namespace default`)
+	rr = do(http.MethodGet, "/graphql?query="+url.QueryEscape(`{ app(id: "2") { company } }`), "other", "")
+	assert.Contains(t, rr.Body.String(), `"company":"Other Inc."`)
+
+	// batch and import report the denied operations
+	rr = do(http.MethodPost, "/batch", "random", `{"operations": [{"op": "delete", "id": "2"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `forbidden: random may not delete app \"Valid App 1\" in namespace default`)
+	rr = do(http.MethodPost, "/import", "random", app+"\n---\n"+moved)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"imported":1,"failed":1`)
+
+	rr = do(http.MethodDelete, "/apps/1", "random", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func TestGrpcServerImpl_Authorization(t *testing.T) {
+	authn, err := NewAuthenticator(&AuthFile{APIKeys: []APIKey{{Name: "ci", SHA256: apiKeySHA256("secret")}}})
+	assert.Nil(t, err)
+	authz, err := NewAuthorizer(&RBACFile{Bindings: []RoleBinding{
+		{Name: "ci", Role: RoleEditor, Subjects: RoleSubjects{Names: []string{"ci"}}, Scope: RoleScope{Labels: map[string]string{"team": "ci"}}},
+	}})
+	assert.Nil(t, err)
+	unary, stream := GrpcAuthInterceptors(authn, nil)
+	client := newGrpcTestClientWithServerOptions(t, []grpc.ServerOption{grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream)},
+		WithAuthorizer(authz))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", "secret")
+
+	app := newTestProtoApp("grpc app")
+	_, err = client.Put(ctx, &pb.PutRequest{App: app})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	app.Labels = map[string]string{"team": "ci"}
+	resp, err := client.Put(ctx, &pb.PutRequest{App: app})
+	assert.Nil(t, err)
+	_, err = client.Delete(ctx, &pb.DeleteRequest{Id: resp.GetId()})
+	assert.Nil(t, err)
+}
+
+func TestFilterEvent(t *testing.T) {
+	authz, err := NewAuthorizer(&RBACFile{FilterSearch: true, Bindings: []RoleBinding{
+		{Name: "random", Role: RoleViewer, Subjects: RoleSubjects{Names: []string{"random"}}, Scope: RoleScope{Company: "Random Inc."}},
+	}})
+	assert.Nil(t, err)
+	ctx := withNamespace(withPrincipal(context.Background(), &Principal{Name: "random"}), cache.DefaultNamespace)
+	random := []byte("title: a\ncompany: Random Inc.\n")
+	other := []byte("title: a\ncompany: Other Inc.\n")
+
+	for _, tc := range []struct {
+		event    cache.Event
+		expected cache.EventType
+		ok       bool
+	}{
+		{cache.Event{Type: cache.EventAdded, Raw: random}, cache.EventAdded, true},
+		{cache.Event{Type: cache.EventAdded, Raw: other}, "", false},
+		{cache.Event{Type: cache.EventModified, Raw: random, Previous: random}, cache.EventModified, true},
+		// the App enters the scope of the principal
+		{cache.Event{Type: cache.EventModified, Raw: random, Previous: other}, cache.EventAdded, true},
+		// the App leaves the scope of the principal
+		{cache.Event{Type: cache.EventModified, Raw: other, Previous: random}, cache.EventDeleted, true},
+		{cache.Event{Type: cache.EventModified, Raw: other, Previous: other}, "", false},
+		{cache.Event{Type: cache.EventDeleted, Previous: random}, cache.EventDeleted, true},
+		{cache.Event{Type: cache.EventDeleted, Previous: other}, "", false},
+	} {
+		event, ok := filterEvent(authz, ctx, tc.event)
+		assert.Equal(t, tc.ok, ok, "%+v", tc.event)
+		if ok {
+			assert.Equal(t, tc.expected, event.Type, "%+v", tc.event)
+			assert.Equal(t, event.Type == cache.EventDeleted, event.Raw == nil, "%+v", tc.event)
+		}
+	}
+	event, ok := filterEvent(nil, ctx, cache.Event{Type: cache.EventAdded, Raw: other})
+	assert.True(t, ok)
+	assert.Equal(t, cache.EventAdded, event.Type)
+}
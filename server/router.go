@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// anyMethod matches every http method, it is used by the legacy endpoints
+const anyMethod = ""
+
+// route is an http method and a path pattern, segments in braces such as {id} are path parameters
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  http.HandlerFunc
+}
+
+// router dispatches requests to the route matching their method and path,
+// with 404 for unknown paths and 405 for known paths with another method
+type router struct {
+	routes []*route
+}
+
+type pathParamsKey struct{}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// handle registers a handler for a method (or anyMethod) and a path pattern
+func (r *router) handle(method, pattern string, handler http.HandlerFunc) {
+	r.routes = append(r.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handler:  handler,
+	})
+}
+
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	segments := splitPath(req.URL.Path)
+	allowed := make([]string, 0)
+	for _, rt := range r.routes {
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		if rt.method != anyMethod && rt.method != req.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+		if len(params) > 0 {
+			req = req.WithContext(context.WithValue(req.Context(), pathParamsKey{}, params))
+		}
+		rt.handler(w, req)
+		return
+	}
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		handleMethodNotAllowedError(w, fmt.Errorf("method %s is not allowed on %s", req.Method, req.URL.Path))
+		return
+	}
+	handleNotFoundError(w, fmt.Errorf("%s not found", req.URL.Path))
+}
+
+// patternOf returns the pattern of the route serving req, or of the first route matching its path with another
+// method, empty when no route matches
+func (r *router) patternOf(req *http.Request) string {
+	segments := splitPath(req.URL.Path)
+	pattern := ""
+	for _, rt := range r.routes {
+		if _, ok := rt.match(segments); !ok {
+			continue
+		}
+		if rt.method == anyMethod || rt.method == req.Method {
+			return rt.pattern
+		}
+		if pattern == "" {
+			pattern = rt.pattern
+		}
+	}
+	return pattern
+}
+
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, s := range rt.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// pathParam returns a path parameter of the matched route, e.g. pathParam(req, "id") for /apps/{id}
+func pathParam(req *http.Request, name string) string {
+	params, _ := req.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter_ServeHTTP(t *testing.T) {
+	r := newRouter()
+	r.handle(http.MethodGet, "/apps", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("list"))
+	})
+	r.handle(http.MethodGet, "/apps/{id}", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("get " + pathParam(req, "id")))
+	})
+	r.handle(http.MethodDelete, "/apps/{id}", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("delete " + pathParam(req, "id")))
+	})
+	r.handle(anyMethod, "/put", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("put"))
+	})
+
+	testCases := []struct {
+		name                 string
+		method               string
+		path                 string
+		expectedResponseCode int
+		expectedBody         string
+		expectedAllow        string
+	}{
+		{name: "static route", method: "GET", path: "/apps", expectedResponseCode: http.StatusOK, expectedBody: "list"},
+		{name: "trailing slash", method: "GET", path: "/apps/", expectedResponseCode: http.StatusOK, expectedBody: "list"},
+		{name: "path parameter", method: "GET", path: "/apps/12", expectedResponseCode: http.StatusOK, expectedBody: "get 12"},
+		{name: "method picks the route", method: "DELETE", path: "/apps/12", expectedResponseCode: http.StatusOK, expectedBody: "delete 12"},
+		{name: "any method", method: "PATCH", path: "/put", expectedResponseCode: http.StatusOK, expectedBody: "put"},
+		{name: "405 on known path", method: "POST", path: "/apps/12", expectedResponseCode: http.StatusMethodNotAllowed, expectedAllow: "DELETE, GET"},
+		{name: "404 on unknown path", method: "GET", path: "/apps/12/x", expectedResponseCode: http.StatusNotFound},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			resp := w.Result()
+			assert.Equal(t, test.expectedResponseCode, resp.StatusCode)
+			if test.expectedBody != "" {
+				assert.Equal(t, test.expectedBody, w.Body.String())
+			}
+			assert.Equal(t, test.expectedAllow, resp.Header.Get("Allow"))
+		})
+	}
+}
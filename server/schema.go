@@ -0,0 +1,264 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+	// emailPattern is shared by the "email" validate tag and the generated schema
+	emailPattern = `^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`
+	// notBlankPattern mirrors the "required" validate tag on strings, which trims spaces
+	notBlankPattern = `\S`
+)
+
+// JSONSchema is the subset of JSON Schema needed to describe App documents
+type JSONSchema struct {
+	Schema      string                 `json:"$schema,omitempty"`
+	Ref         string                 `json:"$ref,omitempty"`
+	Title       string                 `json:"title,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Type        string                 `json:"type,omitempty"`
+	Format      string                 `json:"format,omitempty"`
+	Pattern     string                 `json:"pattern,omitempty"`
+	MinItems    int                    `json:"minItems,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+	Items       *JSONSchema            `json:"items,omitempty"`
+	// AdditionalProperties is either false (structs) or the schema of map values
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+	// AnyOf is matched by documents valid against at least one of its schemas
+	AnyOf []*JSONSchema `json:"anyOf,omitempty"`
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// compiledPatterns caches the regexps of the schema patterns, which are the few patterns of the validate tags
+var compiledPatterns sync.Map
+
+func compilePattern(pattern string) *regexp.Regexp {
+	if re, ok := compiledPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, _ := compiledPatterns.LoadOrStore(pattern, regexp.MustCompile(pattern))
+	return re.(*regexp.Regexp)
+}
+
+// GenerateSchema builds a JSON Schema from a Go type, honouring "json" and "validate" tags
+func GenerateSchema(t reflect.Type, title string) *JSONSchema {
+	s := schemaForType(t, true)
+	s.Schema = jsonSchemaDraft
+	s.Title = title
+	return s
+}
+
+// schemaForType returns the schema of t. The "validate" tags of its fields are only turned into constraints when
+// validated, as the Validator only checks the fields of the structs it traverses: the App, and the fields with a
+// "validate" tag, e.g. the maintainers but not the author of the release.
+func schemaForType(t reflect.Type, validated bool) *JSONSchema {
+	if t == rawMessageType {
+		// embedded json documents can be anything
+		return &JSONSchema{}
+	}
+	if t == timeType {
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaForType(t.Elem(), validated)
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: schemaForType(t.Elem(), validated)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: schemaForType(t.Elem(), false)}
+	case reflect.Struct:
+		s := &JSONSchema{
+			Type:                 "object",
+			Properties:           make(map[string]*JSONSchema),
+			AdditionalProperties: false,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := getJSONName(field)
+			if name == "" {
+				continue
+			}
+			vTags := getValidateTags(field)
+			fieldSchema := schemaForType(field.Type, validated && len(vTags) > 0)
+			if !validated {
+				s.Properties[name] = fieldSchema
+				continue
+			}
+			for _, vTag := range vTags {
+				switch vTag {
+				case "required":
+					s.Required = append(s.Required, name)
+					switch fieldSchema.Type {
+					case "string":
+						fieldSchema.Pattern = notBlankPattern
+					case "array":
+						fieldSchema.MinItems = 1
+					}
+				case "email":
+					fieldSchema.Format = "email"
+					fieldSchema.Pattern = emailPattern
+				}
+			}
+			s.Properties[name] = fieldSchema
+		}
+		return s
+	}
+	return &JSONSchema{}
+}
+
+// getJSONName returns the json field name of a struct field, or "" if it is not serialized
+func getJSONName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	tag := getStructTag(f, "json")
+	if tag == "-" {
+		return ""
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// ValidateDocument validates a raw yaml or json document against the schema
+func (s *JSONSchema) ValidateDocument(raw []byte) error {
+	jsonData, err := yaml.YAMLToJSON(raw)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(jsonData, &doc); err != nil {
+		return err
+	}
+	return s.validate("", doc)
+}
+
+// validate checks a decoded json value against the schema, path is the json path for error messages
+func (s *JSONSchema) validate(path string, doc interface{}) error {
+	if doc == nil {
+		return nil
+	}
+	if len(s.AnyOf) > 0 {
+		var err error
+		for _, alt := range s.AnyOf {
+			if err = alt.validate(path, doc); err == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s matches none of the allowed schemas: %w", displayPath(path), err)
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", displayPath(path))
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s is required", joinPath(path, name))
+			}
+		}
+		// iterate in a stable order so the same document always reports the same error
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			propSchema, ok := s.Properties[k]
+			if !ok {
+				switch additional := s.AdditionalProperties.(type) {
+				case bool:
+					if !additional {
+						return fmt.Errorf("%s is not a known field", joinPath(path, k))
+					}
+					continue
+				case *JSONSchema:
+					propSchema = additional
+				default:
+					continue
+				}
+			}
+			if err := propSchema.validate(joinPath(path, k), obj[k]); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := doc.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", displayPath(path))
+		}
+		if len(arr) < s.MinItems {
+			return fmt.Errorf("%s must have at least %d item(s)", displayPath(path), s.MinItems)
+		}
+		for i, item := range arr {
+			if s.Items == nil {
+				break
+			}
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := doc.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", displayPath(path))
+		}
+		if s.Pattern != "" && !compilePattern(s.Pattern).MatchString(str) {
+			if s.Format != "" {
+				return fmt.Errorf("%s is not a valid %s", displayPath(path), s.Format)
+			}
+			return fmt.Errorf("%s does not match pattern %q", displayPath(path), s.Pattern)
+		}
+	case "boolean":
+		if _, ok := doc.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", displayPath(path))
+		}
+	case "integer", "number":
+		if _, ok := doc.(float64); !ok {
+			return fmt.Errorf("%s must be a %s", displayPath(path), s.Type)
+		}
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "document"
+	}
+	return path
+}
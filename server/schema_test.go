@@ -0,0 +1,116 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSchema(t *testing.T) {
+	schema := GenerateSchema(reflect.TypeOf(api.App{}), "App")
+
+	assert.Equal(t, "object", schema.Type)
+	assert.Equal(t, false, schema.AdditionalProperties)
+	assert.ElementsMatch(t, []string{"title", "version", "maintainers", "company", "website", "source", "license"}, schema.Required)
+	assert.Equal(t, 1, schema.Properties["maintainers"].MinItems)
+	assert.Equal(t, "email", schema.Properties["maintainers"].Items.Properties["email"].Format)
+	assert.ElementsMatch(t, []string{"name", "email"}, schema.Properties["maintainers"].Items.Required)
+	assert.Empty(t, schema.Properties["release"].Required)
+	// the validator does not traverse the release, so neither does the schema
+	assert.Empty(t, schema.Properties["release"].Properties["author"].Required)
+	assert.Empty(t, schema.Properties["release"].Properties["author"].Properties["email"].Pattern)
+	assert.Equal(t, &JSONSchema{Type: "string"}, schema.Properties["labels"].AdditionalProperties)
+}
+
+func TestJSONSchema_ValidateDocument(t *testing.T) {
+	testCases := []struct {
+		name                    string
+		filePath                string
+		doc                     string
+		expectedValidationError bool
+	}{
+		{
+			name:                    "valid payload 1, validation pass",
+			filePath:                "../testdata/valid-payload1.yaml",
+			expectedValidationError: false,
+		},
+		{
+			name:                    "valid payload 2, validation pass",
+			filePath:                "../testdata/valid-payload2.yaml",
+			expectedValidationError: false,
+		},
+		{
+			name:                    "missing version, validation fail",
+			filePath:                "../testdata/invalid-payload1.yaml",
+			expectedValidationError: true,
+		},
+		{
+			name:                    "invalid email, validation fail",
+			filePath:                "../testdata/invalid-payload2.yaml",
+			expectedValidationError: true,
+		},
+		{
+			name: "unknown field, validation fail",
+			doc: `title: t
+version: v
+maintainers:
+  - name: a
+    email: a@b.com
+company: c
+website: w
+source: s
+licence: l`,
+			expectedValidationError: true,
+		},
+		{
+			name: "wrong type, validation fail",
+			doc: `title: t
+version: v
+maintainers: a@b.com
+company: c
+website: w
+source: s
+license: l`,
+			expectedValidationError: true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(test.doc)
+			if test.filePath != "" {
+				var err error
+				data, err = ioutil.ReadFile(test.filePath)
+				assert.Nil(t, err)
+			}
+			err := schemas[defaultAPIVersion].ValidateDocument(data)
+			if test.expectedValidationError {
+				assert.NotNil(t, err)
+				t.Log(err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestJSONSchema_MatchesValidator(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	valid := string(data)
+	for _, doc := range []string{
+		valid,
+		strings.Replace(valid, "email: bob@google.com", "email: not an email", 1),
+		strings.Replace(valid, "    name: mary\n", "", 1),
+		strings.Replace(valid, "email: firstmaintainer@hotmail.com", "email: not an email", 1),
+		strings.Replace(valid, "title: Valid App 1", "title: ' '", 1),
+		strings.Replace(valid, "license:", "licence:", 1),
+	} {
+		_, validatorErr := newAppValidator().ValidatePut([]byte(doc))
+		schemaErr := schemas[defaultAPIVersion].ValidateDocument([]byte(doc))
+		assert.Equal(t, validatorErr == nil, schemaErr == nil, "%s\nvalidator: %v\nschema: %v", doc, validatorErr, schemaErr)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	v1 "application_metadata_api_server/server/api/v1"
+	v2 "application_metadata_api_server/server/api/v2"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// defaultAPIVersion is the apiVersion of documents that do not set one
+const defaultAPIVersion = v1.APIVersion
+
+// scheme maps every supported apiVersion to a constructor of its App type
+var scheme = map[string]func() api.Convertible{
+	v1.APIVersion: func() api.Convertible { return &v1.App{} },
+	v2.APIVersion: func() api.Convertible { return &v2.App{} },
+}
+
+// schemas is the JSON Schema of every supported apiVersion
+var schemas = generateSchemas()
+
+func generateSchemas() map[string]*JSONSchema {
+	rs := make(map[string]*JSONSchema)
+	for version, newApp := range scheme {
+		rs[version] = GenerateSchema(reflect.TypeOf(newApp()), fmt.Sprintf("App %s", version))
+	}
+	return rs
+}
+
+// typeMeta is the part of a document identifying its apiVersion
+type typeMeta struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+}
+
+func newVersionedApp(apiVersion string) (api.Convertible, error) {
+	newApp, ok := scheme[apiVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported apiVersion %q", apiVersion)
+	}
+	return newApp(), nil
+}
+
+// resolveAPIVersion returns the apiVersion of a document: the one it declares, otherwise the requested one,
+// otherwise the default. requested is the apiVersion of the request url, it is empty for unversioned urls.
+func resolveAPIVersion(req []byte, requested string) (string, error) {
+	meta := &typeMeta{}
+	if err := yaml.Unmarshal(req, meta); err != nil {
+		return "", err
+	}
+	if meta.Kind != "" && meta.Kind != api.Kind {
+		return "", fmt.Errorf("unsupported kind %q", meta.Kind)
+	}
+	switch {
+	case meta.APIVersion == "" && requested == "":
+		return defaultAPIVersion, nil
+	case meta.APIVersion == "":
+		return requested, nil
+	case requested != "" && meta.APIVersion != requested:
+		return "", fmt.Errorf("apiVersion %q does not match the requested apiVersion %q", meta.APIVersion, requested)
+	}
+	return meta.APIVersion, nil
+}
+
+// decodeToHub decodes a stored document of any apiVersion into the hub
+func decodeToHub(raw []byte) (*api.App, error) {
+	version, err := resolveAPIVersion(raw, "")
+	if err != nil {
+		return nil, err
+	}
+	obj, err := newVersionedApp(version)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(raw, obj); err != nil {
+		return nil, err
+	}
+	hub := &api.App{}
+	if err := obj.ConvertTo(hub); err != nil {
+		return nil, err
+	}
+	return hub, nil
+}
+
+// convertDocument converts a stored document to apiVersion, it is returned unchanged if already in that version
+func convertDocument(raw []byte, apiVersion string) ([]byte, error) {
+	current, err := resolveAPIVersion(raw, "")
+	if err != nil {
+		return nil, err
+	}
+	if current == apiVersion {
+		return raw, nil
+	}
+	hub, err := decodeToHub(raw)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := newVersionedApp(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := obj.ConvertFrom(hub); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(obj)
+}
+
+// apiVersionFromRequest returns the apiVersion of a versioned url such as /v2/put, or "" for unversioned urls
+func apiVersionFromRequest(req *http.Request) string {
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+	if len(parts) == 2 {
+		if _, ok := scheme[parts[0]]; ok {
+			return parts[0]
+		}
+	}
+	return ""
+}
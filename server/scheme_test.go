@@ -0,0 +1,107 @@
+package server
+
+import (
+	"application_metadata_api_server/server/api"
+	v1 "application_metadata_api_server/server/api/v1"
+	v2 "application_metadata_api_server/server/api/v2"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestResolveAPIVersion(t *testing.T) {
+	testCases := []struct {
+		name            string
+		doc             string
+		requested       string
+		expectedVersion string
+		expectedError   bool
+	}{
+		{name: "no apiVersion defaults to v1", doc: "title: t", expectedVersion: v1.APIVersion},
+		{name: "apiVersion from document", doc: "apiVersion: v2", expectedVersion: v2.APIVersion},
+		{name: "apiVersion from url", doc: "title: t", requested: v2.APIVersion, expectedVersion: v2.APIVersion},
+		{name: "same apiVersion in document and url", doc: "apiVersion: v2", requested: v2.APIVersion, expectedVersion: v2.APIVersion},
+		{name: "different apiVersion in document and url", doc: "apiVersion: v1", requested: v2.APIVersion, expectedError: true},
+		{name: "unknown kind", doc: "apiVersion: v1\nkind: Pod", expectedError: true},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			version, err := resolveAPIVersion([]byte(test.doc), test.requested)
+			if test.expectedError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, test.expectedVersion, version)
+			}
+		})
+	}
+}
+
+func TestAppValidator_ValidatePut_V2(t *testing.T) {
+	validator := newAppValidator(withSchemaValidation())
+	data, err := ioutil.ReadFile("../testdata/valid-payload3-v2.yaml")
+	assert.Nil(t, err)
+
+	app, validationErr := validator.ValidatePut(data)
+	assert.Nil(t, validationErr)
+	assert.Equal(t, "v2.0.0", app.Release.Name)
+	assert.Equal(t, []api.Release{{Name: "v1.0.0", Comment: "c1", Author: api.Maintainer{Name: "bob", Email: "bob@google.com"}}}, app.ReleaseHistory)
+	assert.Equal(t, []api.Dependency{{Name: "Valid App 1", Version: "1.0.1"}}, app.Dependencies)
+
+	// v2 fields are unknown to v1
+	_, _, validationErr = validator.ValidatePutWithOptions(data, PutOptions{APIVersion: v1.APIVersion})
+	assert.NotNil(t, validationErr)
+
+	// dependencies are validated when present
+	_, validationErr = validator.ValidatePut(bytes.Replace(data, []byte("  - name: Valid App 1\n"), []byte("  - "), 1))
+	assert.NotNil(t, validationErr)
+}
+
+func TestConvertDocument(t *testing.T) {
+	dataV1, err := ioutil.ReadFile("../testdata/valid-payload1.yaml")
+	assert.Nil(t, err)
+	dataV2, err := ioutil.ReadFile("../testdata/valid-payload3-v2.yaml")
+	assert.Nil(t, err)
+
+	// same version is returned unchanged
+	raw, err := convertDocument(dataV1, v1.APIVersion)
+	assert.Nil(t, err)
+	assert.Equal(t, dataV1, raw)
+
+	// v1 to v2 turns the release into a list
+	raw, err = convertDocument(dataV1, v2.APIVersion)
+	assert.Nil(t, err)
+	appV2 := &v2.App{}
+	assert.Nil(t, yaml.Unmarshal(raw, appV2))
+	assert.Equal(t, v2.APIVersion, appV2.APIVersion)
+	assert.Equal(t, api.Kind, appV2.Kind)
+	assert.Equal(t, []v2.Release{{Name: "xyz", Comment: "c1", Author: v2.Maintainer{Name: "mary", Email: "bob@google.com"}}}, appV2.Releases)
+
+	// v2 to v1 keeps the latest release and drops the dependencies
+	raw, err = convertDocument(dataV2, v1.APIVersion)
+	assert.Nil(t, err)
+	appV1 := &v1.App{}
+	assert.Nil(t, yaml.UnmarshalStrict(raw, appV1))
+	assert.Equal(t, "v2.0.0", appV1.Release.Name)
+
+	// v2 to hub and back is lossless
+	hub, err := decodeToHub(dataV2)
+	assert.Nil(t, err)
+	roundTrip := &v2.App{}
+	assert.Nil(t, roundTrip.ConvertFrom(hub))
+	original := &v2.App{}
+	assert.Nil(t, yaml.Unmarshal(dataV2, original))
+	assert.Equal(t, original, roundTrip)
+}
+
+func TestApiVersionFromRequest(t *testing.T) {
+	assert.Equal(t, "v2", apiVersionFromRequest(httptest.NewRequest("POST", "/v2/put", nil)))
+	assert.Equal(t, "v1", apiVersionFromRequest(httptest.NewRequest("GET", "/v1/get", nil)))
+	assert.Equal(t, "", apiVersionFromRequest(httptest.NewRequest("POST", "/put", nil)))
+	assert.Equal(t, "", apiVersionFromRequest(httptest.NewRequest("POST", "/v3/put", nil)))
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tlsNextProtos are the ALPN protocols of the servers, HTTP/2 first
+var tlsNextProtos = []string{"h2", "http/1.1"}
+
+// TLSFiles is the server certificate, and the optional CA bundle verifying the client certificates, of TLS files
+// reloaded when they change, so rotated certificates are served without restart
+type TLSFiles struct {
+	lock         sync.RWMutex
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	// modTimes are the modification times of the loaded files
+	modTimes []time.Time
+	// config is the config of the loaded files, served to every new connection
+	config *tls.Config
+}
+
+// LoadTLSFiles reads the certificate and key files and, when clientCAFile is set, the CA bundle verifying the
+// client certificates given by clients
+func LoadTLSFiles(certFile, keyFile, clientCAFile string) (*TLSFiles, error) {
+	f := &TLSFiles{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Config returns the TLS config of the http and grpc servers, each connection gets the files loaded last.
+// It offers HTTP/2.
+func (f *TLSFiles) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: tlsNextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &f.current().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return f.current(), nil
+		},
+	}
+}
+
+func (f *TLSFiles) current() *tls.Config {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.config
+}
+
+// Watch polls the files every interval and reloads them when one is modified, until stop is closed.
+// Files that fail to load, e.g. a certificate written before its key, are logged and the previous ones are kept.
+func (f *TLSFiles) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			modTimes, err := f.stat()
+			if err != nil {
+				log.Errorf("failed to stat tls files: %+v", err)
+				continue
+			}
+			f.lock.RLock()
+			modified := !equalTimes(modTimes, f.modTimes)
+			f.lock.RUnlock()
+			if !modified {
+				continue
+			}
+			if err := f.reload(); err != nil {
+				log.Errorf("failed to reload tls files, keeping previous certificates: %+v", err)
+				continue
+			}
+			log.Infof("Reloaded tls certificate %s", f.certFile)
+		}
+	}
+}
+
+func (f *TLSFiles) files() []string {
+	if f.clientCAFile == "" {
+		return []string{f.certFile, f.keyFile}
+	}
+	return []string{f.certFile, f.keyFile, f.clientCAFile}
+}
+
+func (f *TLSFiles) stat() ([]time.Time, error) {
+	rs := make([]time.Time, 0, 3)
+	for _, path := range f.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, info.ModTime())
+	}
+	return rs, nil
+}
+
+func (f *TLSFiles) reload() error {
+	modTimes, err := f.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12, NextProtos: tlsNextProtos}
+	if f.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(f.clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate in %s", f.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.config = config
+	f.modTimes = modTimes
+	return nil
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"application_metadata_api_server/server/pb"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA signs the test certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the pem certificate and key of a server certificate for 127.0.0.1, or of a client certificate
+func (ca *testCA) issue(t *testing.T, serial int64, name string, client bool) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeTLSFiles writes a server certificate of ca to the cert and key files, with a later modification time
+func writeTLSFiles(t *testing.T, ca *testCA, serial int64, certFile, keyFile string) {
+	certPEM, keyPEM := ca.issue(t, serial, "server", false)
+	assert.Nil(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+	modTime := time.Now().Add(time.Duration(serial) * time.Second)
+	assert.Nil(t, os.Chtimes(certFile, modTime, modTime))
+	assert.Nil(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func TestLoadTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	_, err := LoadTLSFiles(certFile, keyFile, "")
+	assert.True(t, os.IsNotExist(err))
+
+	ca := newTestCA(t, "ca")
+	writeTLSFiles(t, ca, 2, certFile, keyFile)
+	assert.Nil(t, ioutil.WriteFile(caFile, []byte("not a certificate"), 0600))
+	_, err = LoadTLSFiles(certFile, keyFile, caFile)
+	assert.EqualError(t, err, fmt.Sprintf("no certificate in %s", caFile))
+	_, err = LoadTLSFiles(certFile, caFile, "")
+	assert.NotNil(t, err)
+	_, err = LoadTLSFiles(certFile, keyFile, "")
+	assert.Nil(t, err)
+}
+
+func TestTLSFiles_Serve(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	ca, clientCA, otherCA := newTestCA(t, "ca"), newTestCA(t, "client ca"), newTestCA(t, "other ca")
+	writeTLSFiles(t, ca, 2, certFile, keyFile)
+	assert.Nil(t, ioutil.WriteFile(caFile, clientCA.pem, 0600))
+	files, err := LoadTLSFiles(certFile, keyFile, caFile)
+	assert.Nil(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	go files.Watch(10*time.Millisecond, stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := &http.Server{TLSConfig: files.Config(), Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client := "anonymous"
+		if len(req.TLS.VerifiedChains) > 0 {
+			client = req.TLS.VerifiedChains[0][0].Subject.CommonName
+		}
+		fmt.Fprintf(w, "%s %s", req.Proto, client)
+	})}
+	go srv.ServeTLS(listener, "", "")
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	get := func(clientCert *tls.Certificate) (string, *x509.Certificate, error) {
+		config := &tls.Config{RootCAs: roots}
+		if clientCert != nil {
+			// sent even when not issued by a CA the server asks for
+			config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return clientCert, nil
+			}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get("https://" + listener.Addr().String())
+		if err != nil {
+			return "", nil, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		return string(body), resp.TLS.PeerCertificates[0], nil
+	}
+
+	// HTTP/2 is negotiated, client certificates are optional and verified when given
+	body, cert, err := get(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/2.0 anonymous", body)
+	assert.Equal(t, int64(2), cert.SerialNumber.Int64())
+	clientCert, err := tls.X509KeyPair(clientCA.issue(t, 3, "deployer", true))
+	assert.Nil(t, err)
+	body, _, err = get(&clientCert)
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/2.0 deployer", body)
+	otherCert, err := tls.X509KeyPair(otherCA.issue(t, 4, "eve", true))
+	assert.Nil(t, err)
+	_, _, err = get(&otherCert)
+	assert.NotNil(t, err)
+
+	// a rotated certificate is served to the new connections
+	writeTLSFiles(t, ca, 5, certFile, keyFile)
+	assert.Eventually(t, func() bool {
+		_, cert, err := get(nil)
+		return err == nil && cert.SerialNumber.Int64() == 5
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// a broken key keeps the previous certificate
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte("broken"), 0600))
+	modTime := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(keyFile, modTime, modTime))
+	time.Sleep(50 * time.Millisecond)
+	_, cert, err = get(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), cert.SerialNumber.Int64())
+}
+
+func TestTLSFiles_Grpc(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ca := newTestCA(t, "ca")
+	writeTLSFiles(t, ca, 2, certFile, keyFile)
+	files, err := LoadTLSFiles(certFile, keyFile, "")
+	assert.Nil(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(files.Config())))
+	pb.RegisterAppServiceServer(srv, NewGrpcServer())
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: roots})))
+	assert.Nil(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := pb.NewAppServiceClient(conn).Put(ctx, &pb.PutRequest{App: newTestProtoApp("tls app")})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", resp.GetId())
+}
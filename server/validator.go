@@ -7,42 +7,144 @@ import (
 	"regexp"
 	"sigs.k8s.io/yaml"
 	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 // Validator is the interface to validate App schema field who has "validate" tag
 type Validator interface {
 	// ValidatePut validates any field of App who has "validate" tag, be it a struct or single field, also automatically validates nested structs
 	ValidatePut(req []byte) (api.App, ValidationError)
+	// ValidatePutWithOptions is ValidatePut with explicit PutOptions, in lenient mode unknown and duplicate keys
+	// are returned as warnings instead of failing the validation
+	ValidatePutWithOptions(req []byte, opts PutOptions) (api.App, []Warning, ValidationError)
+	// Lint reports non-fatal findings on an App that already passed validation
+	Lint(app *api.App) []Warning
 	// ValidateSearch validates if input is a App struct, but does not validate around "validate" tag
 	ValidateSearch(req []byte) (api.App, ValidationError)
 }
 
+// the rules broken by the validation errors, besides the "validate" tags such as required
+const (
+	// ruleDocument is broken by the documents that are not yaml or json, or exceed the decode limits
+	ruleDocument = "document"
+	// ruleAPIVersion is broken by an unknown apiVersion, or one that differs from the url's
+	ruleAPIVersion = "apiVersion"
+	// ruleStrict is broken by the unknown and duplicate keys of strict decoding
+	ruleStrict = "strict"
+	// ruleSchema is broken by the documents the JSON Schema rejects
+	ruleSchema = "schema"
+)
+
+// PutOptions controls how a put request is decoded
+type PutOptions struct {
+	Mode DecodeMode
+	// APIVersion is the apiVersion requested by the url, the document may omit it or must declare the same one.
+	// When empty the document's own apiVersion is used, defaulting to v1.
+	APIVersion string
+}
+
 // appValidator is an implementation of Validator
 type appValidator struct {
 	validators map[string]func(name string, obj interface{}) (bool, error)
+	// schemaValidation enforces the JSON Schema of the document's apiVersion before the "validate" tags
+	schemaValidation bool
+	// maxDescriptionBytes is the description size above which Lint reports a warning
+	maxDescriptionBytes int
+}
+
+// validatorOption configures an appValidator
+type validatorOption func(v *appValidator)
+
+// withSchemaValidation makes ValidatePut check the raw document against the JSON Schema of its apiVersion
+func withSchemaValidation() validatorOption {
+	return func(v *appValidator) {
+		v.schemaValidation = true
+	}
+}
+
+// NewValidator returns the put validation of the server, configured by the validation Options
+// (WithSchemaValidation, WithMaxDescriptionSize), e.g. to validate documents offline
+func NewValidator(opts ...Option) Validator {
+	o := &serverOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return newAppValidator(o.validatorOpts...)
 }
 
-func newAppValidator() Validator {
-	return &appValidator{
+func newAppValidator(opts ...validatorOption) Validator {
+	v := &appValidator{
 		validators: map[string]func(name string, obj interface{}) (bool, error){
 			"email":    isEmailValid,
 			"required": isRequired,
+			// dive has no check of its own, it only makes the nested fields of an optional field validated
+			"dive": isAny,
 		},
+		maxDescriptionBytes: DefaultMaxDescriptionBytes,
 	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
 }
 
 func (v *appValidator) ValidatePut(req []byte) (api.App, ValidationError) {
+	app, _, err := v.ValidatePutWithOptions(req, PutOptions{Mode: DecodeStrict})
+	return app, err
+}
+
+// ValidatePutWithOptions decodes the document into the App type of its apiVersion, validates it,
+// and converts it to the hub api.App
+func (v *appValidator) ValidatePutWithOptions(req []byte, opts PutOptions) (api.App, []Warning, ValidationError) {
 	app := &api.App{}
-	if err := yaml.Unmarshal(req, app); err != nil {
-		return *app, NewInvalidSpec(err)
+	root, err := parseDocument(req)
+	if err != nil {
+		return *app, nil, newRuleViolation(ruleDocument, err)
+	}
+	version, err := resolveAPIVersion(req, opts.APIVersion)
+	if err != nil {
+		return *app, nil, newRuleViolation(ruleAPIVersion, err)
+	}
+	obj, err := newVersionedApp(version)
+	if err != nil {
+		return *app, nil, newRuleViolation(ruleAPIVersion, err)
+	}
+	warnings := checkKeys(root, reflect.TypeOf(obj))
+	if opts.Mode == DecodeStrict && len(warnings) > 0 {
+		return *app, nil, newRuleViolation(ruleStrict, fmt.Errorf("%s", joinWarnings(warnings)))
+	}
+	if err := yaml.Unmarshal(req, obj); err != nil {
+		return *app, warnings, newRuleViolation(ruleDocument, err)
+	}
+	if v.schemaValidation {
+		doc := req
+		if len(warnings) > 0 {
+			// validate what is going to be indexed, the unknown keys are already reported as warnings.
+			// The values are kept as written, re-encoding the decoded App would add its zero optional fields.
+			dropUnknownKeys(root, reflect.TypeOf(obj))
+			if doc, err = yamlv3.Marshal(root); err != nil {
+				return *app, warnings, newRuleViolation(ruleSchema, err)
+			}
+		}
+		if err := schemas[version].ValidateDocument(doc); err != nil {
+			return *app, warnings, newRuleViolation(ruleSchema, err)
+		}
+	}
+	if validationErr := v.traverseField(reflect.ValueOf(obj)); validationErr != nil {
+		return *app, warnings, validationErr
 	}
-	value := reflect.ValueOf(app)
-	validationErr := v.traverseField(value)
-	return *app, validationErr
+	if err := obj.ConvertTo(app); err != nil {
+		return *app, warnings, NewInvalidSpec(err)
+	}
+	return *app, warnings, nil
 }
 
 func (v *appValidator) ValidateSearch(req []byte) (api.App, ValidationError) {
 	app := &api.App{}
+	if err := checkDocument(req); err != nil {
+		return *app, NewInvalidSpec(err)
+	}
 	if err := yaml.Unmarshal(req, app); err != nil {
 		return *app, NewInvalidSpec(err)
 	}
@@ -60,7 +162,7 @@ func (v *appValidator) validateStruct(cur reflect.Value) ValidationError {
 			if ok {
 				_, err := handlerFn(field.Name, valueField.Interface())
 				if err != nil {
-					return NewInvalidSpec(err)
+					return newRuleViolation(vTag, err)
 				}
 			}
 		}
@@ -100,9 +202,10 @@ func (v *appValidator) traverseField(cur reflect.Value) ValidationError {
 	return nil
 }
 
+var emailRegex = regexp.MustCompile(emailPattern)
+
 func isEmailValid(name string, obj interface{}) (bool, error) {
 	e := obj.(string)
-	emailRegex := regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
 	v := emailRegex.MatchString(e)
 	if !v {
 		return v, fmt.Errorf("%s email is invalid", name)
@@ -144,3 +247,7 @@ func notEmpty(obj interface{}) bool {
 		return value.IsValid() && value.Interface() != reflect.Zero(value.Type()).Interface()
 	}
 }
+
+func isAny(name string, obj interface{}) (bool, error) {
+	return true, nil
+}
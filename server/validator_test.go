@@ -127,3 +127,108 @@ func TestAppValidator_ValidateSearch(t *testing.T) {
 	}
 
 }
+
+func TestAppValidator_ValidatePutWithOptions(t *testing.T) {
+	validator := newAppValidator()
+	validBody := `title: t
+version: v
+maintainers:
+  - name: a
+    email: a@b.com
+company: c
+website: w
+source: s
+license: l
+`
+
+	testCases := []struct {
+		name                    string
+		body                    string
+		mode                    DecodeMode
+		expectedValidationError bool
+		expectedWarnings        []Warning
+	}{
+		{
+			name:             "valid input in strict mode, validation pass",
+			body:             validBody,
+			mode:             DecodeStrict,
+			expectedWarnings: []Warning{},
+		},
+		{
+			name:                    "unknown field in strict mode, validation fail",
+			body:                    validBody + "licence: l\n",
+			mode:                    DecodeStrict,
+			expectedValidationError: true,
+		},
+		{
+			name:                    "duplicate key in strict mode, validation fail",
+			body:                    validBody + "title: t2\n",
+			mode:                    DecodeStrict,
+			expectedValidationError: true,
+		},
+		{
+			name:                    "unknown nested field in strict mode, validation fail",
+			body:                    validBody + "release:\n  nmae: r\n",
+			mode:                    DecodeStrict,
+			expectedValidationError: true,
+		},
+		{
+			name: "unknown and duplicate keys in lenient mode, validation pass with warnings",
+			body: validBody + "licence: l\ntitle: t2\nrelease:\n  nmae: r\n",
+			mode: DecodeLenient,
+			expectedWarnings: []Warning{
+				{Line: 10, Column: 1, Field: "licence", Message: `unknown field "licence"`},
+				{Line: 11, Column: 1, Field: "title", Message: `duplicate key "title" (first defined at line 1)`},
+				{Line: 13, Column: 3, Field: "release.nmae", Message: `unknown field "release.nmae"`},
+			},
+		},
+		{
+			name:                    "unknown field in lenient mode still needs required fields",
+			body:                    "title: t\nlicence: l\n",
+			mode:                    DecodeLenient,
+			expectedValidationError: true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, warnings, err := validator.ValidatePutWithOptions([]byte(test.body), PutOptions{Mode: test.mode})
+			if test.expectedValidationError {
+				assert.NotNil(t, err)
+				t.Log(err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, test.expectedWarnings, warnings)
+			}
+		})
+	}
+}
+
+func TestAppValidator_ValidatePutWithOptions_SchemaValidation(t *testing.T) {
+	validator := newAppValidator(withSchemaValidation())
+	validBody := `title: t
+version: v
+maintainers:
+  - name: a
+    email: a@b.com
+company: c
+website: w
+source: s
+license: l
+`
+
+	for _, mode := range []DecodeMode{DecodeStrict, DecodeLenient} {
+		_, _, err := validator.ValidatePutWithOptions([]byte(validBody), PutOptions{Mode: mode})
+		assert.Nil(t, err)
+	}
+	// the schema checks the document without its unknown keys
+	_, warnings, err := validator.ValidatePutWithOptions([]byte(validBody+"licence: l\nrelease:\n  nmae: r\n"), PutOptions{Mode: DecodeLenient})
+	assert.Nil(t, err)
+	assert.Len(t, warnings, 2)
+	// and with the last definition of the duplicate keys, the one decoded
+	_, _, err = validator.ValidatePutWithOptions([]byte(validBody+"license: ' '\n"), PutOptions{Mode: DecodeLenient})
+	assert.EqualError(t, err, "license does not match pattern \"\\\\S\"")
+	assert.Equal(t, ruleSchema, validationRule(err))
+	_, _, err = validator.ValidatePutWithOptions([]byte("license: ' '\n"+validBody), PutOptions{Mode: DecodeLenient})
+	assert.Nil(t, err)
+}
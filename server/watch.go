@@ -0,0 +1,99 @@
+package server
+
+import (
+	"application_metadata_api_server/cache"
+	"application_metadata_api_server/server/api"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// watchEventError is the type of the last event of a watch which fell behind the changes of the store
+const watchEventError = "ERROR"
+
+// WatchEvent is a line of the watch stream. App is the json App after the change, in the apiVersion of the url
+// (unset for DELETED), Error is only set on ERROR events.
+type WatchEvent struct {
+	Type     string          `json:"type"`
+	Id       api.Id          `json:"id,omitempty"`
+	Revision int64           `json:"revision,omitempty"`
+	App      json.RawMessage `json:"app,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func (h *httpServerImpl) WatchHandler(w http.ResponseWriter, req *http.Request) {
+	if _, ok := negotiate(req, mediaTypeNDJSON); !ok {
+		handleNotAcceptableError(w, fmt.Errorf("no acceptable media type in %q, expecting %s", req.Header.Get("Accept"), mediaTypeNDJSON))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleInternalError(w, fmt.Errorf("%T cannot flush", w), "streaming is not supported")
+		return
+	}
+	store, ok := h.lookupStore(req.Context())
+	if !ok {
+		handleNotFoundError(w, errNamespaceNotFound(NamespaceFromContext(req.Context())))
+		return
+	}
+	version := apiVersionFromRequest(req)
+	events, cancel := store.Watch()
+	defer cancel()
+	// the headers tell the client the watch is registered, the changes from now on are sent
+	w.Header().Set("Content-Type", mediaTypeNDJSON)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-h.shutdown:
+			encoder.Encode(WatchEvent{Type: watchEventError, Error: shuttingDownMsg + ", watch again"})
+			flusher.Flush()
+			return
+		case event, ok := <-events:
+			if !ok {
+				encoder.Encode(WatchEvent{Type: watchEventError, Error: "watch fell behind the changes of the store, get the apps and watch again"})
+				flusher.Flush()
+				return
+			}
+			event, ok = filterEvent(h.authz, req.Context(), event)
+			if !ok {
+				continue
+			}
+			line, err := watchEventFrom(event, version)
+			if err != nil {
+				log.Errorf("failed to convert app %s: %+v", event.Id, err)
+				continue
+			}
+			if err := encoder.Encode(line); err != nil {
+				log.Warnf("watch interrupted: %+v", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func watchEventFrom(event cache.Event, version string) (WatchEvent, error) {
+	line := WatchEvent{Type: string(event.Type), Id: event.Id, Revision: event.Revision}
+	if event.Raw == nil {
+		return line, nil
+	}
+	raw := event.Raw
+	var err error
+	if version != "" {
+		if raw, err = convertDocument(raw, version); err != nil {
+			return line, err
+		}
+	}
+	if line.App, err = yaml.YAMLToJSON(raw); err != nil {
+		return line, err
+	}
+	return line, nil
+}